@@ -3,31 +3,68 @@ package main
 import (
 	"context"
 	"delayed-notifier/internal/config"
+	"delayed-notifier/internal/notification/breaker"
 	"delayed-notifier/internal/notification/cache"
+	"delayed-notifier/internal/notification/digest"
+	"delayed-notifier/internal/notification/memory/broker"
+	"delayed-notifier/internal/notification/outbox"
+	"delayed-notifier/internal/notification/rabbitmq/connection"
 	"delayed-notifier/internal/notification/rabbitmq/handler"
 	"delayed-notifier/internal/notification/rabbitmq/notifier"
+	"delayed-notifier/internal/notification/ratelimit"
+	"delayed-notifier/internal/notification/reconciler"
+	"delayed-notifier/internal/notification/redis/scheduler"
+	"delayed-notifier/internal/notification/repo/memory"
 	"delayed-notifier/internal/notification/repo/postgres"
 	"delayed-notifier/internal/notification/rest"
 	"delayed-notifier/internal/notification/senders"
 	"delayed-notifier/internal/notification/service"
+	"delayed-notifier/internal/notification/throttle"
 	"delayed-notifier/internal/notification/worker"
 	"delayed-notifier/internal/validator"
 	"delayed-notifier/pkg/clients/email"
 	"delayed-notifier/pkg/db"
+	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"github.com/wb-go/wbf/dbpg"
 	"github.com/wb-go/wbf/ginext"
-	"github.com/wb-go/wbf/rabbitmq"
 	"github.com/wb-go/wbf/redis"
 	"github.com/wb-go/wbf/retry"
 	"github.com/wb-go/wbf/zlog"
 	"net/http"
+	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
 
 const workersCount = 5
 
+const defaultShutdownTimeout = 30 * time.Second
+
+const (
+	backendRabbitMQ = "rabbitmq"
+	backendRedis    = "redis"
+	backendMemory   = "memory"
+)
+
+// Имена зависимостей в /readyz
+const (
+	dependencyPostgres = "postgres"
+	dependencyRabbitMQ = "rabbitmq"
+)
+
+// notificationScheduler - бэкенд планирования: публикует отложенные уведомления
+// и отдаёт их воркерам, когда наступает время отправки.
+type notificationScheduler interface {
+	service.Notifier
+	worker.NotifConsumer
+	rest.DeadLetters
+}
+
 func main() {
 	// Initialize logger
 	zlog.Init()
@@ -40,58 +77,177 @@ func main() {
 	cfg := config.MustLoad()
 	fmt.Printf("SMTP Config: %+v\n", cfg.SMTP)
 
-	// Connect to DB
-	DB, err := db.OpenDB(cfg.DB)
-	if err != nil {
-		zlog.Logger.Fatal().Err(err).Msg("failed to connect to DB")
-	}
+	var (
+		DB          *dbpg.DB
+		redisClient *redis.Client
+		rabbitConn  *connection.Manager
+		repo        service.Repo
+		c           service.Cache
+		notifierr   notificationScheduler
+		emailSender senders.NotificationSender
+		limiter     handler.RateLimiter
+		throttler   senders.Throttle
+		background  sync.WaitGroup // фоновые задачи, которые используют соединения
+	)
 
-	// Connect to RabbitMQ
-	conn, err := rabbitmq.Connect(cfg.RabbitMQ.Url(), cfg.RabbitMQ.Retries, cfg.RabbitMQ.Pause)
+	// Initialize rate limit rules
+	rateLimitRules, err := ratelimit.ParseRules(cfg.RateLimit.Rules)
 	if err != nil {
-		if err != nil {
-			zlog.Logger.Fatal().Err(err).Msg("failed to connect to RabbitMQ")
-		}
+		zlog.Logger.Fatal().Err(err).Msg("failed to parse rate limits")
 	}
-
-	channel, err := conn.Channel()
+	rateLimitPolicy, err := ratelimit.ParsePolicy(cfg.RateLimit.Policy)
 	if err != nil {
-		zlog.Logger.Fatal().Err(err).Msg("failed to open server channel")
+		zlog.Logger.Fatal().Err(err).Msg("failed to parse rate limit policy")
 	}
 
-	// Create notifier.Notifier
-	notifierOpts := notifier.Opts{
-		Exchange:   cfg.RabbitMQ.Exchange,
-		RoutingKey: cfg.RabbitMQ.RoutingKey,
-		Queue:      cfg.RabbitMQ.Queue,
-		DLQ:        cfg.RabbitMQ.DLQ,
+	// Initialize notification cache options
+	cacheOpts := cache.Opts{
+		KeyPrefix:   cfg.Cache.KeyPrefix,
+		ActiveTTL:   cfg.Cache.ActiveTTL,
+		TerminalTTL: cfg.Cache.TerminalTTL,
 	}
-	notifierr, err := notifier.New(channel, notifierOpts)
+
+	// Initialize provider throughput limits
+	throttleLimits, err := throttle.ParseLimits(cfg.Throttle.Rates, cfg.Throttle.Concurrency)
 	if err != nil {
-		zlog.Logger.Fatal().Err(err).Msg("failed to create notifier")
+		zlog.Logger.Fatal().Err(err).Msg("failed to parse send throttle limits")
 	}
 
-	// Connect to Redis
-	redisClient := redis.New(cfg.Redis.Addr(), cfg.Redis.Password, cfg.Redis.DB)
-
-	if err = redisClient.Ping(ctx).Err(); err != nil {
-		zlog.Logger.Fatal().Err(err).Msg("failed to ping redis")
+	// Элементы дайджеста не подтверждаются до сброса и занимают prefetch сверх очереди воркеров
+	digestCapacity := 0
+	if cfg.Digest.Window > 0 {
+		if cfg.Digest.Capacity <= 0 {
+			zlog.Logger.Fatal().Int("digest_capacity", cfg.Digest.Capacity).Msg("DIGEST_CAPACITY must be positive when digests are enabled")
+		}
+		digestCapacity = cfg.Digest.Capacity
 	}
 
 	// Initialize email client
-	emailClient := email.New(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.From)
+	emailSender = email.New(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.From)
 
-	// Initialize notification senders
-	notificationSenders := senders.New(emailClient)
+	if cfg.Scheduler.Backend == backendMemory {
+		// Всё в памяти процесса: без Postgres, Redis и RabbitMQ
+		repo = memory.New()
+		c = cache.NewMemory(cacheOpts)
+		notifierr = broker.New(broker.Opts{Tick: cfg.Scheduler.PollInterval})
+		limiter = ratelimit.NewMemory(rateLimitRules)
+		throttler = throttle.NewMemory(throttleLimits)
 
-	// Initialize notification repo
-	repo := postgres.New(DB)
+		if cfg.SMTP.Host == "" {
+			emailSender = senders.LogSender{}
+		}
+	} else {
+		// Connect to DB
+		DB, err = db.OpenDB(cfg.DB)
+		if err != nil {
+			zlog.Logger.Fatal().Err(err).Msg("failed to connect to DB")
+		}
+
+		// Connect to Redis
+		redisClient = redis.New(cfg.Redis.Addr(), cfg.Redis.Password, cfg.Redis.DB)
+
+		if err = redisClient.Ping(ctx).Err(); err != nil {
+			zlog.Logger.Fatal().Err(err).Msg("failed to ping redis")
+		}
+
+		// Initialize notification repo
+		repo = postgres.New(DB)
+
+		// Initialize notification cache; a local LRU in front of Redis unless disabled
+		redisCache := cache.New(redisClient, cacheOpts)
+		c = redisCache
+		if cfg.Cache.LocalSize > 0 {
+			tieredCache := cache.NewTiered(redisCache, redisClient, cache.TieredOpts{
+				Size:    cfg.Cache.LocalSize,
+				TTL:     cfg.Cache.LocalTTL,
+				Channel: cacheOpts.InvalidationChannel(),
+			})
+			c = tieredCache
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				tieredCache.Start(ctx)
+			}()
+		}
+
+		// Initialize per-recipient rate limiter
+		limiter = ratelimit.New(redisClient, rateLimitRules, cfg.RateLimit.KeyPrefix)
+
+		// Initialize provider throughput throttle
+		throttler = throttle.New(redisClient, throttleLimits, cfg.Throttle.KeyPrefix, cfg.Throttle.ConnLease)
+
+		// Initialize notification scheduler backend
+		switch cfg.Scheduler.Backend {
+		case backendRedis:
+			redisScheduler := scheduler.New(redisClient, scheduler.Opts{
+				KeyPrefix:         cfg.Scheduler.KeyPrefix,
+				PollInterval:      cfg.Scheduler.PollInterval,
+				VisibilityTimeout: cfg.Scheduler.VisibilityTimeout,
+				BatchSize:         cfg.Scheduler.BatchSize,
+			})
+			// Элементы дайджеста не подтверждаются до сброса: с окном длиннее таймаута
+			// видимости они доставлялись бы повторно и попадали в дайджест дважды
+			if cfg.Digest.Window > 0 && cfg.Digest.Window >= redisScheduler.VisibilityTimeout() {
+				zlog.Logger.Fatal().
+					Dur("digest_window", cfg.Digest.Window).
+					Dur("visibility_timeout", redisScheduler.VisibilityTimeout()).
+					Msg("DIGEST_WINDOW must be shorter than SCHEDULER_VISIBILITY_TIMEOUT")
+			}
+			notifierr = redisScheduler
+		case backendRabbitMQ, "":
+			rabbitConn = connection.New(cfg.RabbitMQ.Url(), connection.Opts{
+				MinBackoff: cfg.RabbitMQ.Pause,
+				MaxBackoff: cfg.RabbitMQ.MaxPause,
+			})
+
+			// Create notifier.Notifier
+			notifierOpts := notifier.Opts{
+				Exchange:       cfg.RabbitMQ.Exchange,
+				RoutingKey:     cfg.RabbitMQ.RoutingKey,
+				Queue:          cfg.RabbitMQ.PriorityQueue,
+				LegacyQueue:    cfg.RabbitMQ.Queue,
+				DLQ:            cfg.RabbitMQ.DLQ,
+				ConfirmTimeout: cfg.RabbitMQ.ConfirmTimeout,
+				Prefetch:       worker.Capacity(workersCount) + digestCapacity,
+			}
+			notifierr = notifier.New(rabbitConn, notifierOpts)
+
+			// Connect to RabbitMQ; the topology is declared on every (re)connect
+			if err = rabbitConn.Connect(cfg.RabbitMQ.Retries); err != nil {
+				zlog.Logger.Fatal().Err(err).Msg("failed to connect to RabbitMQ")
+			}
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				rabbitConn.Start(ctx)
+			}()
+		default:
+			zlog.Logger.Fatal().Str("backend", cfg.Scheduler.Backend).Msg("unknown scheduler backend")
+		}
+	}
 
-	// Initialize notification cache
-	c := cache.New(redisClient)
+	// Initialize notification senders
+	notificationSenders := senders.New(emailSender)
+	breakers := breaker.New(breaker.Opts{
+		FailureThreshold: cfg.Breaker.FailureThreshold,
+		CoolDown:         cfg.Breaker.CoolDown,
+		HalfOpenProbes:   cfg.Breaker.HalfOpenProbes,
+	})
+	notificationSenders.Use(senders.WithThrottle(throttler))
+	// Автомат снаружи троттлинга: разомкнутая цепь отклоняет отправку, не занимая слот
+	notificationSenders.Use(senders.WithBreaker(breakers))
 
 	// Initialize notification service
-	notificationService := service.NewNotification(repo, notifierr, c, notificationSenders)
+	notificationService := service.NewNotification(repo, notifierr, c, notificationSenders, service.Opts{
+		IdempotencyTTL: cfg.Server.IdempotencyTTL,
+		SendLease:      cfg.SendRetry.Lease,
+		OutboxLease:    cfg.Outbox.ClaimLease,
+		SendRetry: service.SendRetryOpts{
+			MaxAttempts: cfg.SendRetry.MaxAttempts,
+			BaseDelay:   cfg.SendRetry.BaseDelay,
+			MaxDelay:    cfg.SendRetry.MaxDelay,
+		},
+	})
 
 	// Initialize retry strategy
 	strategy := retry.Strategy{
@@ -100,27 +256,109 @@ func main() {
 		Backoff:  cfg.Retry.Backoff,
 	}
 
+	// Initialize lost notifications reconciler
+	notificationReconciler := reconciler.New(notificationService, strategy, reconciler.Opts{
+		Grace:     cfg.Reconcile.Grace,
+		BatchSize: cfg.Reconcile.BatchSize,
+		Interval:  cfg.Reconcile.Interval,
+		DryRun:    cfg.Reconcile.DryRun,
+	})
+
+	if cfg.Reconcile.Once {
+		report, err := notificationReconciler.Run(ctx, cfg.Reconcile.DryRun)
+		if err != nil {
+			zlog.Logger.Fatal().Err(err).Msg("failed to reconcile lost notifications")
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			zlog.Logger.Fatal().Err(err).Msg("failed to print reconcile report")
+		}
+		return
+	}
+
 	// Initialize notification validator
 	notificationValidator := validator.New()
 
 	// Initialize notification handlers
 	httpHandler := rest.New(notificationService, notificationValidator, strategy)
-	msgsHandler := handler.New(notificationService)
+	digestRenderer, err := digest.NewRenderer(cfg.Digest.Template)
+	if err != nil {
+		zlog.Logger.Fatal().Err(err).Msg("failed to initialize digest renderer")
+	}
+	msgsHandler := handler.New(notificationService, limiter, rateLimitPolicy, handler.DigestOpts{
+		Window:   cfg.Digest.Window,
+		MaxItems: cfg.Digest.MaxItems,
+		Capacity: digestCapacity,
+		Renderer: digestRenderer,
+		Strategy: strategy,
+	})
 
 	// Init and start workers
-	workers := worker.NewWorkerPool(notifierr, msgsHandler, notificationService, workersCount)
-	go workers.Start(ctx, strategy)
+	workers := worker.NewWorkerPool(notifierr, msgsHandler, workersCount)
+	workers.Start(ctx, strategy)
+
+	// Start outbox relay
+	outboxRelay := outbox.New(notificationService, strategy, outbox.Opts{
+		PollInterval: cfg.Outbox.PollInterval,
+		BatchSize:    cfg.Outbox.BatchSize,
+	})
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		outboxRelay.Start(ctx)
+	}()
+
+	// Start lost notifications reconciliation
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		notificationReconciler.Start(ctx)
+	}()
 
 	// Initialize Gin engine
 	engine := ginext.New("")
 	engine.Use(ginext.Logger())
 	engine.Use(ginext.Recovery())
 
+	// expvar-метрики
+	engine.GET("/debug/vars", func(c *ginext.Context) {
+		expvar.Handler().ServeHTTP(c.Writer, c.Request)
+	})
+
+	// Readiness: пока зависимость недоступна, использующие её ручки отвечают 503 сразу
+	dependencies := map[string]rest.Dependency{}
+	if DB != nil {
+		dependencies[dependencyPostgres] = db.NewHealth(DB.Master, time.Second)
+	}
+	if rabbitConn != nil {
+		dependencies[dependencyRabbitMQ] = rabbitConn
+	}
+	health := rest.NewHealth(dependencies)
+	engine.GET("/readyz", health.Ready)
+
 	apiGroup := engine.Group("/api/notify")
-	apiGroup.POST("/", httpHandler.CreateNotification)
+	// Создание пишет только в базу и outbox, публикацию в брокер делает relay
+	apiGroup.POST("/", health.RequireReady(dependencyPostgres), httpHandler.CreateNotification)
 	apiGroup.GET("/:id", httpHandler.GetNotificationStatus)
+	apiGroup.GET("/:id/details", httpHandler.GetNotificationDetails)
+	apiGroup.GET("/:id/attempts", httpHandler.GetNotificationAttempts)
 	apiGroup.DELETE("/:id", httpHandler.CancelNotification)
 
+	adminHandler := rest.NewAdmin(notificationReconciler, breakers)
+	adminGroup := engine.Group("/api/admin")
+	adminGroup.GET("/breakers", adminHandler.GetBreakers)
+	adminGroup.GET("/reconcile", adminHandler.GetReconcileReport)
+	adminGroup.POST("/reconcile", adminHandler.Reconcile)
+
+	dlqHandler := rest.NewDLQ(notifierr, notificationService, notificationValidator, strategy)
+	dlqGroup := adminGroup.Group("/dlq", health.RequireReady(dependencyRabbitMQ))
+	dlqGroup.GET("", dlqHandler.ListDeadLetters)
+	dlqGroup.GET("/:id", dlqHandler.GetDeadLetter)
+	dlqGroup.POST("/replay", dlqHandler.ReplayDeadLetters)
+	dlqGroup.DELETE("", dlqHandler.PurgeDeadLetters)
+
 	// Initialize and start http server
 	server := &http.Server{
 		Addr:    cfg.Server.HTTPPort,
@@ -128,30 +366,56 @@ func main() {
 	}
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			zlog.Logger.Fatal().Err(err).Msg("failed to listen start http server")
 		}
 	}()
 
 	<-ctx.Done()
+	zlog.Logger.Info().Msg("shutting down...")
 
-	// Graceful shutdown
-	withTimeout, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Graceful shutdown: stop accepting HTTP, stop consuming, let in-flight sends
+	// finish within the drain deadline, then close the storages
+	shutdownTimeout := cfg.Server.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+	withTimeout, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
 	if err := server.Shutdown(withTimeout); err != nil {
 		zlog.Logger.Error().Err(err).Msg("server shutdown failed")
 	}
 
-	if err := DB.Master.Close(); err != nil {
-		zlog.Logger.Error().Err(err).Msg("failed to close master database")
+	report := workers.Drain(withTimeout)
+	zlog.Logger.Info().
+		Int("drained", report.Drained).
+		Int("requeued", report.Requeued).
+		Bool("timed_out", report.TimedOut).
+		Msg("workers drained")
+
+	// Send buffered digests before the storage goes away
+	msgsHandler.CloseDigests(withTimeout)
+
+	background.Wait()
+
+	if redisClient != nil {
+		if err := redisClient.Close(); err != nil {
+			zlog.Logger.Error().Err(err).Msg("failed to close redis client")
+		}
 	}
 
-	if err := channel.Close(); err != nil {
-		zlog.Logger.Error().Err(err).Msg("failed to close channel")
+	if DB != nil {
+		if err := DB.Master.Close(); err != nil {
+			zlog.Logger.Error().Err(err).Msg("failed to close master database")
+		}
 	}
 
-	if err := conn.Close(); err != nil {
-		zlog.Logger.Error().Err(err).Msg("failed to close RabbitMQ conn")
+	if rabbitConn != nil {
+		if err := rabbitConn.Close(); err != nil {
+			zlog.Logger.Error().Err(err).Msg("failed to close RabbitMQ conn")
+		}
 	}
+
+	zlog.Logger.Info().Msg("shutdown complete")
 }
@@ -9,12 +9,21 @@ import (
 )
 
 type Config struct {
-	DB       DBConfig       `mapstructure:",squash"`
-	Server   ServerConfig   `mapstructure:",squash"`
-	RabbitMQ RabbitMQConfig `mapstructure:",squash"`
-	Redis    RedisConfig    `mapstructure:",squash"`
-	SMTP     SMTPConfig     `mapstructure:",squash"`
-	Retry    RetryConfig    `mapstructure:",squash"`
+	DB        DBConfig        `mapstructure:",squash"`
+	Server    ServerConfig    `mapstructure:",squash"`
+	RabbitMQ  RabbitMQConfig  `mapstructure:",squash"`
+	Redis     RedisConfig     `mapstructure:",squash"`
+	Cache     CacheConfig     `mapstructure:",squash"`
+	SMTP      SMTPConfig      `mapstructure:",squash"`
+	Retry     RetryConfig     `mapstructure:",squash"`
+	Scheduler SchedulerConfig `mapstructure:",squash"`
+	RateLimit RateLimitConfig `mapstructure:",squash"`
+	Throttle  ThrottleConfig  `mapstructure:",squash"`
+	Digest    DigestConfig    `mapstructure:",squash"`
+	Reconcile ReconcileConfig `mapstructure:",squash"`
+	Outbox    OutboxConfig    `mapstructure:",squash"`
+	SendRetry SendRetryConfig `mapstructure:",squash"`
+	Breaker   BreakerConfig   `mapstructure:",squash"`
 }
 
 type DBConfig struct {
@@ -30,20 +39,25 @@ type DBConfig struct {
 }
 
 type ServerConfig struct {
-	HTTPPort string `mapstructure:"HTTP_PORT"`
+	HTTPPort        string        `mapstructure:"HTTP_PORT"`
+	IdempotencyTTL  time.Duration `mapstructure:"IDEMPOTENCY_TTL"`
+	ShutdownTimeout time.Duration `mapstructure:"SHUTDOWN_TIMEOUT"`
 }
 
 type RabbitMQConfig struct {
-	User       string        `mapstructure:"RABBIT_USER"`
-	Password   string        `mapstructure:"RABBIT_PASSWORD"`
-	Host       string        `mapstructure:"RABBIT_HOST"`
-	Port       string        `mapstructure:"RABBIT_PORT"`
-	Retries    int           `mapstructure:"RETRIES"`
-	Pause      time.Duration `mapstructure:"PAUSE"`
-	Exchange   string        `mapstructure:"EXCHANGE"`
-	RoutingKey string        `mapstructure:"ROUTING_KEY"`
-	Queue      string        `mapstructure:"QUEUE"`
-	DLQ        string        `mapstructure:"DLQ"`
+	User           string        `mapstructure:"RABBIT_USER"`
+	Password       string        `mapstructure:"RABBIT_PASSWORD"`
+	Host           string        `mapstructure:"RABBIT_HOST"`
+	Port           string        `mapstructure:"RABBIT_PORT"`
+	Retries        int           `mapstructure:"RETRIES"`
+	Pause          time.Duration `mapstructure:"PAUSE"`
+	Exchange       string        `mapstructure:"EXCHANGE"`
+	RoutingKey     string        `mapstructure:"ROUTING_KEY"`
+	Queue          string        `mapstructure:"QUEUE"`
+	PriorityQueue  string        `mapstructure:"PRIORITY_QUEUE"`
+	DLQ            string        `mapstructure:"DLQ"`
+	ConfirmTimeout time.Duration `mapstructure:"CONFIRM_TIMEOUT"`
+	MaxPause       time.Duration `mapstructure:"RECONNECT_MAX_PAUSE"`
 }
 
 type RedisConfig struct {
@@ -53,6 +67,14 @@ type RedisConfig struct {
 	DB       int    `mapstructure:"REDIS_DB"`
 }
 
+type CacheConfig struct {
+	KeyPrefix   string        `mapstructure:"CACHE_KEY_PREFIX"`
+	ActiveTTL   time.Duration `mapstructure:"CACHE_ACTIVE_TTL"`
+	TerminalTTL time.Duration `mapstructure:"CACHE_TERMINAL_TTL"`
+	LocalSize   int           `mapstructure:"CACHE_LOCAL_SIZE"`
+	LocalTTL    time.Duration `mapstructure:"CACHE_LOCAL_TTL"`
+}
+
 type SMTPConfig struct {
 	Host     string `mapstructure:"SMTP_HOST"`
 	Port     string `mapstructure:"SMTP_PORT"`
@@ -67,8 +89,74 @@ type RetryConfig struct {
 	Backoff  float64       `mapstructure:"RETRY_BACKOFF"`
 }
 
+type SchedulerConfig struct {
+	Backend           string        `mapstructure:"BACKEND"`
+	KeyPrefix         string        `mapstructure:"SCHEDULER_KEY_PREFIX"`
+	PollInterval      time.Duration `mapstructure:"SCHEDULER_POLL_INTERVAL"`
+	VisibilityTimeout time.Duration `mapstructure:"SCHEDULER_VISIBILITY_TIMEOUT"`
+	BatchSize         int           `mapstructure:"SCHEDULER_BATCH_SIZE"`
+}
+
+type RateLimitConfig struct {
+	Rules     string `mapstructure:"RATE_LIMITS"`
+	Policy    string `mapstructure:"RATE_LIMIT_POLICY"`
+	KeyPrefix string `mapstructure:"RATE_LIMIT_KEY_PREFIX"`
+}
+
+type ThrottleConfig struct {
+	Rates       string        `mapstructure:"SEND_RATES"`
+	Concurrency string        `mapstructure:"SEND_CONCURRENCY"`
+	KeyPrefix   string        `mapstructure:"THROTTLE_KEY_PREFIX"`
+	ConnLease   time.Duration `mapstructure:"THROTTLE_CONN_LEASE"`
+}
+
+type DigestConfig struct {
+	Window   time.Duration `mapstructure:"DIGEST_WINDOW"`
+	MaxItems int           `mapstructure:"DIGEST_MAX_ITEMS"`
+	Capacity int           `mapstructure:"DIGEST_CAPACITY"`
+	Template string        `mapstructure:"DIGEST_TEMPLATE"`
+}
+
+type ReconcileConfig struct {
+	Interval  time.Duration `mapstructure:"RECONCILE_INTERVAL"`
+	Grace     time.Duration `mapstructure:"RECONCILE_GRACE"`
+	BatchSize int           `mapstructure:"RECONCILE_BATCH_SIZE"`
+	DryRun    bool          `mapstructure:"RECONCILE_DRY_RUN"`
+	Once      bool          `mapstructure:"RECONCILE_ONCE"`
+}
+
+type SendRetryConfig struct {
+	MaxAttempts int           `mapstructure:"SEND_MAX_ATTEMPTS"`
+	BaseDelay   time.Duration `mapstructure:"SEND_RETRY_BASE_DELAY"`
+	MaxDelay    time.Duration `mapstructure:"SEND_RETRY_MAX_DELAY"`
+	Lease       time.Duration `mapstructure:"SEND_LEASE"`
+}
+
+type BreakerConfig struct {
+	FailureThreshold int           `mapstructure:"BREAKER_FAILURE_THRESHOLD"`
+	CoolDown         time.Duration `mapstructure:"BREAKER_COOL_DOWN"`
+	HalfOpenProbes   int           `mapstructure:"BREAKER_HALF_OPEN_PROBES"`
+}
+
+type OutboxConfig struct {
+	PollInterval time.Duration `mapstructure:"OUTBOX_POLL_INTERVAL"`
+	BatchSize    int           `mapstructure:"OUTBOX_BATCH_SIZE"`
+	ClaimLease   time.Duration `mapstructure:"OUTBOX_CLAIM_LEASE"`
+}
+
 func MustLoad() *Config {
 	c := config.New()
+	if err := c.DefineFlag("", "backend", "BACKEND", "", "scheduler backend: rabbitmq, redis or memory"); err != nil {
+		log.Fatalf("failed to define flag: %v", err)
+	}
+	if err := c.DefineFlag("", "reconcile", "RECONCILE_ONCE", false, "run reconciliation of lost notifications once, print the report and exit"); err != nil {
+		log.Fatalf("failed to define flag: %v", err)
+	}
+	if err := c.DefineFlag("", "dry-run", "RECONCILE_DRY_RUN", false, "only report lost notifications without republishing them"); err != nil {
+		log.Fatalf("failed to define flag: %v", err)
+	}
+	c.ParseFlags()
+
 	if err := c.Load(".env", ".env", ""); err != nil {
 		log.Fatalf("failed to load config: %v", err)
 	}
@@ -0,0 +1,232 @@
+package breaker
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"delayed-notifier/pkg/senderr"
+	"errors"
+	"expvar"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State - состояние автомата отправщика канала.
+type State string
+
+const (
+	Closed   State = "closed"    // отправки идут как обычно
+	Open     State = "open"      // отправки отклоняются до конца остывания
+	HalfOpen State = "half_open" // пропускаются пробные отправки
+)
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+// OpenError - отправка отклонена, повторить её стоит не раньше RetryAt.
+type OpenError struct {
+	Channel domain.NotificationChannel
+	RetryAt time.Time
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("%s: channel %s, retry at %s", ErrOpen, e.Channel, e.RetryAt.Format(time.RFC3339))
+}
+
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen
+}
+
+// metrics - состояния и счётчики автоматов, доступны через /debug/vars.
+var metrics = expvar.NewMap("circuit_breaker")
+
+// Opts - настройки автомата. Нулевой FailureThreshold выключает автомат.
+type Opts struct {
+	FailureThreshold int           // сколько неудач подряд размыкают цепь
+	CoolDown         time.Duration // сколько цепь разомкнута перед пробой
+	HalfOpenProbes   int           // сколько пробных отправок одновременно и успешных подряд нужно, чтобы замкнуть цепь
+}
+
+// Snapshot - состояние автомата канала для админки.
+type Snapshot struct {
+	Channel             domain.NotificationChannel `json:"channel"`
+	State               State                      `json:"state"`
+	ConsecutiveFailures int                        `json:"consecutive_failures"`
+	OpenedAt            *time.Time                 `json:"opened_at,omitempty"`
+	RetryAt             *time.Time                 `json:"retry_at,omitempty"`
+	LastError           string                     `json:"last_error,omitempty"`
+}
+
+// Set - автоматы по каналам. Состояние хранится в памяти процесса: каждый
+// инстанс сам обнаруживает недоступность провайдера.
+type Set struct {
+	opts Opts
+
+	mu       sync.Mutex
+	breakers map[domain.NotificationChannel]*breaker
+}
+
+type breaker struct {
+	state     State
+	failures  int // неудач подряд в замкнутом состоянии
+	successes int // успешных проб подряд в полуоткрытом
+	probes    int // проб в полёте
+	openedAt  time.Time
+	lastError string
+	stateVar  *expvar.String
+}
+
+func New(opts Opts) *Set {
+	if opts.CoolDown <= 0 {
+		opts.CoolDown = 30 * time.Second
+	}
+	if opts.HalfOpenProbes <= 0 {
+		opts.HalfOpenProbes = 1
+	}
+
+	return &Set{
+		opts:     opts,
+		breakers: make(map[domain.NotificationChannel]*breaker),
+	}
+}
+
+// Allow разрешает отправку по каналу или возвращает *OpenError.
+// Разрешённую отправку нужно завершить вызовом Record.
+func (s *Set) Allow(channel domain.NotificationChannel) error {
+	if s.opts.FailureThreshold <= 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.get(channel)
+	now := time.Now()
+
+	if b.state == Open {
+		retryAt := b.openedAt.Add(s.opts.CoolDown)
+		if now.Before(retryAt) {
+			metrics.Add(string(channel)+".rejected", 1)
+			return &OpenError{Channel: channel, RetryAt: retryAt}
+		}
+		s.setState(channel, b, HalfOpen)
+	}
+
+	if b.state == HalfOpen {
+		if b.probes >= s.opts.HalfOpenProbes {
+			metrics.Add(string(channel)+".rejected", 1)
+			return &OpenError{Channel: channel, RetryAt: now.Add(s.opts.CoolDown)}
+		}
+		b.probes++
+	}
+
+	return nil
+}
+
+// Record учитывает результат разрешённой отправки. Цепь размыкают только
+// временные ошибки: отказ по конкретному получателю не говорит о недоступности провайдера.
+func (s *Set) Record(ctx context.Context, channel domain.NotificationChannel, err error) {
+	if s.opts.FailureThreshold <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.get(channel)
+
+	// Отказ троттлинга: провайдер не вызывался, проба только освобождается
+	if err != nil && senderr.Classify(err).Class == senderr.Throttled {
+		if b.state == HalfOpen {
+			b.probes = max(b.probes-1, 0)
+		}
+		return
+	}
+
+	// Остановка сервиса - не сбой провайдера
+	failed := err != nil && ctx.Err() == nil && senderr.Classify(err).Class == senderr.Transient
+
+	switch b.state {
+	case HalfOpen:
+		b.probes = max(b.probes-1, 0)
+		if failed {
+			b.lastError = err.Error()
+			s.open(channel, b)
+			return
+		}
+		b.successes++
+		if b.successes >= s.opts.HalfOpenProbes {
+			s.setState(channel, b, Closed)
+		}
+	case Closed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		b.lastError = err.Error()
+		metrics.Add(string(channel)+".failures", 1)
+		if b.failures >= s.opts.FailureThreshold {
+			s.open(channel, b)
+		}
+	}
+}
+
+// Snapshots возвращает состояния автоматов всех каналов, по которым были отправки.
+func (s *Set) Snapshots() []Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshots := make([]Snapshot, 0, len(s.breakers))
+	for channel, b := range s.breakers {
+		snapshot := Snapshot{
+			Channel:             channel,
+			State:               b.state,
+			ConsecutiveFailures: b.failures,
+			LastError:           b.lastError,
+		}
+		if b.state != Closed {
+			openedAt := b.openedAt
+			retryAt := b.openedAt.Add(s.opts.CoolDown)
+			snapshot.OpenedAt = &openedAt
+			snapshot.RetryAt = &retryAt
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Channel < snapshots[j].Channel
+	})
+
+	return snapshots
+}
+
+// get вызывается под s.mu
+func (s *Set) get(channel domain.NotificationChannel) *breaker {
+	b, ok := s.breakers[channel]
+	if !ok {
+		b = &breaker{state: Closed, stateVar: new(expvar.String)}
+		b.stateVar.Set(string(Closed))
+		metrics.Set(string(channel)+".state", b.stateVar)
+		s.breakers[channel] = b
+	}
+	return b
+}
+
+// open вызывается под s.mu
+func (s *Set) open(channel domain.NotificationChannel, b *breaker) {
+	b.openedAt = time.Now()
+	metrics.Add(string(channel)+".opened", 1)
+	s.setState(channel, b, Open)
+}
+
+// setState вызывается под s.mu
+func (s *Set) setState(_ domain.NotificationChannel, b *breaker, state State) {
+	b.state = state
+	b.failures = 0
+	b.successes = 0
+	if state != HalfOpen {
+		b.probes = 0
+	}
+	b.stateVar.Set(string(state))
+}
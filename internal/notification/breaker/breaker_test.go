@@ -0,0 +1,137 @@
+package breaker
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"delayed-notifier/pkg/senderr"
+	"errors"
+	"testing"
+	"time"
+)
+
+func fail(t *testing.T, s *Set, err error, times int) {
+	t.Helper()
+
+	for i := 0; i < times; i++ {
+		if allowErr := s.Allow(domain.Email); allowErr != nil {
+			t.Fatalf("Allow before failure %d: %v", i+1, allowErr)
+		}
+		s.Record(context.Background(), domain.Email, err)
+	}
+}
+
+func TestOpensAfterConsecutiveTransientFailures(t *testing.T) {
+	s := New(Opts{FailureThreshold: 3, CoolDown: time.Hour})
+
+	fail(t, s, errors.New("timeout"), 3)
+
+	err := s.Allow(domain.Email)
+	var openErr *OpenError
+	if !errors.As(err, &openErr) || !errors.Is(err, ErrOpen) {
+		t.Fatalf("got %v, want OpenError", err)
+	}
+	if openErr.Channel != domain.Email {
+		t.Fatalf("channel: got %s", openErr.Channel)
+	}
+
+	// Цепь другого канала не затронута
+	if err := s.Allow(domain.Telegram); err != nil {
+		t.Fatalf("telegram: %v", err)
+	}
+}
+
+func TestSuccessResetsFailures(t *testing.T) {
+	s := New(Opts{FailureThreshold: 2, CoolDown: time.Hour})
+
+	fail(t, s, errors.New("timeout"), 1)
+	fail(t, s, nil, 1)
+	fail(t, s, errors.New("timeout"), 1)
+
+	if err := s.Allow(domain.Email); err != nil {
+		t.Fatalf("got %v, want closed circuit", err)
+	}
+}
+
+func TestIgnoresNonProviderFailures(t *testing.T) {
+	s := New(Opts{FailureThreshold: 1, CoolDown: time.Hour})
+
+	fail(t, s, senderr.New(senderr.Permanent, "550", errors.New("rejected")), 3)
+	fail(t, s, senderr.New(senderr.InvalidRecipient, "550", errors.New("no such user")), 3)
+	fail(t, s, senderr.NewThrottled(time.Second, errors.New("redis is down")), 3)
+
+	if err := s.Allow(domain.Email); err != nil {
+		t.Fatalf("got %v, want closed circuit", err)
+	}
+
+	// Отправка, прерванная остановкой сервиса, тоже не считается
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Allow(domain.Email); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	s.Record(ctx, domain.Email, context.Canceled)
+
+	if err := s.Allow(domain.Email); err != nil {
+		t.Fatalf("got %v, want closed circuit", err)
+	}
+}
+
+func TestHalfOpenProbe(t *testing.T) {
+	const coolDown = 20 * time.Millisecond
+
+	t.Run("successful probe closes the circuit", func(t *testing.T) {
+		s := New(Opts{FailureThreshold: 1, CoolDown: coolDown, HalfOpenProbes: 1})
+		fail(t, s, errors.New("timeout"), 1)
+
+		time.Sleep(coolDown)
+		if err := s.Allow(domain.Email); err != nil {
+			t.Fatalf("probe: %v", err)
+		}
+		// Пока проба в полёте, остальные отправки отклоняются
+		if err := s.Allow(domain.Email); !errors.Is(err, ErrOpen) {
+			t.Fatalf("second probe: got %v, want ErrOpen", err)
+		}
+
+		s.Record(context.Background(), domain.Email, nil)
+		if snapshot := s.Snapshots()[0]; snapshot.State != Closed {
+			t.Fatalf("state: got %s, want %s", snapshot.State, Closed)
+		}
+	})
+
+	t.Run("failed probe opens the circuit again", func(t *testing.T) {
+		s := New(Opts{FailureThreshold: 1, CoolDown: coolDown, HalfOpenProbes: 1})
+		fail(t, s, errors.New("timeout"), 1)
+
+		time.Sleep(coolDown)
+		fail(t, s, errors.New("timeout"), 1)
+
+		if err := s.Allow(domain.Email); !errors.Is(err, ErrOpen) {
+			t.Fatalf("got %v, want ErrOpen", err)
+		}
+	})
+	t.Run("throttled probe only frees the slot", func(t *testing.T) {
+		s := New(Opts{FailureThreshold: 1, CoolDown: coolDown, HalfOpenProbes: 1})
+		fail(t, s, errors.New("timeout"), 1)
+
+		time.Sleep(coolDown)
+		fail(t, s, senderr.NewThrottled(time.Second, errors.New("redis is down")), 1)
+
+		// Провайдер не вызывался: цепь не замкнута, но следующая проба пропускается
+		if snapshot := s.Snapshots()[0]; snapshot.State != HalfOpen {
+			t.Fatalf("state: got %s, want %s", snapshot.State, HalfOpen)
+		}
+		if err := s.Allow(domain.Email); err != nil {
+			t.Fatalf("probe after throttled one: %v", err)
+		}
+	})
+}
+
+func TestDisabled(t *testing.T) {
+	s := New(Opts{})
+
+	fail(t, s, errors.New("timeout"), 10)
+
+	if err := s.Allow(domain.Email); err != nil {
+		t.Fatalf("got %v, want disabled breaker", err)
+	}
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/wb-go/wbf/redis"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	n := notification(3)
+	n.ScheduledAt = now
+	n.Status = domain.Canceled
+	n.LastFailure = &domain.SendFailure{Class: "transient", Error: "timeout"}
+	n.Cancellation = &domain.Cancellation{By: "user", Reason: "not needed", At: now}
+	n.CreatedAt = now
+	n.UpdatedAt = now
+
+	raw, err := encode(n)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	got, err := decode(raw)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, n) {
+		t.Fatalf("round trip:\n got %+v\nwant %+v", got, n)
+	}
+}
+
+func TestCacheTTLByStatus(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	c := New(newRedisClient(t, server), Opts{ActiveTTL: time.Minute, TerminalTTL: time.Hour})
+
+	active := notification(1)
+	terminal := notification(2)
+	terminal.Status = domain.Sent
+	for _, n := range []domain.Notification{active, terminal} {
+		if err := c.Set(ctx, n); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+
+	if ttl := server.TTL(c.opts.KeyPrefix + active.ID.String()); ttl != time.Minute {
+		t.Fatalf("active TTL: got %s, want %s", ttl, time.Minute)
+	}
+	if ttl := server.TTL(c.opts.KeyPrefix + terminal.ID.String()); ttl != time.Hour {
+		t.Fatalf("terminal TTL: got %s, want %s", ttl, time.Hour)
+	}
+
+	// Активная запись истекает раньше, промах - redis.NoMatches
+	server.FastForward(time.Minute + time.Second)
+	if _, err := c.Get(ctx, active.ID.String()); !errors.Is(err, redis.NoMatches) {
+		t.Fatalf("active after TTL: got %v, want NoMatches", err)
+	}
+	if got, err := c.Get(ctx, terminal.ID.String()); err != nil || got.Status != domain.Sent {
+		t.Fatalf("terminal after active TTL: %v, %v", got.Status, err)
+	}
+
+	if err := c.DeleteWithRetry(ctx, terminal.ID.String(), testStrategy); err != nil {
+		t.Fatalf("DeleteWithRetry: %v", err)
+	}
+	if _, err := c.Get(ctx, terminal.ID.String()); !errors.Is(err, redis.NoMatches) {
+		t.Fatalf("after delete: got %v, want NoMatches", err)
+	}
+}
+
+func TestMemoryCacheTTLByStatus(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemory(Opts{ActiveTTL: 20 * time.Millisecond, TerminalTTL: time.Hour})
+
+	active := notification(1)
+	terminal := notification(2)
+	terminal.Status = domain.Failed
+	for _, n := range []domain.Notification{active, terminal} {
+		if err := c.Set(ctx, n); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := c.Get(ctx, active.ID.String()); !errors.Is(err, redis.NoMatches) {
+		t.Fatalf("active after TTL: got %v, want NoMatches", err)
+	}
+	if _, err := c.Get(ctx, terminal.ID.String()); err != nil {
+		t.Fatalf("terminal after active TTL: %v", err)
+	}
+}
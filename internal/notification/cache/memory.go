@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"delayed-notifier/pkg/errutils"
+	"github.com/wb-go/wbf/redis"
+	"github.com/wb-go/wbf/retry"
+	"sync"
+	"time"
+)
+
+// MemoryCache - кэш записей в памяти процесса с тем же контрактом, что и Cache:
+// промах возвращает redis.NoMatches. Просроченные записи удаляются при чтении
+// и при записи, не чаще раза в ActiveTTL.
+type MemoryCache struct {
+	opts Opts
+
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewMemory(opts Opts) *MemoryCache {
+	return &MemoryCache{opts: opts.withDefaults(), entries: make(map[string]memoryEntry)}
+}
+
+func (c *MemoryCache) Set(_ context.Context, notification domain.Notification) error {
+	// Храним закодированную запись, чтобы вызывающий не менял её через указатели
+	value, err := encode(notification)
+	if err != nil {
+		return errutils.Wrap("failed to encode notification", err)
+	}
+
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastSweep) > c.opts.ActiveTTL {
+		for id, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, id)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	c.entries[notification.ID.String()] = memoryEntry{
+		value:     value,
+		expiresAt: now.Add(c.opts.ttl(notification.Status)),
+	}
+	return nil
+}
+
+func (c *MemoryCache) Get(_ context.Context, id string) (domain.Notification, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[id]
+	if ok && time.Now().After(entry.expiresAt) {
+		delete(c.entries, id)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		return domain.Notification{}, errutils.Wrap("failed to get notification from memory", redis.NoMatches)
+	}
+
+	notification, err := decode(entry.value)
+	if err != nil {
+		return domain.Notification{}, errutils.Wrap("failed to decode cached notification", err)
+	}
+	return notification, nil
+}
+
+func (c *MemoryCache) DeleteWithRetry(_ context.Context, id string, _ retry.Strategy) error {
+	c.mu.Lock()
+	delete(c.entries, id)
+	c.mu.Unlock()
+	return nil
+}
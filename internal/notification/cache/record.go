@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"delayed-notifier/internal/notification/types/domain"
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Opts - настройки кэша. Запись уведомления, которое ещё может сменить статус,
+// живёт ActiveTTL, уведомления в конечном статусе - TerminalTTL.
+type Opts struct {
+	KeyPrefix   string
+	ActiveTTL   time.Duration
+	TerminalTTL time.Duration
+}
+
+func (o Opts) withDefaults() Opts {
+	if o.KeyPrefix == "" {
+		o.KeyPrefix = "notifier:notification:"
+	}
+	if o.ActiveTTL <= 0 {
+		o.ActiveTTL = 30 * time.Second
+	}
+	if o.TerminalTTL <= 0 {
+		o.TerminalTTL = 24 * time.Hour
+	}
+	return o
+}
+
+// InvalidationChannel - канал pub/sub для инвалидаций локальных кэшей.
+func (o Opts) InvalidationChannel() string {
+	return o.withDefaults().KeyPrefix + "invalidate"
+}
+
+// ttl - время жизни записи в зависимости от статуса. Активную запись держим
+// недолго: если её перезапишут устаревшей версией, ошибка быстро исчезнет.
+func (o Opts) ttl(status domain.NotificationStatus) time.Duration {
+	switch status {
+	case domain.Scheduled, domain.Sending:
+		return o.ActiveTTL
+	default:
+		return o.TerminalTTL
+	}
+}
+
+// record - компактное представление уведомления в кэше: короткие ключи,
+// пустые поля опускаются. Follow-up'ы не хранятся, GET их не показывает.
+type record struct {
+	ID            uuid.UUID  `json:"i"`
+	Message       string     `json:"m"`
+	ScheduledAt   time.Time  `json:"sa"`
+	Channel       string     `json:"c"`
+	Recipient     string     `json:"r"`
+	Priority      string     `json:"p,omitempty"`
+	Status        string     `json:"s"`
+	Version       int64      `json:"v,omitempty"`
+	ExpiresAt     *time.Time `json:"ea,omitempty"`
+	LeaseUntil    *time.Time `json:"lu,omitempty"`
+	Retries       int        `json:"rt,omitempty"`
+	NextRetryAt   *time.Time `json:"nr,omitempty"`
+	FailureClass  string     `json:"fc,omitempty"`
+	FailureError  *string    `json:"fe,omitempty"` // nil - неудачных попыток не было
+	FailureResp   string     `json:"fr,omitempty"`
+	RateLimit     string     `json:"rl,omitempty"`
+	DeferredUntil *time.Time `json:"du,omitempty"`
+	Digest        bool       `json:"d,omitempty"`
+	DigestID      *uuid.UUID `json:"di,omitempty"`
+	ParentID      *uuid.UUID `json:"pi,omitempty"`
+	CanceledAt    *time.Time `json:"ca,omitempty"`
+	CanceledBy    string     `json:"cb,omitempty"`
+	CancelReason  string     `json:"cr,omitempty"`
+	LastAttemptAt *time.Time `json:"la,omitempty"`
+	RepublishedAt *time.Time `json:"ra,omitempty"`
+	CreatedAt     *time.Time `json:"ct,omitempty"`
+	UpdatedAt     *time.Time `json:"ut,omitempty"`
+}
+
+func encode(n domain.Notification) ([]byte, error) {
+	r := record{
+		ID:            n.ID,
+		Message:       n.Message,
+		ScheduledAt:   n.ScheduledAt,
+		Channel:       string(n.Channel),
+		Recipient:     n.Recipient,
+		Priority:      string(n.Priority),
+		Status:        string(n.Status),
+		Version:       n.Version,
+		ExpiresAt:     n.ExpiresAt,
+		LeaseUntil:    n.LeaseUntil,
+		Retries:       n.Retries,
+		NextRetryAt:   n.NextRetryAt,
+		RateLimit:     string(n.RateLimit),
+		DeferredUntil: n.DeferredUntil,
+		Digest:        n.Digest,
+		DigestID:      n.DigestID,
+		ParentID:      n.ParentID,
+		LastAttemptAt: n.LastAttemptAt,
+		RepublishedAt: n.RepublishedAt,
+	}
+	if n.LastFailure != nil {
+		r.FailureClass = n.LastFailure.Class
+		r.FailureError = &n.LastFailure.Error
+		r.FailureResp = n.LastFailure.Response
+	}
+	if n.Cancellation != nil {
+		r.CanceledAt = &n.Cancellation.At
+		r.CanceledBy = n.Cancellation.By
+		r.CancelReason = n.Cancellation.Reason
+	}
+	if !n.CreatedAt.IsZero() {
+		r.CreatedAt = &n.CreatedAt
+	}
+	if !n.UpdatedAt.IsZero() {
+		r.UpdatedAt = &n.UpdatedAt
+	}
+
+	return json.Marshal(r)
+}
+
+func decode(raw []byte) (domain.Notification, error) {
+	var r record
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return domain.Notification{}, err
+	}
+
+	n := domain.Notification{
+		ID:            r.ID,
+		Message:       r.Message,
+		ScheduledAt:   r.ScheduledAt,
+		Channel:       domain.NotificationChannel(r.Channel),
+		Recipient:     r.Recipient,
+		Priority:      domain.NotificationPriority(r.Priority),
+		Status:        domain.NotificationStatus(r.Status),
+		Version:       r.Version,
+		ExpiresAt:     r.ExpiresAt,
+		LeaseUntil:    r.LeaseUntil,
+		Retries:       r.Retries,
+		NextRetryAt:   r.NextRetryAt,
+		RateLimit:     domain.RateLimitDecision(r.RateLimit),
+		DeferredUntil: r.DeferredUntil,
+		Digest:        r.Digest,
+		DigestID:      r.DigestID,
+		ParentID:      r.ParentID,
+		LastAttemptAt: r.LastAttemptAt,
+		RepublishedAt: r.RepublishedAt,
+	}
+	if r.FailureError != nil {
+		n.LastFailure = &domain.SendFailure{
+			Class:    r.FailureClass,
+			Error:    *r.FailureError,
+			Response: r.FailureResp,
+		}
+	}
+	if r.CanceledAt != nil {
+		n.Cancellation = &domain.Cancellation{
+			By:     r.CanceledBy,
+			Reason: r.CancelReason,
+			At:     *r.CanceledAt,
+		}
+	}
+	if r.CreatedAt != nil {
+		n.CreatedAt = *r.CreatedAt
+	}
+	if r.UpdatedAt != nil {
+		n.UpdatedAt = *r.UpdatedAt
+	}
+
+	return n, nil
+}
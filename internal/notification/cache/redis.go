@@ -2,30 +2,53 @@ package cache
 
 import (
 	"context"
+	"delayed-notifier/internal/notification/types/domain"
 	"delayed-notifier/pkg/errutils"
 	"github.com/wb-go/wbf/redis"
 	"github.com/wb-go/wbf/retry"
 )
 
+// Cache - кэш записей уведомлений в Redis под ключами KeyPrefix+ID.
+// Промах возвращает redis.NoMatches.
 type Cache struct {
 	client *redis.Client
+	opts   Opts
 }
 
-func New(client *redis.Client) *Cache {
-	return &Cache{client: client}
+func New(client *redis.Client, opts Opts) *Cache {
+	return &Cache{client: client, opts: opts.withDefaults()}
 }
 
-func (c *Cache) SetStatusWithRetry(ctx context.Context, id string, status string, strategy retry.Strategy) error {
-	if err := c.client.SetWithRetry(ctx, strategy, id, status); err != nil {
-		return errutils.Wrap("failed to cache status", err)
+func (c *Cache) Set(ctx context.Context, notification domain.Notification) error {
+	value, err := encode(notification)
+	if err != nil {
+		return errutils.Wrap("failed to encode notification", err)
+	}
+
+	key := c.opts.KeyPrefix + notification.ID.String()
+	if err := c.client.SetWithExpiration(ctx, key, value, c.opts.ttl(notification.Status)); err != nil {
+		return errutils.Wrap("failed to cache notification", err)
 	}
 	return nil
 }
 
-func (c *Cache) GetStatus(ctx context.Context, id string) (string, error) {
-	status, err := c.client.Get(ctx, id)
+func (c *Cache) Get(ctx context.Context, id string) (domain.Notification, error) {
+	value, err := c.client.Get(ctx, c.opts.KeyPrefix+id)
+	if err != nil {
+		return domain.Notification{}, errutils.Wrap("failed to get notification from redis", err)
+	}
+
+	notification, err := decode([]byte(value))
 	if err != nil {
-		return "", errutils.Wrap("failed to get status from redis", err)
+		return domain.Notification{}, errutils.Wrap("failed to decode cached notification", err)
 	}
-	return status, nil
+	return notification, nil
+}
+
+// DeleteWithRetry удаляет запись после смены статуса уведомления.
+func (c *Cache) DeleteWithRetry(ctx context.Context, id string, strategy retry.Strategy) error {
+	if err := c.client.DelWithRetry(ctx, strategy, c.opts.KeyPrefix+id); err != nil {
+		return errutils.Wrap("failed to delete cached notification", err)
+	}
+	return nil
 }
@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"delayed-notifier/pkg/errutils"
+	"expvar"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/redis"
+	"github.com/wb-go/wbf/retry"
+	"github.com/wb-go/wbf/zlog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metrics - попадания и промахи двухуровневого кэша, доступны через /debug/vars.
+var metrics = expvar.NewMap("notification_cache")
+
+// Backend - общий для всех инстансов кэш, перед которым стоит локальный LRU.
+type Backend interface {
+	Set(ctx context.Context, notification domain.Notification) error
+	Get(ctx context.Context, id string) (domain.Notification, error)
+	DeleteWithRetry(ctx context.Context, id string, strategy retry.Strategy) error
+}
+
+// TieredOpts - настройки локального уровня.
+type TieredOpts struct {
+	Size    int           // сколько записей держит LRU
+	TTL     time.Duration // сколько живёт запись в LRU
+	Channel string        // канал pub/sub, по которому инстансы рассылают инвалидации
+}
+
+// Tiered - LRU в памяти процесса перед Backend. Удаление записи рассылается
+// остальным инстансам через Redis pub/sub, и они вытесняют её из своего LRU.
+// Сообщения, пропущенные при обрыве подписки, не повторяются: устаревшая
+// локальная запись живёт не дольше TTL.
+//
+// Удалённая запись остаётся в LRU отметкой на TTL: чтение Backend, начатое до
+// удаления, не вернёт в LRU прежнюю версию. Запись не заменяется более старой версией.
+type Tiered struct {
+	backend  Backend
+	client   *redis.Client
+	opts     TieredOpts
+	instance string // свои инвалидации инстанс не обрабатывает повторно
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // в начале - недавно использованные
+}
+
+type localEntry struct {
+	id           string
+	notification domain.Notification
+	expiresAt    time.Time
+	removed      bool // отметка удаления: до expiresAt LRU по этому ID не заполняется
+}
+
+func NewTiered(backend Backend, client *redis.Client, opts TieredOpts) *Tiered {
+	if opts.Size <= 0 {
+		opts.Size = 10000
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 2 * time.Second
+	}
+	if opts.Channel == "" {
+		opts.Channel = Opts{}.InvalidationChannel()
+	}
+
+	return &Tiered{
+		backend:  backend,
+		client:   client,
+		opts:     opts,
+		instance: uuid.NewString(),
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Set записывает запись в Backend и LRU. Инвалидация не рассылается: Set
+// вызывается для новых уведомлений и при заполнении кэша по промаху.
+func (t *Tiered) Set(ctx context.Context, notification domain.Notification) error {
+	if err := t.backend.Set(ctx, notification); err != nil {
+		return err
+	}
+
+	t.add(notification)
+	return nil
+}
+
+// Get ищет запись в LRU, затем в Backend. Промах возвращает redis.NoMatches.
+func (t *Tiered) Get(ctx context.Context, id string) (domain.Notification, error) {
+	if notification, ok := t.get(id); ok {
+		metrics.Add("local_hits", 1)
+		return notification, nil
+	}
+
+	notification, err := t.backend.Get(ctx, id)
+	if err != nil {
+		metrics.Add("misses", 1)
+		return domain.Notification{}, err
+	}
+	metrics.Add("remote_hits", 1)
+
+	t.add(notification)
+	return notification, nil
+}
+
+// DeleteWithRetry удаляет запись из обоих уровней и рассылает инвалидацию.
+func (t *Tiered) DeleteWithRetry(ctx context.Context, id string, strategy retry.Strategy) error {
+	const op = "cache.tiered.DeleteWithRetry"
+
+	t.remove(id)
+
+	if err := t.backend.DeleteWithRetry(ctx, id, strategy); err != nil {
+		return err
+	}
+
+	err := retry.Do(func() error {
+		return t.client.Publish(ctx, t.opts.Channel, t.instance+" "+id).Err()
+	}, strategy)
+	if err != nil {
+		return errutils.Wrap(op, err)
+	}
+	return nil
+}
+
+// Start подписывается на инвалидации других инстансов до отмены ctx.
+func (t *Tiered) Start(ctx context.Context) {
+	pubsub := t.client.Subscribe(ctx, t.opts.Channel)
+	defer func() {
+		if err := pubsub.Close(); err != nil {
+			zlog.Logger.Error().Err(err).Msg("failed to close cache invalidation subscription")
+		}
+	}()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			instance, id, found := strings.Cut(msg.Payload, " ")
+			if !found || instance == t.instance {
+				continue
+			}
+			metrics.Add("invalidations", 1)
+			t.remove(id)
+		}
+	}
+}
+
+func (t *Tiered) get(id string) (domain.Notification, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	elem, ok := t.entries[id]
+	if !ok {
+		return domain.Notification{}, false
+	}
+
+	entry := elem.Value.(*localEntry)
+	if time.Now().After(entry.expiresAt) {
+		t.order.Remove(elem)
+		delete(t.entries, id)
+		return domain.Notification{}, false
+	}
+	if entry.removed {
+		return domain.Notification{}, false
+	}
+
+	t.order.MoveToFront(elem)
+	return entry.notification, true
+}
+
+func (t *Tiered) add(notification domain.Notification) {
+	id := notification.ID.String()
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if elem, ok := t.entries[id]; ok {
+		entry := elem.Value.(*localEntry)
+		if now.Before(entry.expiresAt) {
+			// Запись недавно удалена или в LRU уже более новая версия
+			if entry.removed || entry.notification.Version > notification.Version {
+				return
+			}
+		}
+		*entry = localEntry{id: id, notification: notification, expiresAt: now.Add(t.opts.TTL)}
+		t.order.MoveToFront(elem)
+		return
+	}
+
+	t.push(&localEntry{id: id, notification: notification, expiresAt: now.Add(t.opts.TTL)})
+}
+
+// remove заменяет запись отметкой удаления.
+func (t *Tiered) remove(id string) {
+	tombstone := &localEntry{id: id, expiresAt: time.Now().Add(t.opts.TTL), removed: true}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if elem, ok := t.entries[id]; ok {
+		elem.Value = tombstone
+		t.order.MoveToFront(elem)
+		return
+	}
+
+	t.push(tombstone)
+}
+
+// push добавляет новую запись и вытесняет самую давнюю сверх Size. Вызывается под t.mu.
+func (t *Tiered) push(entry *localEntry) {
+	t.entries[entry.id] = t.order.PushFront(entry)
+
+	if t.order.Len() > t.opts.Size {
+		oldest := t.order.Back()
+		t.order.Remove(oldest)
+		delete(t.entries, oldest.Value.(*localEntry).id)
+	}
+}
@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/redis"
+	"github.com/wb-go/wbf/retry"
+	"testing"
+	"time"
+)
+
+var testStrategy = retry.Strategy{Attempts: 1}
+
+func newRedisClient(t *testing.T, server *miniredis.Miniredis) *redis.Client {
+	t.Helper()
+
+	client := &redis.Client{Client: goredis.NewClient(&goredis.Options{Addr: server.Addr()})}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
+
+func newTiered(t *testing.T, backend Backend, opts TieredOpts) *Tiered {
+	t.Helper()
+
+	return NewTiered(backend, newRedisClient(t, miniredis.RunT(t)), opts)
+}
+
+func notification(version int64) domain.Notification {
+	return domain.Notification{
+		ID:          uuid.New(),
+		Message:     "reminder",
+		ScheduledAt: time.Now(),
+		Channel:     domain.Email,
+		Recipient:   "user@example.com",
+		Status:      domain.Scheduled,
+		Version:     version,
+	}
+}
+
+// cached - есть ли запись в LRU, без обращения к Backend.
+func cached(t *Tiered, n domain.Notification) bool {
+	_, ok := t.get(n.ID.String())
+	return ok
+}
+
+func TestTieredEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	tiered := newTiered(t, NewMemory(Opts{}), TieredOpts{Size: 2, TTL: time.Hour})
+
+	a, b, c := notification(1), notification(1), notification(1)
+	for _, n := range []domain.Notification{a, b} {
+		if err := tiered.Set(ctx, n); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+
+	// a использована последней, вытесняется b
+	cached(tiered, a)
+	if err := tiered.Set(ctx, c); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	if !cached(tiered, a) || !cached(tiered, c) {
+		t.Fatal("recently used entries were evicted")
+	}
+	if cached(tiered, b) {
+		t.Fatal("least recently used entry was not evicted")
+	}
+
+	// Из Backend вытесненная запись по-прежнему читается
+	if _, err := tiered.Get(ctx, b.ID.String()); err != nil {
+		t.Fatalf("Get evicted entry: %v", err)
+	}
+}
+
+func TestTieredEntryExpires(t *testing.T) {
+	ctx := context.Background()
+	tiered := newTiered(t, NewMemory(Opts{}), TieredOpts{TTL: 20 * time.Millisecond})
+
+	n := notification(1)
+	if err := tiered.Set(ctx, n); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if !cached(tiered, n) {
+		t.Fatal("entry was not cached")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if cached(tiered, n) {
+		t.Fatal("entry outlived TTL")
+	}
+}
+
+func TestTieredKeepsNewerVersion(t *testing.T) {
+	tiered := newTiered(t, NewMemory(Opts{}), TieredOpts{TTL: time.Hour})
+
+	newer := notification(2)
+	older := newer
+	older.Version = 1
+	newer.Status = domain.Sent
+
+	tiered.add(newer)
+	tiered.add(older)
+
+	got, ok := tiered.get(newer.ID.String())
+	if !ok || got.Version != 2 || got.Status != domain.Sent {
+		t.Fatalf("got version %d (%s), want the newer record", got.Version, got.Status)
+	}
+}
+
+// slowBackend отдаёт запись, прочитанную до сигнала read, только после release.
+type slowBackend struct {
+	*MemoryCache
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (b *slowBackend) Get(ctx context.Context, id string) (domain.Notification, error) {
+	notification, err := b.MemoryCache.Get(ctx, id)
+	close(b.read)
+	<-b.release
+	return notification, err
+}
+
+func TestTieredStaleFillAfterDelete(t *testing.T) {
+	ctx := context.Background()
+	backend := &slowBackend{MemoryCache: NewMemory(Opts{}), read: make(chan struct{}), release: make(chan struct{})}
+	tiered := newTiered(t, backend, TieredOpts{TTL: time.Hour})
+
+	stale := notification(1)
+	if err := backend.Set(ctx, stale); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	// Чтение Backend началось до смены статуса, а закончилось после удаления
+	filled := make(chan struct{})
+	go func() {
+		defer close(filled)
+		_, _ = tiered.Get(ctx, stale.ID.String())
+	}()
+	<-backend.read
+
+	if err := tiered.DeleteWithRetry(ctx, stale.ID.String(), testStrategy); err != nil {
+		t.Fatalf("DeleteWithRetry: %v", err)
+	}
+	close(backend.release)
+	<-filled
+
+	if cached(tiered, stale) {
+		t.Fatal("stale record was put back into LRU after delete")
+	}
+}
+
+func TestTieredInvalidatesOtherInstances(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := miniredis.RunT(t)
+	backend := NewMemory(Opts{})
+	first := NewTiered(backend, newRedisClient(t, server), TieredOpts{TTL: time.Hour})
+	second := NewTiered(backend, newRedisClient(t, server), TieredOpts{TTL: time.Hour})
+	go first.Start(ctx)
+	go second.Start(ctx)
+
+	// Подписка устанавливается асинхронно
+	deadline := time.Now().Add(time.Second)
+	for server.PubSubNumSub(first.opts.Channel)[first.opts.Channel] < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("instances did not subscribe")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	n := notification(1)
+	if err := first.Set(ctx, n); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, err := second.Get(ctx, n.ID.String()); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !cached(second, n) {
+		t.Fatal("second instance did not cache the record")
+	}
+
+	if err := first.DeleteWithRetry(ctx, n.ID.String(), testStrategy); err != nil {
+		t.Fatalf("DeleteWithRetry: %v", err)
+	}
+
+	deadline = time.Now().Add(time.Second)
+	for cached(second, n) {
+		if time.Now().After(deadline) {
+			t.Fatal("second instance kept the record after invalidation")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,192 @@
+package digest
+
+import (
+	"bytes"
+	"context"
+	"delayed-notifier/internal/notification/rabbitmq/notifier"
+	"delayed-notifier/pkg/errutils"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const DefaultTemplate = "You have {{.Count}} reminders:\n{{range .Items}}- {{.Message}}\n{{end}}"
+
+// Key - дайджесты собираются отдельно для каждого получателя и канала.
+type Key struct {
+	Channel   string
+	Recipient string
+}
+
+// FlushFunc отправляет накопленный дайджест и подтверждает его элементы брокеру.
+type FlushFunc func(ctx context.Context, key Key, items []notifier.Delivery)
+
+type batch struct {
+	items []notifier.Delivery
+	timer *time.Timer
+}
+
+// Buffer копит уведомления одного получателя в течение окна и сбрасывает их
+// одним дайджестом по истечении окна или при достижении max элементов.
+// Элементы остаются неподтверждёнными до сброса, поэтому окно должно быть
+// меньше таймаута видимости бэкенда, а prefetch - вмещать capacity элементов.
+type Buffer struct {
+	window   time.Duration
+	max      int
+	capacity int
+	flush    FlushFunc
+
+	mu      sync.Mutex
+	batches map[Key]*batch
+	size    int  // элементов во всех дайджестах
+	closed  bool // после Close новые элементы не принимаются
+
+	flushes sync.WaitGroup // идущие сбросы, их дожидается Close
+}
+
+// NewBuffer создаёт буфер, который держит не больше capacity элементов по всем получателям.
+func NewBuffer(window time.Duration, max, capacity int, flush FlushFunc) *Buffer {
+	return &Buffer{
+		window:   window,
+		max:      max,
+		capacity: capacity,
+		flush:    flush,
+		batches:  make(map[Key]*batch),
+	}
+}
+
+// Add добавляет уведомление в дайджест получателя. Если дайджест заполнен,
+// он сбрасывается синхронно в вызывающей горутине. Возвращает false, если
+// буфер полон или закрыт и уведомление нужно отправить отдельно.
+func (b *Buffer) Add(ctx context.Context, delivery notifier.Delivery) bool {
+	key := Key{Channel: delivery.Channel, Recipient: delivery.Recipient}
+
+	b.mu.Lock()
+	if b.closed || b.size >= b.capacity {
+		b.mu.Unlock()
+		return false
+	}
+
+	bt, ok := b.batches[key]
+	if !ok {
+		bt = &batch{}
+		bt.timer = time.AfterFunc(b.window, func() {
+			b.flushExpired(key, bt)
+		})
+		b.batches[key] = bt
+	}
+	bt.items = append(bt.items, delivery)
+	b.size++
+
+	if b.max <= 0 || len(bt.items) < b.max {
+		b.mu.Unlock()
+		return true
+	}
+
+	bt.timer.Stop()
+	b.remove(key, bt)
+	b.flushes.Add(1)
+	b.mu.Unlock()
+
+	defer b.flushes.Done()
+	b.flush(ctx, key, bt.items)
+
+	return true
+}
+
+// Close немедленно сбрасывает все накопленные дайджесты и ждёт окончания сбросов,
+// начатых раньше, пока не истечёт ctx. Добавить элементы после Close нельзя.
+func (b *Buffer) Close(ctx context.Context) {
+	b.mu.Lock()
+	b.closed = true
+	batches := b.batches
+	b.batches = make(map[Key]*batch)
+	b.size = 0
+	for _, bt := range batches {
+		bt.timer.Stop()
+	}
+	b.mu.Unlock()
+
+	for key, bt := range batches {
+		b.flush(ctx, key, bt.items)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		b.flushes.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+func (b *Buffer) flushExpired(key Key, bt *batch) {
+	b.mu.Lock()
+	if b.batches[key] != bt {
+		// Уже сброшен по количеству или при закрытии
+		b.mu.Unlock()
+		return
+	}
+	b.remove(key, bt)
+	b.flushes.Add(1)
+	b.mu.Unlock()
+
+	defer b.flushes.Done()
+	b.flush(context.Background(), key, bt.items)
+}
+
+// remove убирает дайджест из буфера перед сбросом. Вызывается под mu.
+func (b *Buffer) remove(key Key, bt *batch) {
+	delete(b.batches, key)
+	b.size -= len(bt.items)
+}
+
+// Item - элемент дайджеста, доступный в шаблоне.
+type Item struct {
+	Message     string
+	ScheduledAt time.Time
+}
+
+// Renderer собирает текст дайджеста по шаблону text/template.
+// В шаблон передаются поля Count и Items.
+type Renderer struct {
+	tmpl *template.Template
+}
+
+func NewRenderer(text string) (*Renderer, error) {
+	if text == "" {
+		text = DefaultTemplate
+	}
+
+	tmpl, err := template.New("digest").Parse(text)
+	if err != nil {
+		return nil, errutils.Wrap("failed to parse digest template", err)
+	}
+
+	return &Renderer{tmpl: tmpl}, nil
+}
+
+func (r *Renderer) Render(items []notifier.Message) (string, error) {
+	data := struct {
+		Count int
+		Items []Item
+	}{Count: len(items)}
+
+	for _, item := range items {
+		data.Items = append(data.Items, Item{Message: item.Message, ScheduledAt: item.ScheduledAt})
+	}
+	sort.SliceStable(data.Items, func(i, j int) bool {
+		return data.Items[i].ScheduledAt.Before(data.Items[j].ScheduledAt)
+	})
+
+	var buf bytes.Buffer
+	if err := r.tmpl.Execute(&buf, data); err != nil {
+		return "", errutils.Wrap("failed to render digest", err)
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,243 @@
+package digest
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/rabbitmq/notifier"
+	"github.com/google/uuid"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type nopAcker struct{}
+
+func (nopAcker) Ack() error              { return nil }
+func (nopAcker) Nack(requeue bool) error { return nil }
+
+func delivery(recipient string) notifier.Delivery {
+	return notifier.NewDelivery(notifier.Message{
+		ID:          uuid.New(),
+		Message:     "reminder",
+		ScheduledAt: time.Now(),
+		Channel:     "email",
+		Recipient:   recipient,
+		Digest:      true,
+	}, nopAcker{})
+}
+
+type flushed struct {
+	key   Key
+	items []notifier.Delivery
+}
+
+type recorder struct {
+	mu      sync.Mutex
+	flushes []flushed
+	done    chan struct{}
+}
+
+func newRecorder() *recorder {
+	return &recorder{done: make(chan struct{}, 16)}
+}
+
+func (r *recorder) flush(_ context.Context, key Key, items []notifier.Delivery) {
+	r.mu.Lock()
+	r.flushes = append(r.flushes, flushed{key: key, items: items})
+	r.mu.Unlock()
+	r.done <- struct{}{}
+}
+
+func (r *recorder) get() []flushed {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]flushed(nil), r.flushes...)
+}
+
+func TestBufferFlushesWhenFull(t *testing.T) {
+	rec := newRecorder()
+	b := NewBuffer(time.Hour, 3, 100, rec.flush)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if !b.Add(ctx, delivery("a@example.com")) {
+			t.Fatalf("Add %d rejected", i)
+		}
+	}
+	b.Add(ctx, delivery("b@example.com"))
+
+	flushes := rec.get()
+	if len(flushes) != 1 {
+		t.Fatalf("flushes: got %d, want 1", len(flushes))
+	}
+	if flushes[0].key.Recipient != "a@example.com" || len(flushes[0].items) != 3 {
+		t.Fatalf("flush: got %s with %d items", flushes[0].key.Recipient, len(flushes[0].items))
+	}
+}
+
+func TestBufferFlushesAfterWindow(t *testing.T) {
+	rec := newRecorder()
+	b := NewBuffer(20*time.Millisecond, 10, 100, rec.flush)
+
+	b.Add(context.Background(), delivery("a@example.com"))
+	b.Add(context.Background(), delivery("a@example.com"))
+
+	select {
+	case <-rec.done:
+	case <-time.After(time.Second):
+		t.Fatal("digest was not flushed after the window")
+	}
+
+	if flushes := rec.get(); len(flushes[0].items) != 2 {
+		t.Fatalf("items: got %d, want 2", len(flushes[0].items))
+	}
+}
+
+func TestBufferCapacity(t *testing.T) {
+	rec := newRecorder()
+	b := NewBuffer(50*time.Millisecond, 10, 3, rec.flush)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		b.Add(ctx, delivery("a@example.com"))
+	}
+
+	if b.Add(ctx, delivery("b@example.com")) {
+		t.Fatal("Add accepted an item over capacity")
+	}
+	if len(rec.get()) != 0 {
+		t.Fatal("rejected item triggered a flush")
+	}
+
+	// Сброс дайджеста освобождает место
+	select {
+	case <-rec.done:
+	case <-time.After(time.Second):
+		t.Fatal("digest was not flushed after the window")
+	}
+	if !b.Add(ctx, delivery("b@example.com")) {
+		t.Fatal("Add rejected an item after a flush freed capacity")
+	}
+	b.Close(ctx)
+}
+
+func TestBufferCloseFlushesEverything(t *testing.T) {
+	rec := newRecorder()
+	b := NewBuffer(time.Hour, 10, 100, rec.flush)
+	ctx := context.Background()
+
+	b.Add(ctx, delivery("a@example.com"))
+	b.Add(ctx, delivery("b@example.com"))
+	b.Add(ctx, delivery("b@example.com"))
+
+	b.Close(ctx)
+
+	total := 0
+	for _, f := range rec.get() {
+		total += len(f.items)
+	}
+	if total != 3 {
+		t.Fatalf("flushed items: got %d, want 3", total)
+	}
+}
+
+func TestBufferRejectsAddAfterClose(t *testing.T) {
+	rec := newRecorder()
+	b := NewBuffer(10*time.Millisecond, 10, 100, rec.flush)
+	ctx := context.Background()
+
+	b.Close(ctx)
+
+	if b.Add(ctx, delivery("a@example.com")) {
+		t.Fatal("Add accepted an item after Close")
+	}
+
+	// Таймер окна не запущен, сбрасывать нечего
+	time.Sleep(50 * time.Millisecond)
+	if len(rec.get()) != 0 {
+		t.Fatal("item added after Close was flushed")
+	}
+}
+
+func TestBufferCloseWaitsForInFlightFlush(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	b := NewBuffer(10*time.Millisecond, 10, 100, func(context.Context, Key, []notifier.Delivery) {
+		close(started)
+		<-release
+	})
+
+	b.Add(context.Background(), delivery("a@example.com"))
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		b.Close(context.Background())
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("Close returned while a flush was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return after the flush finished")
+	}
+}
+
+func TestBufferCloseGivesUpAtDeadline(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	b := NewBuffer(10*time.Millisecond, 10, 100, func(context.Context, Key, []notifier.Delivery) {
+		close(started)
+		<-release
+	})
+
+	b.Add(context.Background(), delivery("a@example.com"))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	closed := make(chan struct{})
+	go func() {
+		b.Close(ctx)
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return at the context deadline")
+	}
+}
+
+func TestRendererSortsItemsBySchedule(t *testing.T) {
+	r, err := NewRenderer("")
+	if err != nil {
+		t.Fatalf("NewRenderer: %v", err)
+	}
+
+	now := time.Now()
+	text, err := r.Render([]notifier.Message{
+		{Message: "second", ScheduledAt: now.Add(time.Minute)},
+		{Message: "first", ScheduledAt: now},
+	})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+
+	if !strings.HasPrefix(text, "You have 2 reminders:") {
+		t.Fatalf("text: %q", text)
+	}
+	if strings.Index(text, "first") > strings.Index(text, "second") {
+		t.Fatalf("items are not sorted: %q", text)
+	}
+}
@@ -0,0 +1,241 @@
+package broker
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/rabbitmq/notifier"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/retry"
+	"github.com/wb-go/wbf/zlog"
+	"sync"
+	"time"
+)
+
+type Opts struct {
+	Tick      time.Duration
+	WheelSize int
+}
+
+// Broker - внутрипроцессный планировщик и брокер уведомлений для разработки
+// и тестов. Реализует те же интерфейсы, что и notifier.Notifier.
+type Broker struct {
+	mu     sync.Mutex
+	wheel  *timingWheel
+	ready  []notifier.Message
+	dead   []notifier.DeadLetter
+	signal chan struct{}
+	tick   time.Duration
+}
+
+func New(opts Opts) *Broker {
+	if opts.Tick <= 0 {
+		opts.Tick = 10 * time.Millisecond
+	}
+	if opts.WheelSize <= 0 {
+		opts.WheelSize = 64
+	}
+
+	return &Broker{
+		wheel:  newTimingWheel(opts.Tick, opts.WheelSize, time.Now()),
+		signal: make(chan struct{}, 1),
+		tick:   opts.Tick,
+	}
+}
+
+func (b *Broker) Publish(notification notifier.Message, _ retry.Strategy) error {
+	e := &entry{
+		expiration: notification.ScheduledAt.UnixMilli(),
+		message:    notification,
+	}
+
+	b.mu.Lock()
+	scheduled := b.wheel.add(e)
+	if !scheduled {
+		b.ready = append(b.ready, notification)
+	}
+	b.mu.Unlock()
+
+	if !scheduled {
+		b.notify()
+	}
+
+	return nil
+}
+
+func (b *Broker) Consume(ctx context.Context, deliveries chan notifier.Delivery, _ retry.Strategy) error {
+	defer close(deliveries)
+
+	go b.run(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			zlog.Logger.Info().Msg("consumer shutdown...")
+			return nil
+		case <-b.signal:
+		}
+
+		b.mu.Lock()
+		ready := b.ready
+		b.ready = nil
+		b.mu.Unlock()
+
+		for i, notification := range ready {
+			select {
+			case <-ctx.Done():
+				b.requeueFront(ready[i:])
+				zlog.Logger.Info().Msg("consumer shutdown...")
+				return nil
+			case deliveries <- notifier.NewDelivery(notification, memoryAcker{broker: b, message: notification}):
+			}
+		}
+	}
+}
+
+// run крутит колесо с периодом tick и переносит наступившие сообщения в ready.
+func (b *Broker) run(ctx context.Context) {
+	ticker := time.NewTicker(b.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.mu.Lock()
+			expired := b.wheel.advance(now.UnixMilli())
+			for _, e := range expired {
+				b.ready = append(b.ready, e.message)
+			}
+			b.mu.Unlock()
+
+			if len(expired) > 0 {
+				b.notify()
+			}
+		}
+	}
+}
+
+func (b *Broker) requeueFront(messages []notifier.Message) {
+	b.mu.Lock()
+	b.ready = append(messages, b.ready...)
+	b.mu.Unlock()
+}
+
+func (b *Broker) notify() {
+	select {
+	case b.signal <- struct{}{}:
+	default:
+	}
+}
+
+// memoryAcker возвращает неподтверждённое сообщение в начало ready или откладывает в dead.
+type memoryAcker struct {
+	broker  *Broker
+	message notifier.Message
+}
+
+func (a memoryAcker) Ack() error {
+	return nil
+}
+
+func (a memoryAcker) Nack(requeue bool) error {
+	if requeue {
+		a.broker.requeueFront([]notifier.Message{a.message})
+		a.broker.notify()
+		return nil
+	}
+
+	body, err := json.Marshal(a.message)
+	if err != nil {
+		return err
+	}
+
+	letter := notifier.NewDeadLetter(uuid.NewString(), body)
+	now := time.Now().UTC()
+	letter.Reason = "rejected"
+	letter.Count = 1
+	letter.DiedAt = &now
+
+	a.broker.mu.Lock()
+	a.broker.dead = append(a.broker.dead, letter)
+	a.broker.mu.Unlock()
+
+	return nil
+}
+
+func (b *Broker) DeadLetters(_ context.Context, limit int) ([]notifier.DeadLetter, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	letters := make([]notifier.DeadLetter, 0, min(limit, len(b.dead)))
+	for _, letter := range b.dead {
+		if len(letters) == limit {
+			break
+		}
+		letters = append(letters, letter)
+	}
+
+	return letters, nil
+}
+
+func (b *Broker) DeadLetter(_ context.Context, ID string) (notifier.DeadLetter, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, letter := range b.dead {
+		if letter.ID == ID {
+			return letter, nil
+		}
+	}
+
+	return notifier.DeadLetter{}, notifier.ErrDeadLetterNotFound
+}
+
+func (b *Broker) ReplayDeadLetters(ctx context.Context, IDs []string, replay notifier.ReplayFunc) error {
+	for _, id := range IDs {
+		letter, err := b.DeadLetter(ctx, id)
+		if err != nil {
+			continue
+		}
+		if replay(letter) == nil {
+			b.removeDead(id)
+		}
+	}
+
+	return nil
+}
+
+// PurgeDeadLetters удаляет выбранные сообщения DLQ, при пустом IDs - все.
+func (b *Broker) PurgeDeadLetters(_ context.Context, IDs []string) (int, error) {
+	if len(IDs) == 0 {
+		b.mu.Lock()
+		purged := len(b.dead)
+		b.dead = nil
+		b.mu.Unlock()
+		return purged, nil
+	}
+
+	purged := 0
+	for _, id := range IDs {
+		if b.removeDead(id) {
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (b *Broker) removeDead(ID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, letter := range b.dead {
+		if letter.ID == ID {
+			b.dead = append(b.dead[:i], b.dead[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
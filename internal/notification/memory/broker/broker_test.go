@@ -0,0 +1,68 @@
+package broker
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/rabbitmq/notifier"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/retry"
+	"testing"
+	"time"
+)
+
+// consume запускает консьюмер брокера и возвращает канал сообщений.
+func consume(t *testing.T, b *Broker) chan notifier.Delivery {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	deliveries := make(chan notifier.Delivery)
+	go func() {
+		_ = b.Consume(ctx, deliveries, retry.Strategy{})
+	}()
+	t.Cleanup(cancel)
+
+	return deliveries
+}
+
+func receive(t *testing.T, deliveries chan notifier.Delivery) notifier.Delivery {
+	t.Helper()
+
+	select {
+	case delivery := <-deliveries:
+		return delivery
+	case <-time.After(time.Second):
+		t.Fatal("no delivery")
+		return notifier.Delivery{}
+	}
+}
+
+func TestRequeueAndReject(t *testing.T) {
+	b := New(Opts{Tick: 5 * time.Millisecond})
+	deliveries := consume(t, b)
+
+	message := notifier.Message{ID: uuid.New(), ScheduledAt: time.Now()}
+	if err := b.Publish(message, retry.Strategy{}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	// Возвращённое в очередь сообщение приходит повторно
+	receive(t, deliveries).Requeue()
+	delivery := receive(t, deliveries)
+	if delivery.Message.ID != message.ID {
+		t.Fatalf("redelivered: got %s, want %s", delivery.Message.ID, message.ID)
+	}
+
+	// Отклонённое попадает в DLQ
+	delivery.Reject()
+	letters, err := b.DeadLetters(context.Background(), 10)
+	if err != nil || len(letters) != 1 {
+		t.Fatalf("DeadLetters: %v, %v", letters, err)
+	}
+
+	purged, err := b.PurgeDeadLetters(context.Background(), []string{letters[0].ID})
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeDeadLetters: %d, %v", purged, err)
+	}
+	if letters, _ := b.DeadLetters(context.Background(), 10); len(letters) != 0 {
+		t.Fatalf("dead letters after purge: %v", letters)
+	}
+}
@@ -0,0 +1,172 @@
+package broker_test
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/cache"
+	"delayed-notifier/internal/notification/memory/broker"
+	"delayed-notifier/internal/notification/rabbitmq/handler"
+	"delayed-notifier/internal/notification/ratelimit"
+	"delayed-notifier/internal/notification/repo/memory"
+	"delayed-notifier/internal/notification/senders"
+	"delayed-notifier/internal/notification/service"
+	"delayed-notifier/internal/notification/types/domain"
+	"delayed-notifier/internal/notification/types/dto"
+	"delayed-notifier/internal/notification/worker"
+	"github.com/wb-go/wbf/retry"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testStrategy = retry.Strategy{Attempts: 1}
+
+// sender запоминает отправленные сообщения.
+type sender struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (s *sender) Send(_ context.Context, message string, _ string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, message)
+	return nil
+}
+
+func (s *sender) get() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.sent...)
+}
+
+// app - сервис, пул воркеров и обработчик поверх памяти, как в main с --backend=memory.
+type app struct {
+	service *service.Notification
+	sender  *sender
+	pool    *worker.WorkerPool
+	cancel  context.CancelFunc
+}
+
+func startApp(t *testing.T) *app {
+	t.Helper()
+
+	a := &app{sender: &sender{}}
+	memoryBroker := broker.New(broker.Opts{Tick: 5 * time.Millisecond})
+	a.service = service.NewNotification(
+		memory.New(),
+		memoryBroker,
+		cache.NewMemory(cache.Opts{}),
+		senders.New(a.sender),
+		service.Opts{},
+	)
+	msgsHandler := handler.New(a.service, ratelimit.NewMemory(ratelimit.Rules{}), ratelimit.PolicyDefer, handler.DigestOpts{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	a.pool = worker.NewWorkerPool(memoryBroker, msgsHandler, 2)
+	a.pool.Start(ctx, testStrategy)
+
+	t.Cleanup(func() {
+		cancel()
+		drainCtx, drainCancel := context.WithTimeout(context.Background(), time.Second)
+		defer drainCancel()
+		a.pool.Drain(drainCtx)
+	})
+
+	return a
+}
+
+// create создаёт уведомление через сервис и публикует его из outbox.
+func (a *app) create(t *testing.T, message string, scheduledAt time.Time) string {
+	t.Helper()
+
+	ID, err := a.service.Create(context.Background(), dto.Notification{
+		Message:     message,
+		ScheduledAt: scheduledAt.Format(time.RFC3339Nano),
+		Channel:     "email",
+		Recipient:   "user@example.com",
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if published, err := a.service.RelayOutbox(context.Background(), 10, testStrategy); err != nil || published != 1 {
+		t.Fatalf("RelayOutbox: published %d, %v", published, err)
+	}
+
+	return ID
+}
+
+func (a *app) status(t *testing.T, ID string) domain.NotificationStatus {
+	t.Helper()
+
+	notification, err := a.service.GetByID(context.Background(), ID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	return notification.Status
+}
+
+func (a *app) waitStatus(t *testing.T, ID string, want domain.NotificationStatus) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for a.status(t, ID) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("status: got %s, want %s", a.status(t, ID), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDueNotificationIsSent(t *testing.T) {
+	a := startApp(t)
+
+	ID := a.create(t, "now", time.Now().Add(-time.Second))
+
+	a.waitStatus(t, ID, domain.Sent)
+	if sent := a.sender.get(); len(sent) != 1 || sent[0] != "now" {
+		t.Fatalf("sent: got %v, want [now]", sent)
+	}
+}
+
+func TestDelayedNotificationWaitsForSchedule(t *testing.T) {
+	a := startApp(t)
+
+	scheduledAt := time.Now().Add(200 * time.Millisecond)
+	ID := a.create(t, "later", scheduledAt)
+
+	// До срока уведомление лежит в колесе брокера
+	time.Sleep(100 * time.Millisecond)
+	if sent := a.sender.get(); len(sent) != 0 {
+		t.Fatalf("sent before schedule: %v", sent)
+	}
+	if status := a.status(t, ID); status != domain.Scheduled {
+		t.Fatalf("status before schedule: got %s, want %s", status, domain.Scheduled)
+	}
+
+	a.waitStatus(t, ID, domain.Sent)
+	if time.Now().Before(scheduledAt) {
+		t.Fatal("sent before scheduled_at")
+	}
+}
+
+func TestCanceledNotificationIsNotSent(t *testing.T) {
+	a := startApp(t)
+
+	ID := a.create(t, "canceled", time.Now().Add(100*time.Millisecond))
+	if _, err := a.service.Cancel(context.Background(), ID, "user", "changed plans", false, testStrategy); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+
+	// Сообщение всё равно придёт из брокера, но обработчик его не отправит
+	marker := a.create(t, "marker", time.Now().Add(150*time.Millisecond))
+	a.waitStatus(t, marker, domain.Sent)
+
+	if status := a.status(t, ID); status != domain.Canceled {
+		t.Fatalf("status: got %s, want %s", status, domain.Canceled)
+	}
+	if sent := a.sender.get(); len(sent) != 1 || sent[0] != "marker" {
+		t.Fatalf("sent: got %v, want [marker]", sent)
+	}
+}
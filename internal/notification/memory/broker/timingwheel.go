@@ -0,0 +1,102 @@
+package broker
+
+import (
+	"delayed-notifier/internal/notification/rabbitmq/notifier"
+	"time"
+)
+
+// entry - запланированное сообщение внутри колеса.
+type entry struct {
+	expiration int64 // unix ms
+	message    notifier.Message
+}
+
+// timingWheel - иерархическое колесо таймеров. Каждый уровень содержит size
+// корзин шириной tick; сообщения дальше горизонта уровня уходят на следующий
+// уровень (overflow) с tick, равным интервалу предыдущего. Когда корзина
+// верхнего уровня наступает, её сообщения заново раскладываются с корня
+// и опускаются на нижние уровни.
+type timingWheel struct {
+	tick     int64 // ms
+	size     int64
+	interval int64 // tick * size
+	current  int64 // начало текущей корзины, кратно tick
+	buckets  [][]*entry
+	overflow *timingWheel
+}
+
+func newTimingWheel(tick time.Duration, size int, start time.Time) *timingWheel {
+	return newLevel(tick.Milliseconds(), int64(size), start.UnixMilli())
+}
+
+func newLevel(tick, size, start int64) *timingWheel {
+	return &timingWheel{
+		tick:     tick,
+		size:     size,
+		interval: tick * size,
+		current:  start - start%tick,
+		buckets:  make([][]*entry, size),
+	}
+}
+
+// add кладёт запись в подходящую корзину. Возвращает false, если время
+// записи уже наступило и её нужно отдать сразу.
+func (w *timingWheel) add(e *entry) bool {
+	switch {
+	case e.expiration < w.current+w.tick:
+		return false
+	case e.expiration < w.current+w.interval:
+		idx := (e.expiration / w.tick) % w.size
+		w.buckets[idx] = append(w.buckets[idx], e)
+		return true
+	default:
+		if w.overflow == nil {
+			w.overflow = newLevel(w.interval, w.size, w.current)
+		}
+		return w.overflow.add(e)
+	}
+}
+
+// advance переводит колесо на момент now (unix ms) и возвращает записи,
+// время которых наступило.
+func (w *timingWheel) advance(now int64) []*entry {
+	var expired []*entry
+	reinsert := func(e *entry) {
+		if !w.add(e) {
+			expired = append(expired, e)
+		}
+	}
+
+	w.advanceLevel(now, func(e *entry) {
+		expired = append(expired, e)
+	})
+
+	for level := w.overflow; level != nil; level = level.overflow {
+		level.advanceLevel(now, reinsert)
+	}
+
+	return expired
+}
+
+// advanceLevel прокручивает один уровень до now, передавая содержимое
+// пройденных корзин в flush.
+func (w *timingWheel) advanceLevel(now int64, flush func(*entry)) {
+	target := now - now%w.tick
+
+	// После долгого простоя достаточно одного полного оборота: все корзины
+	// уровня к этому моменту уже наступили.
+	steps := (target - w.current) / w.tick
+	if steps > w.size {
+		w.current = target - w.size*w.tick
+	}
+
+	for w.current < target {
+		w.current += w.tick
+		idx := (w.current / w.tick) % w.size
+		bucket := w.buckets[idx]
+		w.buckets[idx] = nil
+		for _, e := range bucket {
+			flush(e)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package outbox
+
+import (
+	"context"
+	"github.com/wb-go/wbf/retry"
+	"github.com/wb-go/wbf/zlog"
+	"time"
+)
+
+type Notification interface {
+	RelayOutbox(ctx context.Context, limit int, strategy retry.Strategy) (int, error)
+}
+
+type Opts struct {
+	PollInterval time.Duration
+	BatchSize    int
+}
+
+// Relay переносит уведомления из outbox в брокер. Запись outbox создаётся в одной
+// транзакции с уведомлением и удаляется только после подтверждённой публикации,
+// поэтому каждое созданное уведомление публикуется хотя бы один раз.
+type Relay struct {
+	notification Notification
+	strategy     retry.Strategy
+	opts         Opts
+}
+
+func New(notification Notification, strategy retry.Strategy, opts Opts) *Relay {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 500 * time.Millisecond
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+
+	return &Relay{
+		notification: notification,
+		strategy:     strategy,
+		opts:         opts,
+	}
+}
+
+// Start разбирает outbox с периодом PollInterval, пока не отменён ctx.
+func (r *Relay) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		r.drain(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain публикует пачки, пока outbox не опустеет или публикация не сломается.
+func (r *Relay) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		published, err := r.notification.RelayOutbox(ctx, r.opts.BatchSize, r.strategy)
+		if err != nil {
+			zlog.Logger.Error().Err(err).Int("published", published).Msg("failed to relay outbox")
+			return
+		}
+		if published < r.opts.BatchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package connection
+
+import (
+	"context"
+	"delayed-notifier/pkg/errutils"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/wb-go/wbf/zlog"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotConnected = errors.New("rabbitmq is not connected")
+	ErrClosed       = errors.New("connection manager is closed")
+)
+
+// SetupFunc готовит новый канал: объявляет топологию, включает подтверждения и т.п.
+// Вызывается после каждого (пере)подключения. conn - для служебных каналов,
+// например для проверок, после неудачи которых брокер закрывает канал.
+type SetupFunc func(conn *amqp.Connection, channel *amqp.Channel) error
+
+type Opts struct {
+	MinBackoff time.Duration // пауза перед первой попыткой переподключения
+	MaxBackoff time.Duration // предел удваивающейся паузы
+}
+
+// Manager держит соединение и канал RabbitMQ и восстанавливает их после обрыва:
+// переподключается с экспоненциальной паузой и заново выполняет SetupFunc.
+type Manager struct {
+	url   string
+	opts  Opts
+	setup []SetupFunc
+
+	mu      sync.RWMutex
+	conn    *amqp.Connection
+	channel *amqp.Channel
+	up      chan struct{} // закрыт, пока соединение установлено
+	closed  bool
+}
+
+func New(url string, opts Opts) *Manager {
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = time.Second
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = 30 * time.Second
+	}
+
+	return &Manager{
+		url:  url,
+		opts: opts,
+		up:   make(chan struct{}),
+	}
+}
+
+// OnConnect добавляет настройку канала. Регистрировать до Connect.
+func (m *Manager) OnConnect(setup SetupFunc) {
+	m.setup = append(m.setup, setup)
+}
+
+// Connect устанавливает первое соединение, делая до attempts попыток.
+func (m *Manager) Connect(attempts int) error {
+	var err error
+	for i := 0; i < max(attempts, 1); i++ {
+		if err = m.connect(); err == nil {
+			return nil
+		}
+		zlog.Logger.Warn().Err(err).Int("attempt", i+1).Msg("failed to connect to RabbitMQ")
+		time.Sleep(m.backoff(i))
+	}
+
+	return errutils.Wrap("failed to connect to RabbitMQ", err)
+}
+
+// Start следит за соединением и переподключается после обрыва, пока не отменён ctx.
+func (m *Manager) Start(ctx context.Context) {
+	for {
+		m.mu.RLock()
+		conn, channel := m.conn, m.channel
+		m.mu.RUnlock()
+
+		if conn == nil {
+			return
+		}
+
+		connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		channelClosed := channel.NotifyClose(make(chan *amqp.Error, 1))
+
+		var reason *amqp.Error
+		select {
+		case <-ctx.Done():
+			return
+		case reason = <-connClosed:
+		case reason = <-channelClosed:
+		}
+
+		if !m.markDown() {
+			return
+		}
+		zlog.Logger.Error().Interface("reason", reason).Msg("RabbitMQ connection lost, reconnecting")
+
+		if !m.reconnect(ctx) {
+			return
+		}
+		zlog.Logger.Info().Msg("RabbitMQ connection restored")
+	}
+}
+
+// Channel возвращает текущий канал или ErrNotConnected, не дожидаясь переподключения.
+func (m *Manager) Channel() (*amqp.Channel, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+	if m.channel == nil || m.channel.IsClosed() || !m.isUp() {
+		return nil, ErrNotConnected
+	}
+
+	return m.channel, nil
+}
+
+// OpenChannel открывает отдельный канал на текущем соединении для операций,
+// которые не должны занимать общий канал. Закрыть канал должен вызывающий.
+func (m *Manager) OpenChannel() (*amqp.Channel, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+	if m.conn == nil || m.conn.IsClosed() || !m.isUp() {
+		return nil, ErrNotConnected
+	}
+
+	return m.conn.Channel()
+}
+
+// Wait ждёт установленного соединения и возвращает текущий канал.
+func (m *Manager) Wait(ctx context.Context) (*amqp.Channel, error) {
+	for {
+		m.mu.RLock()
+		up, closed := m.up, m.closed
+		m.mu.RUnlock()
+
+		if closed {
+			return nil, ErrClosed
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-up:
+		}
+
+		if channel, err := m.Channel(); err == nil {
+			return channel, nil
+		}
+	}
+}
+
+// Ready - установлено ли соединение.
+func (m *Manager) Ready() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return !m.closed && m.isUp()
+}
+
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+
+	var errs []error
+	if m.channel != nil && !m.channel.IsClosed() {
+		errs = append(errs, m.channel.Close())
+	}
+	if m.conn != nil && !m.conn.IsClosed() {
+		errs = append(errs, m.conn.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m *Manager) connect() error {
+	conn, err := amqp.Dial(m.url)
+	if err != nil {
+		return err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	for _, setup := range m.setup {
+		if err := setup(conn, channel); err != nil {
+			_ = conn.Close()
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		_ = conn.Close()
+		return ErrClosed
+	}
+	m.conn, m.channel = conn, channel
+	close(m.up)
+
+	return nil
+}
+
+func (m *Manager) reconnect(ctx context.Context) bool {
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(m.backoff(attempt)):
+		}
+
+		err := m.connect()
+		if err == nil {
+			return true
+		}
+		if errors.Is(err, ErrClosed) {
+			return false
+		}
+		zlog.Logger.Warn().Err(err).Int("attempt", attempt+1).Msg("failed to reconnect to RabbitMQ")
+	}
+}
+
+// markDown переводит менеджер в состояние "нет соединения". Возвращает false после Close.
+func (m *Manager) markDown() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return false
+	}
+	if m.isUp() {
+		m.up = make(chan struct{})
+	}
+	if m.conn != nil && !m.conn.IsClosed() {
+		// Закрылся только канал - соединение пересоздаём целиком
+		_ = m.conn.Close()
+	}
+
+	return true
+}
+
+// isUp вызывается под m.mu
+func (m *Manager) isUp() bool {
+	select {
+	case <-m.up:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *Manager) backoff(attempt int) time.Duration {
+	delay := m.opts.MaxBackoff
+	if attempt < 32 {
+		if d := m.opts.MinBackoff << attempt; d > 0 && d < delay {
+			delay = d
+		}
+	}
+	return delay
+}
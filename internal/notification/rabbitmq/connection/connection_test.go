@@ -0,0 +1,330 @@
+package connection
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// AMQP 0-9-1: типы кадров и методы, которые понимает fakeBroker.
+const (
+	frameMethod    = 1
+	frameHeartbeat = 8
+	frameEnd       = 0xCE
+)
+
+type method struct{ class, id uint16 }
+
+var (
+	connectionStart   = method{10, 10}
+	connectionStartOk = method{10, 11}
+	connectionTune    = method{10, 30}
+	connectionOpen    = method{10, 40}
+	connectionOpenOk  = method{10, 41}
+	connectionClose   = method{10, 50}
+	connectionCloseOk = method{10, 51}
+	channelOpen       = method{20, 10}
+	channelOpenOk     = method{20, 11}
+	channelClose      = method{20, 40}
+	channelCloseOk    = method{20, 41}
+)
+
+// fakeBroker - брокер AMQP, который умеет только установить соединение и
+// открыть каналы. Тест обрывает соединения и запрещает новые, как при рестарте.
+type fakeBroker struct {
+	listener net.Listener
+	refuse   atomic.Bool
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	b := &fakeBroker{listener: listener}
+	t.Cleanup(func() {
+		_ = listener.Close()
+		b.drop()
+	})
+
+	go b.accept()
+
+	return b
+}
+
+func (b *fakeBroker) url() string {
+	return "amqp://guest:guest@" + b.listener.Addr().String() + "/"
+}
+
+func (b *fakeBroker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		if b.refuse.Load() {
+			_ = conn.Close()
+			continue
+		}
+
+		b.mu.Lock()
+		b.conns = append(b.conns, conn)
+		b.mu.Unlock()
+
+		go b.serve(conn)
+	}
+}
+
+// drop обрывает все открытые соединения.
+func (b *fakeBroker) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, conn := range b.conns {
+		_ = conn.Close()
+	}
+	b.conns = nil
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return
+	}
+
+	// connection.start: версия 0-9, пустые свойства, PLAIN, en_US
+	start := []byte{0, 9}
+	start = binary.BigEndian.AppendUint32(start, 0)
+	start = appendLongString(start, "PLAIN")
+	start = appendLongString(start, "en_US")
+	if writeMethod(conn, 0, connectionStart, start) != nil {
+		return
+	}
+
+	for {
+		typ, channel, payload, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		if typ == frameHeartbeat {
+			continue
+		}
+		if typ != frameMethod || len(payload) < 4 {
+			return
+		}
+
+		m := method{binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:])}
+		switch m {
+		case connectionStartOk:
+			// channel-max, frame-max, heartbeat
+			tune := binary.BigEndian.AppendUint16(nil, 0)
+			tune = binary.BigEndian.AppendUint32(tune, 131072)
+			tune = binary.BigEndian.AppendUint16(tune, 0)
+			err = writeMethod(conn, 0, connectionTune, tune)
+		case connectionOpen:
+			err = writeMethod(conn, 0, connectionOpenOk, []byte{0})
+		case channelOpen:
+			err = writeMethod(conn, channel, channelOpenOk, binary.BigEndian.AppendUint32(nil, 0))
+		case channelClose:
+			err = writeMethod(conn, channel, channelCloseOk, nil)
+		case connectionClose:
+			_ = writeMethod(conn, 0, connectionCloseOk, nil)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func readFrame(r *bufio.Reader) (byte, uint16, []byte, error) {
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, nil, err
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint32(header[3:])+1)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	if payload[len(payload)-1] != frameEnd {
+		return 0, 0, nil, fmt.Errorf("bad frame end %x", payload[len(payload)-1])
+	}
+
+	return header[0], binary.BigEndian.Uint16(header[1:]), payload[:len(payload)-1], nil
+}
+
+func writeMethod(w io.Writer, channel uint16, m method, args []byte) error {
+	payload := binary.BigEndian.AppendUint16(nil, m.class)
+	payload = binary.BigEndian.AppendUint16(payload, m.id)
+	payload = append(payload, args...)
+
+	frame := []byte{frameMethod}
+	frame = binary.BigEndian.AppendUint16(frame, channel)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(payload)))
+	frame = append(frame, payload...)
+	frame = append(frame, frameEnd)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+func appendLongString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestManagerReconnects(t *testing.T) {
+	broker := newFakeBroker(t)
+	m := New(broker.url(), Opts{MinBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond})
+
+	var setups atomic.Int32
+	m.OnConnect(func(conn *amqp.Connection, channel *amqp.Channel) error {
+		setups.Add(1)
+		return nil
+	})
+
+	if err := m.Connect(1); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer m.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Start(ctx)
+
+	first, err := m.Channel()
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+
+	// Брокер перезапускается: соединение рвётся, новые пока не принимаются
+	broker.refuse.Store(true)
+	broker.drop()
+
+	eventually(t, "connection loss", func() bool { return !m.Ready() })
+	if _, err := m.Channel(); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("Channel while down: got %v, want ErrNotConnected", err)
+	}
+
+	waitCtx, waitCancel := context.WithCancel(context.Background())
+	defer waitCancel()
+	waited := make(chan *amqp.Channel, 1)
+	go func() {
+		channel, _ := m.Wait(waitCtx)
+		waited <- channel
+	}()
+
+	broker.refuse.Store(false)
+	eventually(t, "reconnect", m.Ready)
+
+	if got := setups.Load(); got != 2 {
+		t.Fatalf("OnConnect runs: got %d, want 2", got)
+	}
+
+	second, err := m.Channel()
+	if err != nil {
+		t.Fatalf("Channel after reconnect: %v", err)
+	}
+	if second == first {
+		t.Fatal("Channel after reconnect returned the old channel")
+	}
+
+	select {
+	case channel := <-waited:
+		if channel != second {
+			t.Fatal("Wait returned a channel other than the restored one")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return after reconnect")
+	}
+}
+
+func TestManagerFailedSetupIsRetried(t *testing.T) {
+	broker := newFakeBroker(t)
+	m := New(broker.url(), Opts{MinBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond})
+
+	var setups atomic.Int32
+	m.OnConnect(func(conn *amqp.Connection, channel *amqp.Channel) error {
+		// Вторая настройка, после первого обрыва, не удаётся
+		if setups.Add(1) == 2 {
+			return errors.New("declare failed")
+		}
+		return nil
+	})
+
+	if err := m.Connect(1); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer m.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Start(ctx)
+
+	broker.drop()
+	eventually(t, "reconnect after failed setup", func() bool { return m.Ready() && setups.Load() >= 3 })
+}
+
+func TestManagerClose(t *testing.T) {
+	broker := newFakeBroker(t)
+	m := New(broker.url(), Opts{MinBackoff: 10 * time.Millisecond})
+
+	if err := m.Connect(1); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan struct{})
+	go func() {
+		m.Start(ctx)
+		close(started)
+	}()
+
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Закрытие менеджера не считается обрывом: переподключения нет
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after Close")
+	}
+	if _, err := m.Channel(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Channel after Close: got %v, want ErrClosed", err)
+	}
+	if _, err := m.Wait(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Wait after Close: got %v, want ErrClosed", err)
+	}
+}
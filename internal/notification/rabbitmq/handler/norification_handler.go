@@ -2,66 +2,480 @@ package handler
 
 import (
 	"context"
+	"delayed-notifier/internal/notification/breaker"
+	"delayed-notifier/internal/notification/digest"
 	"delayed-notifier/internal/notification/rabbitmq/notifier"
+	"delayed-notifier/internal/notification/ratelimit"
 	"delayed-notifier/internal/notification/service"
+	"delayed-notifier/internal/notification/types/domain"
 	"delayed-notifier/internal/notification/types/dto"
+	"delayed-notifier/pkg/senderr"
 	"errors"
+	"github.com/google/uuid"
 	"github.com/wb-go/wbf/retry"
 	"github.com/wb-go/wbf/zlog"
 	"time"
 )
 
+// minPostponeDelay - минимальная пауза перед повтором отложенного уведомления:
+// планировщик может доставить его чуть раньше, чем цепь перейдёт в полуоткрытое
+// состояние, и без паузы оно крутилось бы между воркером и брокером.
+const minPostponeDelay = time.Second
+
 type Notification interface {
-	Send(notification dto.SendNotification) error
-	SetStatus(ctx context.Context, ID string, status string, strategy retry.Strategy) error
+	Send(ctx context.Context, notification dto.SendNotification) error
+	SetStatus(ctx context.Context, ID string, version int64, status string, strategy retry.Strategy) error
+	Claim(ctx context.Context, ID string, scheduledAt time.Time, strategy retry.Strategy) (domain.Claim, bool, error)
+	Release(ctx context.Context, ID string, version int64, strategy retry.Strategy) error
+	Defer(ctx context.Context, notification notifier.Message, version int64, until time.Time, strategy retry.Strategy) error
+	Postpone(ctx context.Context, notification notifier.Message, version int64, until time.Time, strategy retry.Strategy) error
+	Drop(ctx context.Context, ID string, version int64, strategy retry.Strategy) error
+	MarkDigestSent(
+		ctx context.Context,
+		digest dto.SendNotification,
+		claims map[uuid.UUID]int64,
+		leaseUntil time.Time,
+		strategy retry.Strategy,
+	) (uuid.UUID, error)
+	ScheduleFollowUps(ctx context.Context, parentID string, outcome string, strategy retry.Strategy) error
+	RecordAttempt(ctx context.Context, ID uuid.UUID) error
+	RetrySend(
+		ctx context.Context,
+		notification notifier.Message,
+		version int64,
+		sendErr error,
+		strategy retry.Strategy,
+	) (bool, error)
+}
+
+type RateLimiter interface {
+	Allow(ctx context.Context, channel domain.NotificationChannel, recipient string, id string) (ratelimit.Result, error)
+	Release(ctx context.Context, channel domain.NotificationChannel, recipient string, id string) error
+}
+
+// DigestOpts - настройки дайджестов. Нулевое окно выключает режим дайджестов.
+type DigestOpts struct {
+	Window   time.Duration
+	MaxItems int
+	Capacity int // сколько элементов можно держать во всех дайджестах сразу
+	Renderer *digest.Renderer
+	Strategy retry.Strategy // для сброса дайджеста по таймеру
 }
 
 type Handler struct {
 	notification Notification
+	limiter      RateLimiter
+	policy       ratelimit.Policy
+	digests      *digest.Buffer
+	digestOpts   DigestOpts
 }
 
-func New(notification Notification) *Handler {
-	return &Handler{notification: notification}
+func New(notification Notification, limiter RateLimiter, policy ratelimit.Policy, digestOpts DigestOpts) *Handler {
+	h := &Handler{
+		notification: notification,
+		limiter:      limiter,
+		policy:       policy,
+		digestOpts:   digestOpts,
+	}
+
+	if digestOpts.Window > 0 {
+		h.digests = digest.NewBuffer(digestOpts.Window, digestOpts.MaxItems, digestOpts.Capacity, h.flushDigest)
+	}
+
+	return h
 }
 
-func (h *Handler) HandleNotif(ctx context.Context, notification notifier.Message, strategy retry.Strategy) {
+// HandleNotif обрабатывает сообщение и подтверждает его брокеру только после того,
+// как результат записан: при временной ошибке сообщение возвращается в очередь,
+// если уведомления нет - уходит в DLQ.
+func (h *Handler) HandleNotif(ctx context.Context, delivery notifier.Delivery, strategy retry.Strategy) {
+	notification := delivery.Message
 	id := notification.ID.String()
 
+	// Отметка нужна сверке: уведомления, которые воркер уже брал, не считаются потерянными
+	if err := h.notification.RecordAttempt(ctx, notification.ID); err != nil {
+		zlog.Logger.Error().Err(err).Str("id", id).Msg("failed to record delivery attempt")
+	}
+
+	if notification.ExpiresAt != nil && time.Now().After(*notification.ExpiresAt) {
+		h.expire(ctx, delivery, strategy)
+		return
+	}
+
+	// Когда буфер дайджестов полон, уведомление отправляется отдельно:
+	// накопленные элементы занимают prefetch и не должны его превышать
+	if notification.Digest && h.digests != nil && h.digests.Add(ctx, delivery) {
+		return
+	}
+
+	delivery, claimed := h.claim(ctx, delivery, strategy)
+	if !claimed {
+		return
+	}
+
+	h.deliver(ctx, delivery, strategy)
+}
+
+// deliver отправляет уведомление, уже взятое в статус sending.
+func (h *Handler) deliver(ctx context.Context, delivery notifier.Delivery, strategy retry.Strategy) {
+	notification := delivery.Message
+	id := notification.ID.String()
+
+	if !h.allowedByRateLimit(ctx, delivery, strategy) {
+		return
+	}
+
 	dtoNotif := dto.SendNotification{
 		Message:     notification.Message,
 		ScheduledAt: notification.ScheduledAt.Format(time.RFC3339),
 		Channel:     notification.Channel,
 		Recipient:   notification.Recipient,
+		IDs:         []uuid.UUID{notification.ID},
 	}
 
-	handleFunc := func() error {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			return h.notification.Send(dtoNotif)
+	// Одна попытка: повторы идут через брокер, чтобы не держать воркер на время паузы
+	if sendErr := h.notification.Send(ctx, dtoNotif); sendErr != nil {
+		h.releaseRateLimit(ctx, notification)
+		h.sendFailed(ctx, delivery, sendErr, strategy)
+		return
+	}
+
+	if !h.setFinalStatus(ctx, delivery, "sent", strategy) {
+		return
+	}
+
+	h.scheduleFollowUps(ctx, id, "sent", strategy)
+	delivery.Ack()
+
+	zlog.Logger.Info().Str("id", id).Msg("notification successfully sent")
+}
+
+// sendFailed обрабатывает неудачную отправку взятого уведомления, одного или в
+// составе дайджеста: если провайдер не вызывался, уведомление откладывается без
+// траты попытки, иначе повтор планируется через брокер или уведомление
+// помечается failed, когда повторять нечего.
+func (h *Handler) sendFailed(ctx context.Context, delivery notifier.Delivery, sendErr error, strategy retry.Strategy) {
+	notification := delivery.Message
+	id := notification.ID.String()
+
+	if ctx.Err() != nil {
+		// Остановка сервиса - попыткой не считается
+		h.release(ctx, delivery, strategy)
+		return
+	}
+
+	// Провайдер канала недоступен - уведомление ждёт в брокере, попытка не тратится
+	var openErr *breaker.OpenError
+	if errors.As(sendErr, &openErr) {
+		h.postpone(ctx, delivery, openErr.RetryAt, "channel circuit breaker is open", strategy)
+		return
+	}
+	// Троттлер недоступен - провайдер тоже не вызывался
+	if classified := senderr.Classify(sendErr); classified.Class == senderr.Throttled {
+		h.postpone(ctx, delivery, time.Now().Add(classified.RetryAfter), "send throttle unavailable", strategy)
+		return
+	}
+
+	retried, err := h.notification.RetrySend(ctx, notification, delivery.Claim.Version, sendErr, strategy)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("id", id).Msg("failed to schedule notification retry")
+		h.release(ctx, delivery, strategy)
+		return
+	}
+	if retried {
+		delivery.Ack()
+		zlog.Logger.Warn().
+			Err(sendErr).
+			Str("id", id).
+			Int("attempt", notification.Attempt+1).
+			Msg("failed to send notification, retry scheduled")
+		return
+	}
+
+	if !h.setFinalStatus(ctx, delivery, "failed", strategy) {
+		return
+	}
+
+	h.scheduleFollowUps(ctx, id, "failed", strategy)
+	delivery.Ack()
+
+	zlog.Logger.Error().Err(sendErr).Str("id", id).Msg("failed to send notification")
+}
+
+// claim забирает уведомление в статус sending и возвращает доставку с версией взятия.
+// Если уведомление уже отправил или отправляет другой воркер (повторная доставка,
+// несколько инстансов), сообщение подтверждается без отправки, и возвращается false.
+func (h *Handler) claim(ctx context.Context, delivery notifier.Delivery, strategy retry.Strategy) (notifier.Delivery, bool) {
+	id := delivery.ID.String()
+
+	claim, claimed, err := h.notification.Claim(ctx, id, delivery.ScheduledAt, strategy)
+	if err != nil {
+		if errors.Is(err, service.ErrStaleDelivery) {
+			// Уведомление перенесено, и для нового времени опубликовано своё сообщение
+			zlog.Logger.Info().Err(err).Str("id", id).Msg("stale notification message, skipping")
+			delivery.Ack()
+			return delivery, false
 		}
+		if errors.Is(err, service.ErrNotifNotFound) {
+			zlog.Logger.Warn().Err(err).Str("id", id).Msg("notification not found")
+			delivery.Reject()
+			return delivery, false
+		}
+		zlog.Logger.Error().Err(err).Str("id", id).Msg("failed to claim notification for sending")
+		delivery.Requeue()
+		return delivery, false
+	}
+
+	if !claimed {
+		zlog.Logger.Info().Str("id", id).Msg("notification already sent or being sent, skipping")
+		delivery.Ack()
+		return delivery, false
+	}
+
+	delivery.Claim = claim
+	return delivery, true
+}
+
+// release возвращает взятое уведомление в scheduled и сообщение - в очередь.
+// Вызывается и при остановке, поэтому не зависит от отмены ctx.
+func (h *Handler) release(ctx context.Context, delivery notifier.Delivery, strategy retry.Strategy) {
+	id := delivery.ID.String()
+
+	if err := h.notification.Release(context.WithoutCancel(ctx), id, delivery.Claim.Version, strategy); err != nil {
+		zlog.Logger.Error().Err(err).Str("id", id).Msg("failed to release notification")
 	}
+	delivery.Requeue()
+}
 
-	sendErr := retry.Do(handleFunc, strategy)
-	status := "sent"
-	if sendErr != nil {
-		status = "failed"
+// postpone откладывает взятое уведомление до until, не тратя попытку:
+// провайдер не вызывался (цепь канала разомкнута или троттлер недоступен).
+func (h *Handler) postpone(ctx context.Context, delivery notifier.Delivery, until time.Time, reason string, strategy retry.Strategy) {
+	id := delivery.ID.String()
+	if earliest := time.Now().Add(minPostponeDelay); until.Before(earliest) {
+		until = earliest
 	}
 
-	if err := h.notification.SetStatus(ctx, id, status, strategy); err != nil {
+	if err := h.notification.Postpone(ctx, delivery.Message, delivery.Claim.Version, until, strategy); err != nil {
+		zlog.Logger.Error().Err(err).Str("id", id).Msg("failed to postpone notification")
+		h.release(ctx, delivery, strategy)
+		return
+	}
+	// Отложенное уведомление уже опубликовано заново
+	delivery.Ack()
+
+	zlog.Logger.Warn().
+		Str("id", id).
+		Str("channel", delivery.Channel).
+		Time("retry_at", until).
+		Msgf("%s, notification postponed", reason)
+}
+
+// setFinalStatus записывает итоговый статус. Если записать не удалось, сообщение
+// возвращается в очередь (или уходит в DLQ, если уведомления нет) и возвращается false.
+func (h *Handler) setFinalStatus(ctx context.Context, delivery notifier.Delivery, status string, strategy retry.Strategy) bool {
+	id := delivery.ID.String()
+
+	if err := h.notification.SetStatus(ctx, id, delivery.Claim.Version, status, strategy); err != nil {
 		if errors.Is(err, service.ErrNotifNotFound) {
 			zlog.Logger.Warn().Err(err).Str("id", id).Msg("notification not found")
-			return
+			delivery.Reject()
+			return false
+		}
+		if errors.Is(err, service.ErrClaimLost) {
+			// Аренда истекла, уведомлением уже занимается другой воркер
+			zlog.Logger.Warn().Err(err).Str("id", id).Msgf("notification status (%s) not written", status)
+			delivery.Ack()
+			return false
+		}
+		if errors.Is(err, service.ErrInvalidTransition) {
+			// Статус уже конечный, сообщение больше не нужно
+			zlog.Logger.Warn().Err(err).Str("id", id).Msgf("notification status (%s) rejected", status)
+			delivery.Ack()
+			return false
 		}
 		zlog.Logger.Error().Err(err).Str("id", id).Msgf("failed to set notification status (%s)", status)
+		delivery.Requeue()
+		return false
+	}
+
+	return true
+}
+
+// expire помечает просроченное уведомление статусом "expired" вместо отправки.
+func (h *Handler) expire(ctx context.Context, delivery notifier.Delivery, strategy retry.Strategy) {
+	if !h.setFinalStatus(ctx, delivery, "expired", strategy) {
 		return
 	}
+	delivery.Ack()
+
+	zlog.Logger.Warn().
+		Str("id", delivery.ID.String()).
+		Time("expires_at", *delivery.ExpiresAt).
+		Msg("notification expired before delivery")
+}
+
+// allowedByRateLimit проверяет лимит получателя. Сверх лимита уведомление
+// откладывается или отбрасывается согласно политике, сообщение подтверждается,
+// и отправлять его не нужно. При недоступности лимитера уведомление отправляется.
+func (h *Handler) allowedByRateLimit(ctx context.Context, delivery notifier.Delivery, strategy retry.Strategy) bool {
+	notification := delivery.Message
+	id := notification.ID.String()
+
+	res, err := h.limiter.Allow(ctx, domain.NotificationChannel(notification.Channel), notification.Recipient, id)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("id", id).Msg("failed to check rate limit, sending anyway")
+		return true
+	}
+	if res.Allowed {
+		return true
+	}
+
+	if h.policy == ratelimit.PolicyDrop {
+		if err := h.notification.Drop(ctx, id, delivery.Claim.Version, strategy); err != nil {
+			zlog.Logger.Error().Err(err).Str("id", id).Msg("failed to drop rate limited notification")
+			delivery.Requeue()
+			return false
+		}
+		delivery.Ack()
+		zlog.Logger.Warn().Str("id", id).Str("recipient", notification.Recipient).Msg("notification dropped by rate limit")
+		return false
+	}
+
+	if err := h.notification.Defer(ctx, notification, delivery.Claim.Version, res.RetryAt, strategy); err != nil {
+		zlog.Logger.Error().Err(err).Str("id", id).Msg("failed to defer rate limited notification")
+		delivery.Requeue()
+		return false
+	}
+	// Отложенное уведомление уже опубликовано заново
+	delivery.Ack()
+	zlog.Logger.Warn().
+		Str("id", id).
+		Str("recipient", notification.Recipient).
+		Time("deferred_until", res.RetryAt).
+		Msg("notification deferred by rate limit")
+
+	return false
+}
+
+// releaseRateLimit возвращает место в окне лимита после неудачной отправки:
+// лимит считает только доставленные уведомления. Повтор займёт место заново.
+func (h *Handler) releaseRateLimit(ctx context.Context, notification notifier.Message) {
+	id := notification.ID.String()
+
+	err := h.limiter.Release(context.WithoutCancel(ctx), domain.NotificationChannel(notification.Channel), notification.Recipient, id)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("id", id).Msg("failed to release rate limit slot")
+	}
+}
+
+// CloseDigests немедленно отправляет все накопленные дайджесты.
+func (h *Handler) CloseDigests(ctx context.Context) {
+	if h.digests != nil {
+		h.digests.Close(ctx)
+	}
+}
 
-	if sendErr != nil {
-		zlog.Logger.Error().Err(sendErr).Str("id", id).Msg("failed to send notification")
+// flushDigest отправляет накопленные уведомления получателя одним сообщением.
+// Лимит получателя к дайджестам не применяется: дайджест сам по себе
+// сокращает число сообщений.
+func (h *Handler) flushDigest(ctx context.Context, key digest.Key, items []notifier.Delivery) {
+	strategy := h.digestOpts.Strategy
+
+	// Отменённые за время окна и уже отправленные уведомления в дайджест не попадают.
+	pending := make([]notifier.Delivery, 0, len(items))
+	for _, item := range items {
+		if claimed, ok := h.claim(ctx, item, strategy); ok {
+			pending = append(pending, claimed)
+		}
+	}
+
+	if len(pending) == 0 {
 		return
 	}
 
-	zlog.Logger.Info().Str("id", id).Msg("notification successfully sent")
+	if len(pending) == 1 {
+		h.deliver(ctx, withoutDigest(pending[0]), strategy)
+		return
+	}
+
+	messages := make([]notifier.Message, 0, len(pending))
+	for _, item := range pending {
+		messages = append(messages, item.Message)
+	}
+
+	text, err := h.digestOpts.Renderer.Render(messages)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("recipient", key.Recipient).Msg("failed to render digest, sending individually")
+		for _, item := range pending {
+			h.deliver(ctx, withoutDigest(item), strategy)
+		}
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(pending))
+	claims := make(map[uuid.UUID]int64, len(pending))
+	leaseUntil := pending[0].Claim.LeaseUntil
+	for _, item := range pending {
+		ids = append(ids, item.ID)
+		claims[item.ID] = item.Claim.Version
+		if item.Claim.LeaseUntil.Before(leaseUntil) {
+			leaseUntil = item.Claim.LeaseUntil
+		}
+	}
+
+	dtoDigest := dto.SendNotification{
+		Message:     text,
+		ScheduledAt: time.Now().UTC().Format(time.RFC3339),
+		Channel:     key.Channel,
+		Recipient:   key.Recipient,
+		IDs:         ids,
+		Digest:      true,
+	}
+
+	// Как и одиночная отправка - одна попытка, повторы каждого уведомления идут
+	// через брокер и снова собираются в дайджест
+	if sendErr := h.notification.Send(ctx, dtoDigest); sendErr != nil {
+		zlog.Logger.Error().Err(sendErr).Str("recipient", key.Recipient).Msg("failed to send digest")
+		for _, item := range pending {
+			h.sendFailed(ctx, item, sendErr, strategy)
+		}
+		return
+	}
+
+	digestID, err := h.notification.MarkDigestSent(ctx, dtoDigest, claims, leaseUntil, strategy)
+	if err != nil {
+		// Запись повторялась почти до конца аренды. Сообщения возвращаются в очередь,
+		// чтобы уведомления не остались в sending без сообщения в брокере: после
+		// аренды повторная доставка отправит их ещё раз
+		zlog.Logger.Error().
+			Err(err).
+			Str("recipient", key.Recipient).
+			Interface("ids", ids).
+			Msg("digest sent but failed to mark its notifications as sent, requeueing")
+		for _, item := range pending {
+			item.Requeue()
+		}
+		return
+	}
+
+	for _, item := range pending {
+		h.scheduleFollowUps(ctx, item.ID.String(), "sent", strategy)
+		item.Ack()
+	}
+
+	zlog.Logger.Info().
+		Str("digest_id", digestID.String()).
+		Int("count", len(ids)).
+		Msg("digest successfully sent")
+}
+
+func withoutDigest(delivery notifier.Delivery) notifier.Delivery {
+	delivery.Digest = false
+	return delivery
+}
+
+func (h *Handler) scheduleFollowUps(ctx context.Context, id string, outcome string, strategy retry.Strategy) {
+	if err := h.notification.ScheduleFollowUps(ctx, id, outcome, strategy); err != nil {
+		zlog.Logger.Error().Err(err).Str("id", id).Msg("failed to schedule follow-up notifications")
+	}
 }
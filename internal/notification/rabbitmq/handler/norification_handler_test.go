@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/cache"
+	"delayed-notifier/internal/notification/rabbitmq/notifier"
+	"delayed-notifier/internal/notification/ratelimit"
+	"delayed-notifier/internal/notification/repo/memory"
+	"delayed-notifier/internal/notification/senders"
+	"delayed-notifier/internal/notification/service"
+	"delayed-notifier/internal/notification/types/domain"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/retry"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testStrategy = retry.Strategy{Attempts: 1}
+
+// recordingAcker запоминает, чем закончилась обработка сообщения.
+type recordingAcker struct {
+	mu     sync.Mutex
+	result string
+}
+
+func (a *recordingAcker) Ack() error {
+	a.set("ack")
+	return nil
+}
+
+func (a *recordingAcker) Nack(requeue bool) error {
+	if requeue {
+		a.set("requeue")
+	} else {
+		a.set("reject")
+	}
+	return nil
+}
+
+func (a *recordingAcker) set(result string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.result = result
+}
+
+func (a *recordingAcker) get() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.result
+}
+
+// publisher запоминает переопубликованные уведомления.
+type publisher struct {
+	mu        sync.Mutex
+	published []notifier.Message
+}
+
+func (p *publisher) Publish(message notifier.Message, _ retry.Strategy) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.published = append(p.published, message)
+	return nil
+}
+
+func (p *publisher) get() []notifier.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]notifier.Message(nil), p.published...)
+}
+
+// sender отказывает первые failures отправок.
+type sender struct {
+	mu       sync.Mutex
+	failures int
+	sent     []string
+}
+
+func (s *sender) Send(_ context.Context, message string, _ string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("connection reset")
+	}
+	s.sent = append(s.sent, message)
+	return nil
+}
+
+type fixture struct {
+	repo      *memory.Repo
+	publisher *publisher
+	sender    *sender
+	handler   *Handler
+}
+
+func newFixture(t *testing.T, limiter RateLimiter, policy ratelimit.Policy, opts service.Opts) *fixture {
+	t.Helper()
+
+	f := &fixture{repo: memory.New(), publisher: &publisher{}, sender: &sender{}}
+	notificationService := service.NewNotification(
+		f.repo,
+		f.publisher,
+		cache.NewMemory(cache.Opts{}),
+		senders.New(f.sender),
+		opts,
+	)
+	f.handler = New(notificationService, limiter, policy, DigestOpts{})
+
+	return f
+}
+
+// handle создаёт уведомление получателю и обрабатывает его сообщение.
+func (f *fixture) handle(t *testing.T, recipient string) (uuid.UUID, string) {
+	t.Helper()
+
+	notification := domain.Notification{
+		ID:          uuid.New(),
+		Message:     "reminder",
+		ScheduledAt: time.Now().Add(-time.Second),
+		Channel:     domain.Email,
+		Recipient:   recipient,
+		Status:      domain.Scheduled,
+	}
+	if err := f.repo.CreateNotification(context.Background(), notification); err != nil {
+		t.Fatalf("CreateNotification: %v", err)
+	}
+
+	acker := &recordingAcker{}
+	delivery := notifier.NewDelivery(notifier.Message{
+		ID:          notification.ID,
+		Message:     notification.Message,
+		ScheduledAt: notification.ScheduledAt,
+		Channel:     string(notification.Channel),
+		Recipient:   notification.Recipient,
+	}, acker)
+	f.handler.HandleNotif(context.Background(), delivery, testStrategy)
+
+	return notification.ID, acker.get()
+}
+
+func (f *fixture) status(t *testing.T, ID uuid.UUID) domain.NotificationStatus {
+	t.Helper()
+
+	status, err := f.repo.GetStatusByID(context.Background(), ID)
+	if err != nil {
+		t.Fatalf("GetStatusByID: %v", err)
+	}
+	return status
+}
+
+func TestRateLimitPolicy(t *testing.T) {
+	rules := ratelimit.Rules{domain.Email: {Limit: 1, Window: time.Hour}}
+
+	t.Run("defer", func(t *testing.T) {
+		f := newFixture(t, ratelimit.NewMemory(rules), ratelimit.PolicyDefer, service.Opts{})
+
+		first, result := f.handle(t, "user@example.com")
+		if result != "ack" || f.status(t, first) != domain.Sent {
+			t.Fatalf("first: %s, %s", result, f.status(t, first))
+		}
+
+		second, result := f.handle(t, "user@example.com")
+		if result != "ack" {
+			t.Fatalf("second: got %s, want ack", result)
+		}
+		if status := f.status(t, second); status != domain.Scheduled {
+			t.Fatalf("second status: got %s, want %s", status, domain.Scheduled)
+		}
+
+		// Уведомление опубликовано заново на конец окна
+		published := f.publisher.get()
+		if len(published) != 1 || published[0].ID != second {
+			t.Fatalf("published: got %v, want the deferred notification", published)
+		}
+		if published[0].ScheduledAt.Before(time.Now().Add(time.Hour - time.Minute)) {
+			t.Fatalf("deferred until %s, want the end of the window", published[0].ScheduledAt)
+		}
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		f := newFixture(t, ratelimit.NewMemory(rules), ratelimit.PolicyDrop, service.Opts{})
+
+		f.handle(t, "user@example.com")
+		second, result := f.handle(t, "user@example.com")
+		if result != "ack" {
+			t.Fatalf("second: got %s, want ack", result)
+		}
+		if status := f.status(t, second); status != domain.Dropped {
+			t.Fatalf("second status: got %s, want %s", status, domain.Dropped)
+		}
+		if len(f.publisher.get()) != 0 {
+			t.Fatal("dropped notification was published")
+		}
+
+		// Другому получателю лимит не мешает
+		if other, _ := f.handle(t, "other@example.com"); f.status(t, other) != domain.Sent {
+			t.Fatalf("other recipient status: got %s, want %s", f.status(t, other), domain.Sent)
+		}
+	})
+
+	t.Run("failed send frees the slot", func(t *testing.T) {
+		f := newFixture(t, ratelimit.NewMemory(rules), ratelimit.PolicyDrop, service.Opts{
+			SendRetry: service.SendRetryOpts{MaxAttempts: 3},
+		})
+		f.sender.failures = 1
+
+		first, result := f.handle(t, "user@example.com")
+		if result != "ack" || f.status(t, first) != domain.Scheduled {
+			t.Fatalf("first: %s, %s, want a scheduled retry", result, f.status(t, first))
+		}
+
+		second, _ := f.handle(t, "user@example.com")
+		if status := f.status(t, second); status != domain.Sent {
+			t.Fatalf("second status: got %s, want %s", status, domain.Sent)
+		}
+	})
+}
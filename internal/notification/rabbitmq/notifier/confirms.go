@@ -0,0 +1,174 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/wb-go/wbf/zlog"
+	"sync"
+)
+
+// confirmChannel - то, что confirmer использует из канала AMQP в режиме confirm.
+type confirmChannel interface {
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	GetNextPublishSeqNo() uint64
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// confirmer сопоставляет подтверждения и возвраты публикаций одного канала.
+// Брокер присылает basic.return раньше basic.ack той же публикации, а клиент
+// не обработает ack, пока возврат не принят, поэтому одна горутина, читающая
+// оба потока, всегда видит возврат до подтверждения.
+type confirmer struct {
+	channel confirmChannel
+
+	publishMu sync.Mutex // номер публикации должен совпасть с GetNextPublishSeqNo
+
+	mu      sync.Mutex
+	pending map[uint64]*pendingPublish // номер публикации -> ожидающая подтверждения
+	closed  bool
+}
+
+type pendingPublish struct {
+	messageID string
+	returned  bool
+	done      chan error
+}
+
+// newConfirmer подписывается на подтверждения и возвраты канала в режиме confirm.
+// Все публикации канала должны идти через publish, иначе номера разойдутся.
+func newConfirmer(channel confirmChannel) *confirmer {
+	c := &confirmer{
+		channel: channel,
+		pending: make(map[uint64]*pendingPublish),
+	}
+
+	// Каналы без буфера: клиент не пойдёт дальше, пока событие не принято.
+	// Оба закрываются вместе с каналом AMQP, и горутина завершается.
+	returns := channel.NotifyReturn(make(chan amqp.Return))
+	confirms := channel.NotifyPublish(make(chan amqp.Confirmation))
+	go c.run(returns, confirms)
+
+	return c
+}
+
+// publish публикует сообщение и ждёт его подтверждения. Возвращает ErrNacked,
+// ErrReturned, ErrConfirmTimeout по сроку ctx или ошибку отменённого ctx.
+func (c *confirmer) publish(ctx context.Context, exchange, key string, mandatory bool, publishing amqp.Publishing) error {
+	pending := &pendingPublish{
+		messageID: publishing.MessageId,
+		done:      make(chan error, 1),
+	}
+
+	c.publishMu.Lock()
+	tag := c.channel.GetNextPublishSeqNo()
+	if !c.track(tag, pending) {
+		c.publishMu.Unlock()
+		return amqp.ErrClosed
+	}
+	err := c.channel.PublishWithContext(ctx, exchange, key, mandatory, false, publishing)
+	c.publishMu.Unlock()
+	if err != nil {
+		c.forget(tag)
+		return err
+	}
+
+	select {
+	case err := <-pending.done:
+		return err
+	case <-ctx.Done():
+		c.forget(tag)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ErrConfirmTimeout
+		}
+		return ctx.Err()
+	}
+}
+
+func (c *confirmer) run(returns <-chan amqp.Return, confirms <-chan amqp.Confirmation) {
+	defer c.close()
+
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			metrics.Add("returned", 1)
+			zlog.Logger.Warn().
+				Str("message_id", ret.MessageId).
+				Uint16("reply_code", ret.ReplyCode).
+				Str("reply_text", ret.ReplyText).
+				Msg("notification message returned by broker")
+			c.markReturned(ret.MessageId)
+		case confirmation, ok := <-confirms:
+			if !ok {
+				return
+			}
+			c.resolve(confirmation)
+		}
+	}
+}
+
+func (c *confirmer) track(tag uint64, pending *pendingPublish) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	c.pending[tag] = pending
+
+	return true
+}
+
+func (c *confirmer) forget(tag uint64) {
+	c.mu.Lock()
+	delete(c.pending, tag)
+	c.mu.Unlock()
+}
+
+func (c *confirmer) markReturned(messageID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, pending := range c.pending {
+		if pending.messageID == messageID {
+			pending.returned = true
+		}
+	}
+}
+
+func (c *confirmer) resolve(confirmation amqp.Confirmation) {
+	c.mu.Lock()
+	pending, ok := c.pending[confirmation.DeliveryTag]
+	delete(c.pending, confirmation.DeliveryTag)
+	c.mu.Unlock()
+	if !ok {
+		// Публикация уже не ждёт подтверждения
+		return
+	}
+
+	switch {
+	case !confirmation.Ack:
+		pending.done <- ErrNacked
+	case pending.returned:
+		pending.done <- ErrReturned
+	default:
+		pending.done <- nil
+	}
+}
+
+// close завершает ожидающие публикации, когда канал закрыт.
+func (c *confirmer) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for tag, pending := range c.pending {
+		pending.done <- amqp.ErrClosed
+		delete(c.pending, tag)
+	}
+}
@@ -0,0 +1,169 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeChannel - канал в режиме confirm: номера публикаций, подтверждения
+// и возвраты задаёт тест.
+type fakeChannel struct {
+	mu        sync.Mutex
+	seqNo     uint64
+	returns   chan amqp.Return
+	confirms  chan amqp.Confirmation
+	published chan amqp.Publishing
+}
+
+func newFakeChannel() *fakeChannel {
+	return &fakeChannel{seqNo: 1, published: make(chan amqp.Publishing, 16)}
+}
+
+func (c *fakeChannel) NotifyReturn(returns chan amqp.Return) chan amqp.Return {
+	c.returns = returns
+	return returns
+}
+
+func (c *fakeChannel) NotifyPublish(confirms chan amqp.Confirmation) chan amqp.Confirmation {
+	c.confirms = confirms
+	return confirms
+}
+
+func (c *fakeChannel) GetNextPublishSeqNo() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seqNo
+}
+
+func (c *fakeChannel) PublishWithContext(_ context.Context, _, _ string, _, _ bool, msg amqp.Publishing) error {
+	c.mu.Lock()
+	c.seqNo++
+	c.mu.Unlock()
+	c.published <- msg
+	return nil
+}
+
+// close закрывает уведомления, как это делает закрытый канал AMQP.
+func (c *fakeChannel) close() {
+	close(c.returns)
+	close(c.confirms)
+}
+
+// publishAsync публикует сообщение и ждёт, пока оно дойдёт до канала.
+func publishAsync(t *testing.T, c *confirmer, ctx context.Context, messageID string) (amqp.Publishing, <-chan error) {
+	t.Helper()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- c.publish(ctx, "exchange", "key", true, amqp.Publishing{MessageId: messageID})
+	}()
+
+	select {
+	case msg := <-c.channel.(*fakeChannel).published:
+		return msg, result
+	case <-time.After(time.Second):
+		t.Fatal("message was not published")
+		return amqp.Publishing{}, nil
+	}
+}
+
+func waitResult(t *testing.T, result <-chan error) error {
+	t.Helper()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("publish did not return")
+		return nil
+	}
+}
+
+func TestConfirmerPublish(t *testing.T) {
+	t.Run("ack", func(t *testing.T) {
+		channel := newFakeChannel()
+		c := newConfirmer(channel)
+
+		_, result := publishAsync(t, c, context.Background(), "a")
+		channel.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+
+		if err := waitResult(t, result); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+	})
+
+	t.Run("nack", func(t *testing.T) {
+		channel := newFakeChannel()
+		c := newConfirmer(channel)
+
+		_, result := publishAsync(t, c, context.Background(), "a")
+		channel.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: false}
+
+		if err := waitResult(t, result); !errors.Is(err, ErrNacked) {
+			t.Fatalf("got %v, want ErrNacked", err)
+		}
+	})
+
+	t.Run("returned message", func(t *testing.T) {
+		channel := newFakeChannel()
+		c := newConfirmer(channel)
+
+		_, returned := publishAsync(t, c, context.Background(), "a")
+		_, delivered := publishAsync(t, c, context.Background(), "b")
+
+		// Брокер присылает возврат раньше подтверждения той же публикации
+		channel.returns <- amqp.Return{MessageId: "a", ReplyCode: amqp.NoRoute}
+		channel.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+		channel.confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: true}
+
+		if err := waitResult(t, returned); !errors.Is(err, ErrReturned) {
+			t.Fatalf("returned: got %v, want ErrReturned", err)
+		}
+		if err := waitResult(t, delivered); err != nil {
+			t.Fatalf("delivered: got %v, want nil", err)
+		}
+	})
+
+	t.Run("confirm timeout", func(t *testing.T) {
+		channel := newFakeChannel()
+		c := newConfirmer(channel)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, result := publishAsync(t, c, ctx, "a")
+
+		if err := waitResult(t, result); !errors.Is(err, ErrConfirmTimeout) {
+			t.Fatalf("got %v, want ErrConfirmTimeout", err)
+		}
+
+		// Опоздавшее подтверждение никого не ждёт, следующая публикация не путается
+		channel.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: false}
+		_, next := publishAsync(t, c, context.Background(), "b")
+		channel.confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: true}
+		if err := waitResult(t, next); err != nil {
+			t.Fatalf("next: got %v, want nil", err)
+		}
+	})
+
+	t.Run("closed channel", func(t *testing.T) {
+		channel := newFakeChannel()
+		c := newConfirmer(channel)
+
+		_, result := publishAsync(t, c, context.Background(), "a")
+		channel.close()
+
+		if err := waitResult(t, result); !errors.Is(err, amqp.ErrClosed) {
+			t.Fatalf("pending: got %v, want ErrClosed", err)
+		}
+
+		// Ожидающие публикации завершает закрытие confirmer, новые сразу отклоняются
+		err := c.publish(context.Background(), "exchange", "key", true, amqp.Publishing{MessageId: "b"})
+		if !errors.Is(err, amqp.ErrClosed) {
+			t.Fatalf("publish after close: got %v, want ErrClosed", err)
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"time"
+)
+
+// deadLetterPageSize - сколько сообщений DLQ просматривается за один запрос.
+// Просмотренные сообщения удерживаются до конца запроса, поэтому страница небольшая.
+const deadLetterPageSize = 500
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter - сообщение, попавшее в DLQ.
+type DeadLetter struct {
+	ID      string
+	Message *Message // nil, если тело не удалось разобрать
+	Body    string
+	Reason  string
+	Error   string // описание ошибки разбора для сообщений из карантина
+	Queue   string // очередь, из которой сообщение попало в DLQ
+	Count   int64  // сколько раз сообщение попадало в DLQ
+	DiedAt  *time.Time
+}
+
+// NewDeadLetter разбирает тело сообщения, если это возможно.
+func NewDeadLetter(id string, body []byte) DeadLetter {
+	letter := DeadLetter{ID: id, Body: string(body)}
+
+	if message, err := Decode(body); err == nil {
+		letter.Message = &message
+	}
+
+	return letter
+}
+
+// ReplayFunc публикует сообщение из DLQ заново. Сообщение удаляется из DLQ,
+// только если ReplayFunc вернула nil.
+type ReplayFunc func(letter DeadLetter) error
+
+// DeadLetters возвращает до limit сообщений DLQ, не удаляя их, но не больше страницы.
+func (n *Notifier) DeadLetters(_ context.Context, limit int) ([]DeadLetter, error) {
+	limit = min(limit, deadLetterPageSize)
+
+	letters := []DeadLetter{}
+	err := n.scanDLQ(limit, func(letter DeadLetter) bool {
+		letters = append(letters, letter)
+		return false
+	})
+
+	return letters, err
+}
+
+func (n *Notifier) DeadLetter(_ context.Context, ID string) (DeadLetter, error) {
+	var (
+		found DeadLetter
+		ok    bool
+	)
+	err := n.scanDLQ(deadLetterPageSize, func(letter DeadLetter) bool {
+		if letter.ID == ID && !ok {
+			found, ok = letter, true
+		}
+		return false
+	})
+	if err != nil {
+		return DeadLetter{}, err
+	}
+	if !ok {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+
+	return found, nil
+}
+
+func (n *Notifier) ReplayDeadLetters(_ context.Context, IDs []string, replay ReplayFunc) error {
+	selected := toSet(IDs)
+
+	return n.scanDLQ(deadLetterPageSize, func(letter DeadLetter) bool {
+		if _, ok := selected[letter.ID]; !ok {
+			return false
+		}
+		return replay(letter) == nil
+	})
+}
+
+// PurgeDeadLetters удаляет выбранные сообщения DLQ, при пустом IDs - все.
+func (n *Notifier) PurgeDeadLetters(_ context.Context, IDs []string) (int, error) {
+	if len(IDs) == 0 {
+		channel, err := n.conn.Channel()
+		if err != nil {
+			return 0, err
+		}
+		return channel.QueuePurge(n.opts.DLQ, false)
+	}
+
+	selected := toSet(IDs)
+	purged := 0
+	err := n.scanDLQ(deadLetterPageSize, func(letter DeadLetter) bool {
+		if _, ok := selected[letter.ID]; ok {
+			purged++
+			return true
+		}
+		return false
+	})
+
+	return purged, err
+}
+
+// scanDLQ забирает из DLQ до limit сообщений без подтверждения и передаёт их в visit.
+// Сообщения, для которых visit вернул true, удаляются, остальные возвращаются в DLQ.
+// Просмотр идёт в отдельном канале, чтобы не задерживать публикации.
+func (n *Notifier) scanDLQ(limit int, visit func(letter DeadLetter) bool) error {
+	channel, err := n.conn.OpenChannel()
+	if err != nil {
+		return err
+	}
+	// Закрытие канала вернёт в DLQ и сообщения, которые не удалось вернуть явно
+	defer func() {
+		_ = channel.Close()
+	}()
+
+	var held []amqp.Delivery
+	defer func() {
+		for _, d := range held {
+			_ = d.Nack(false, true)
+		}
+	}()
+
+	for len(held) < limit {
+		d, ok, err := channel.Get(n.opts.DLQ, false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+
+		if visit(deadLetterFromDelivery(d)) {
+			if err := d.Ack(false); err != nil {
+				return err
+			}
+			continue
+		}
+		held = append(held, d)
+	}
+
+	return nil
+}
+
+func deadLetterFromDelivery(d amqp.Delivery) DeadLetter {
+	letter := NewDeadLetter(d.MessageId, d.Body)
+	if letter.ID == "" && letter.Message != nil {
+		// Сообщения, опубликованные без MessageId
+		letter.ID = letter.Message.ID.String()
+	}
+
+	if quarantineFromHeaders(&letter, d.Headers) {
+		return letter
+	}
+
+	// Последняя причина - первая запись x-death
+	deaths, _ := d.Headers["x-death"].([]interface{})
+	if len(deaths) == 0 {
+		return letter
+	}
+	death, ok := deaths[0].(amqp.Table)
+	if !ok {
+		return letter
+	}
+
+	letter.Reason, _ = death["reason"].(string)
+	letter.Queue, _ = death["queue"].(string)
+	letter.Count, _ = death["count"].(int64)
+	if diedAt, ok := death["time"].(time.Time); ok {
+		letter.DiedAt = &diedAt
+	}
+
+	return letter
+}
+
+func toSet(IDs []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(IDs))
+	for _, id := range IDs {
+		set[id] = struct{}{}
+	}
+	return set
+}
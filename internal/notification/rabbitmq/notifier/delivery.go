@@ -0,0 +1,61 @@
+package notifier
+
+import (
+	"delayed-notifier/internal/notification/types/domain"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/wb-go/wbf/zlog"
+)
+
+// Acknowledger подтверждает обработку сообщения в конкретном бэкенде.
+// Nack(true) возвращает сообщение в очередь, Nack(false) отправляет его в DLQ.
+type Acknowledger interface {
+	Ack() error
+	Nack(requeue bool) error
+}
+
+// Delivery - полученное из брокера сообщение. Пока оно не подтверждено,
+// брокер считает его необработанным и доставит снова после падения консьюмера.
+type Delivery struct {
+	Message
+	// Claim - взятие уведомления в отправку: записи обработчика из статуса
+	// sending выполняются только при совпадении версии
+	Claim domain.Claim
+	acker Acknowledger
+}
+
+func NewDelivery(message Message, acker Acknowledger) Delivery {
+	return Delivery{Message: message, acker: acker}
+}
+
+// Ack - результат обработки записан, сообщение можно удалить.
+func (d Delivery) Ack() {
+	if err := d.acker.Ack(); err != nil {
+		zlog.Logger.Error().Err(err).Str("id", d.ID.String()).Msg("failed to ack notification message")
+	}
+}
+
+// Requeue - временная ошибка, сообщение нужно доставить ещё раз.
+func (d Delivery) Requeue() {
+	if err := d.acker.Nack(true); err != nil {
+		zlog.Logger.Error().Err(err).Str("id", d.ID.String()).Msg("failed to requeue notification message")
+	}
+}
+
+// Reject - сообщение не может быть обработано и уходит в DLQ.
+func (d Delivery) Reject() {
+	if err := d.acker.Nack(false); err != nil {
+		zlog.Logger.Error().Err(err).Str("id", d.ID.String()).Msg("failed to reject notification message")
+	}
+}
+
+type amqpAcker struct {
+	delivery amqp.Delivery
+}
+
+func (a amqpAcker) Ack() error {
+	return a.delivery.Ack(false)
+}
+
+func (a amqpAcker) Nack(requeue bool) error {
+	return a.delivery.Nack(false, requeue)
+}
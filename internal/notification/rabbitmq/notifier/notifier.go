@@ -2,38 +2,113 @@ package notifier
 
 import (
 	"context"
+	"delayed-notifier/internal/notification/rabbitmq/connection"
+	"delayed-notifier/internal/notification/types/domain"
 	"delayed-notifier/pkg/errutils"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
-	"github.com/wb-go/wbf/rabbitmq"
 	"github.com/wb-go/wbf/retry"
 	"github.com/wb-go/wbf/zlog"
+	"sync/atomic"
 	"time"
 )
 
 type Opts struct {
-	Exchange   string
-	RoutingKey string
-	Queue      string
-	DLQ        string
+	Exchange       string
+	RoutingKey     string
+	Queue          string // приоритетная очередь, из которой читают воркеры
+	LegacyQueue    string // очередь без приоритетов от прежних версий, вычитывается и удаляется
+	DLQ            string
+	ConfirmTimeout time.Duration // сколько ждать подтверждения публикации от брокера
+	Prefetch       int           // сколько неподтверждённых сообщений брокер отдаёт консьюмеру
 }
 
+var (
+	ErrNacked         = errors.New("message nacked by broker")
+	ErrReturned       = errors.New("message returned by broker as unroutable")
+	ErrConfirmTimeout = errors.New("publish confirmation timed out")
+)
+
+// metrics - счётчики публикаций, доступны через /debug/vars.
+var metrics = expvar.NewMap("rabbitmq_publisher")
+
 type Message struct {
 	ID          uuid.UUID
 	Message     string
 	ScheduledAt time.Time
 	Channel     string
 	Recipient   string
+	ExpiresAt   *time.Time `json:",omitempty"`
+	Digest      bool       `json:",omitempty"`
+	Priority    string     `json:",omitempty"`
+	Attempt     int        `json:",omitempty"` // сколько попыток отправки уже не удалось
+}
+
+// Connection - соединение с RabbitMQ, которое восстанавливается после обрыва.
+type Connection interface {
+	OnConnect(setup connection.SetupFunc)
+	Channel() (*amqp.Channel, error)
+	OpenChannel() (*amqp.Channel, error)
+	Wait(ctx context.Context) (*amqp.Channel, error)
 }
 
 type Notifier struct {
-	publisher *rabbitmq.Publisher
-	consumer  *rabbitmq.Consumer
-	opts      Opts
+	conn Connection
+	opts Opts
+
+	confirmer atomic.Pointer[confirmer] // публикации текущего канала
+
+	draining atomic.Bool // в старой очереди остались сообщения, консьюмер читает и её
+}
+
+// New регистрирует настройку канала в conn: топология, подтверждения и prefetch
+// объявляются заново после каждого переподключения. Вызывать до conn.Connect.
+func New(conn Connection, opts Opts) *Notifier {
+	if opts.ConfirmTimeout <= 0 {
+		opts.ConfirmTimeout = 5 * time.Second
+	}
+	if opts.Queue == "" {
+		opts.Queue = opts.LegacyQueue + ".priority"
+	}
+
+	n := &Notifier{
+		conn: conn,
+		opts: opts,
+	}
+	conn.OnConnect(n.setup)
+
+	return n
+}
+
+func (n *Notifier) setup(conn *amqp.Connection, channel *amqp.Channel) error {
+	if err := declareTopology(channel, n.opts); err != nil {
+		return err
+	}
+
+	if err := n.retireLegacyQueue(conn); err != nil {
+		return err
+	}
+
+	// Publisher confirms: Publish возвращается только после ack брокера
+	if err := channel.Confirm(false); err != nil {
+		return errutils.Wrap("failed to put channel into confirm mode", err)
+	}
+
+	if n.opts.Prefetch > 0 {
+		if err := channel.Qos(n.opts.Prefetch, 0, false); err != nil {
+			return errutils.Wrap("failed to set prefetch", err)
+		}
+	}
+
+	n.confirmer.Store(newConfirmer(channel))
+
+	return nil
 }
 
-func New(channel *rabbitmq.Channel, opts Opts) (*Notifier, error) {
+func declareTopology(channel *amqp.Channel, opts Opts) error {
 	table := amqp.Table{
 		"x-delayed-type": "direct",
 	}
@@ -47,7 +122,21 @@ func New(channel *rabbitmq.Channel, opts Opts) (*Notifier, error) {
 		false,
 		table,
 	); err != nil {
-		return nil, errutils.Wrap("failed to declare an exchange", err)
+		return errutils.Wrap("failed to declare an exchange", err)
+	}
+
+	// DLX и DLQ называются одинаково: имя уже указано в аргументах основной
+	// очереди, а менять аргументы существующей очереди нельзя
+	if err := channel.ExchangeDeclare(
+		opts.DLQ, // "notification-dlq"
+		"direct",
+		true,
+		false,
+		false,
+		false,
+		nil,
+	); err != nil {
+		return errutils.Wrap("failed to declare dead letter exchange", err)
 	}
 
 	if _, err := channel.QueueDeclare(
@@ -58,24 +147,36 @@ func New(channel *rabbitmq.Channel, opts Opts) (*Notifier, error) {
 		false,
 		nil,
 	); err != nil {
-		return nil, errutils.Wrap("failed to declare DLQ", err)
+		return errutils.Wrap("failed to declare DLQ", err)
+	}
+
+	if err := channel.QueueBind(
+		opts.DLQ,
+		opts.RoutingKey,
+		opts.DLQ,
+		false,
+		nil,
+	); err != nil {
+		return errutils.Wrap("failed to bind DLQ", err)
 	}
 
 	table = amqp.Table{
 		"x-dead-letter-exchange":    opts.DLQ,        // exchange, куда будут падать сообщения
 		"x-dead-letter-routing-key": opts.RoutingKey, // ключ маршрутизации для DLX
+		// Аргументы существующей очереди изменить нельзя, поэтому приоритетная
+		// очередь объявляется под новым именем, а старая вычитывается
+		"x-max-priority": domain.MaxPriorityLevel,
 	}
 
-	queue, err := channel.QueueDeclare(
+	if _, err := channel.QueueDeclare(
 		opts.Queue, // "notification-queue"
 		true,
 		false,
 		false,
 		false,
 		table,
-	)
-	if err != nil {
-		return nil, errutils.Wrap("failed to declare main notification queue", err)
+	); err != nil {
+		return errutils.Wrap("failed to declare main notification queue", err)
 	}
 
 	if err := channel.QueueBind(
@@ -85,19 +186,75 @@ func New(channel *rabbitmq.Channel, opts Opts) (*Notifier, error) {
 		false,
 		nil,
 	); err != nil {
-		return nil, errutils.Wrap("failed to bind queue", err)
+		return errutils.Wrap("failed to bind queue", err)
+	}
+
+	return nil
+}
+
+// retireLegacyQueue отвязывает очередь без приоритетов, оставшуюся от прежних
+// версий, чтобы новые сообщения шли только в приоритетную очередь. Уже лежащие
+// в ней сообщения вычитывает Consume, пустая очередь без консьюмеров удаляется.
+func (n *Notifier) retireLegacyQueue(conn *amqp.Connection) error {
+	n.draining.Store(false)
+	if n.opts.LegacyQueue == "" || n.opts.LegacyQueue == n.opts.Queue {
+		return nil
 	}
 
-	publisher := rabbitmq.NewPublisher(channel, opts.Exchange)
-	consumer := rabbitmq.NewConsumer(channel, &rabbitmq.ConsumerConfig{
-		Queue: queue.Name,
-	})
+	// Если очереди нет, брокер закрывает канал, поэтому проверяем на отдельном
+	probe, err := conn.Channel()
+	if err != nil {
+		return errutils.Wrap("failed to open channel for legacy queue", err)
+	}
+	defer func() {
+		_ = probe.Close()
+	}()
+
+	queue, err := probe.QueueDeclarePassive(n.opts.LegacyQueue, true, false, false, false, nil)
+	if err != nil {
+		var amqpErr *amqp.Error
+		if errors.As(err, &amqpErr) && amqpErr.Code == amqp.NotFound {
+			return nil
+		}
+		return errutils.Wrap("failed to inspect legacy queue", err)
+	}
+
+	if err := probe.QueueUnbind(n.opts.LegacyQueue, n.opts.RoutingKey, n.opts.Exchange, nil); err != nil {
+		return errutils.Wrap("failed to unbind legacy queue", err)
+	}
+
+	if queue.Messages == 0 && queue.Consumers == 0 {
+		// Сообщение могло прийти после проверки - тогда брокер откажет, и очередь
+		// будет вычитана и удалена при следующем подключении
+		if _, err := probe.QueueDelete(n.opts.LegacyQueue, true, true, false); err == nil {
+			zlog.Logger.Info().Str("queue", n.opts.LegacyQueue).Msg("legacy notification queue deleted")
+			return nil
+		}
+	}
 
-	return &Notifier{
-		publisher: publisher,
-		consumer:  consumer,
-		opts:      opts,
-	}, nil
+	zlog.Logger.Warn().
+		Str("queue", n.opts.LegacyQueue).
+		Int("messages", queue.Messages).
+		Msg("draining legacy notification queue")
+	n.draining.Store(true)
+
+	return nil
+}
+
+// publishConfirmed публикует сообщение в текущий канал и ждёт подтверждения брокера.
+func (n *Notifier) publishConfirmed(ctx context.Context, exchange string, mandatory bool, publishing amqp.Publishing) error {
+	// Пока соединение восстанавливается, публикация сразу завершается ошибкой
+	channel, err := n.conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	confirmer := n.confirmer.Load()
+	if confirmer == nil || confirmer.channel != channel {
+		return connection.ErrNotConnected
+	}
+
+	return confirmer.publish(ctx, exchange, n.opts.RoutingKey, mandatory, publishing)
 }
 
 func (n *Notifier) Publish(notification Message, strategy retry.Strategy) error {
@@ -111,46 +268,133 @@ func (n *Notifier) Publish(notification Message, strategy retry.Strategy) error
 		return errutils.Wrap("failed to marshal notification message", err)
 	}
 
-	headers := amqp.Table{"x-delay": int(delay.Milliseconds())}
+	// x-delayed-message маршрутизирует отложенное сообщение только в момент доставки
+	// и не поддерживает для него mandatory, поэтому возврат ждём лишь для немедленных
+	mandatory := delay == 0
 
-	if err := n.publisher.PublishWithRetry(
-		body,
-		n.opts.RoutingKey,
-		"application/json",
-		strategy,
-		rabbitmq.PublishingOptions{Headers: headers},
-	); err != nil {
+	// rabbitmq.Publisher не умеет выставлять приоритет и ждать подтверждений,
+	// поэтому публикуем в канал напрямую
+	publishFunc := func() error {
+		headers := amqp.Table{"x-delay": int(delay.Milliseconds())}
+		if notification.Attempt > 0 {
+			headers["x-attempt"] = notification.Attempt
+		}
+
+		publishing := amqp.Publishing{
+			Headers:     headers,
+			ContentType: "application/json",
+			MessageId:   uuid.NewString(),
+			Priority:    domain.NotificationPriority(notification.Priority).Level(),
+			Body:        body,
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), n.opts.ConfirmTimeout)
+		defer cancel()
+
+		// Ошибка повторяется по стратегии
+		err := n.publishConfirmed(ctx, n.opts.Exchange, mandatory, publishing)
+		switch {
+		case errors.Is(err, ErrConfirmTimeout), errors.Is(err, context.DeadlineExceeded):
+			metrics.Add("confirm_timeouts", 1)
+			return ErrConfirmTimeout
+		case errors.Is(err, ErrNacked):
+			metrics.Add("nacked", 1)
+			return err
+		case err != nil:
+			return err
+		}
+
+		metrics.Add("published", 1)
+		return nil
+	}
+
+	if err := retry.Do(publishFunc, strategy); err != nil {
 		return errutils.Wrap("failed to publish message with retry", err)
 	}
 
 	return nil
 }
 
-func (n *Notifier) Consume(ctx context.Context, notifications chan Message, strategy retry.Strategy) error {
-	msgChan := make(chan []byte)
+// Consume отдаёт сообщения очереди с ручным подтверждением: сообщение остаётся
+// за консьюмером, пока обработчик не вызовет Ack, Requeue или Reject.
+// После обрыва соединения подписка возобновляется на новом канале; сообщения,
+// выданные старым каналом, брокер доставит повторно.
+func (n *Notifier) Consume(ctx context.Context, deliveries chan Delivery, strategy retry.Strategy) error {
+	defer close(deliveries)
 
-	go func() {
-		defer close(notifications)
-		for {
-			select {
-			case <-ctx.Done():
+	for {
+		var msgs, legacy <-chan amqp.Delivery
+		consumeFunc := func() error {
+			channel, err := n.conn.Wait(ctx)
+			if err != nil {
+				return err
+			}
+			msgs, err = channel.Consume(n.opts.Queue, "", false, false, false, false, nil)
+			if err != nil || !n.draining.Load() {
+				return err
+			}
+			legacy, err = channel.Consume(n.opts.LegacyQueue, "", false, false, false, false, nil)
+			return err
+		}
+
+		if err := retry.Do(consumeFunc, strategy); err != nil {
+			if ctx.Err() != nil {
 				zlog.Logger.Info().Msg("consumer shutdown...")
-				return
-			case msg, ok := <-msgChan:
-				if !ok {
-					return
-				}
-
-				var notification Message
-				if err := json.Unmarshal(msg, &notification); err != nil {
-					zlog.Logger.Error().Err(err).Msg("failed to unmarshal notification message")
-					continue
-				}
-
-				notifications <- notification
+				return nil
+			}
+			if errors.Is(err, connection.ErrClosed) {
+				return errutils.Wrap("failed to start consuming", err)
 			}
+			// Канал закрылся между Wait и Consume - ждём следующего подключения
+			zlog.Logger.Warn().Err(err).Msg("failed to start consuming, waiting for connection")
+			continue
 		}
-	}()
 
-	return n.consumer.ConsumeWithRetry(msgChan, strategy)
+		if !n.forward(ctx, msgs, legacy, deliveries) {
+			zlog.Logger.Info().Msg("consumer shutdown...")
+			return nil
+		}
+		zlog.Logger.Warn().Msg("delivery channel closed by broker, resuming after reconnect")
+	}
+}
+
+// forward передаёт сообщения из msgs и вычитываемой старой очереди legacy в deliveries.
+// Возвращает false при остановке и true, если брокер закрыл канал доставки.
+func (n *Notifier) forward(ctx context.Context, msgs, legacy <-chan amqp.Delivery, deliveries chan Delivery) bool {
+	for {
+		var (
+			msg amqp.Delivery
+			ok  bool
+		)
+		select {
+		case <-ctx.Done():
+			return false
+		case msg, ok = <-msgs:
+			if !ok {
+				return true
+			}
+		case msg, ok = <-legacy:
+			if !ok {
+				// Старую очередь удалил другой инстанс
+				legacy = nil
+				continue
+			}
+		}
+
+		notification, err := Decode(msg.Body)
+		if err != nil {
+			var qErr *QuarantineError
+			errors.As(err, &qErr)
+			n.quarantine(msg, qErr)
+			continue
+		}
+
+		delivery := NewDelivery(notification, amqpAcker{delivery: msg})
+		select {
+		case <-ctx.Done():
+			delivery.Requeue()
+			return false
+		case deliveries <- delivery:
+		}
+	}
 }
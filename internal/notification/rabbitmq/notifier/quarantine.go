@@ -0,0 +1,154 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/wb-go/wbf/zlog"
+	"time"
+)
+
+// Причины карантина - сообщения, которые не обработать ни при каком повторе.
+const (
+	QuarantineDecodeError    = "decode_error"    // тело не разбирается как Message
+	QuarantineInvalidMessage = "invalid_message" // не заполнены обязательные поля
+)
+
+// Заголовки, с которыми сообщение кладётся в DLQ при карантине.
+const (
+	headerQuarantineReason = "x-quarantine-reason"
+	headerQuarantineError  = "x-quarantine-error"
+	headerQuarantineQueue  = "x-quarantine-queue"
+	headerQuarantinedAt    = "x-quarantined-at"
+)
+
+var ErrInvalidMessage = errors.New("invalid notification message")
+
+// quarantineMetrics - сколько сообщений ушло в карантин, по причинам; доступны через /debug/vars.
+var quarantineMetrics = expvar.NewMap("quarantine")
+
+// QuarantineError - сообщение не удалось разобрать; Reason - одна из причин карантина.
+type QuarantineError struct {
+	Reason string
+	Err    error
+}
+
+func (e *QuarantineError) Error() string {
+	return e.Reason + ": " + e.Err.Error()
+}
+
+func (e *QuarantineError) Unwrap() error {
+	return e.Err
+}
+
+// Decode разбирает тело сообщения и проверяет обязательные поля.
+// Ошибка всегда *QuarantineError.
+func Decode(body []byte) (Message, error) {
+	var message Message
+	if err := json.Unmarshal(body, &message); err != nil {
+		return Message{}, &QuarantineError{Reason: QuarantineDecodeError, Err: err}
+	}
+
+	if err := validate(message); err != nil {
+		return Message{}, &QuarantineError{Reason: QuarantineInvalidMessage, Err: err}
+	}
+
+	return message, nil
+}
+
+func validate(message Message) error {
+	switch {
+	case message.ID == uuid.Nil:
+		return fmt.Errorf("%w: missing ID", ErrInvalidMessage)
+	case message.Channel == "":
+		return fmt.Errorf("%w: missing Channel", ErrInvalidMessage)
+	case message.Recipient == "":
+		return fmt.Errorf("%w: missing Recipient", ErrInvalidMessage)
+	case message.ScheduledAt.IsZero():
+		return fmt.Errorf("%w: missing ScheduledAt", ErrInvalidMessage)
+	case message.Attempt < 0:
+		return fmt.Errorf("%w: negative Attempt", ErrInvalidMessage)
+	}
+
+	return nil
+}
+
+// CountQuarantined учитывает сообщение, отправленное в карантин.
+func CountQuarantined(reason string) {
+	quarantineMetrics.Add(reason, 1)
+}
+
+// quarantine перекладывает сообщение в DLQ как есть, добавив заголовки с причиной.
+// Если опубликовать копию не удалось, сообщение отклоняется и попадает в DLQ
+// через DLX - без описания ошибки, но с исходным телом.
+func (n *Notifier) quarantine(msg amqp.Delivery, qErr *QuarantineError) {
+	zlog.Logger.Error().
+		Err(qErr.Err).
+		Str("reason", qErr.Reason).
+		Str("message_id", msg.MessageId).
+		Msg("notification message quarantined")
+	CountQuarantined(qErr.Reason)
+
+	if err := n.publishQuarantined(msg, qErr); err != nil {
+		zlog.Logger.Error().Err(err).Str("message_id", msg.MessageId).Msg("failed to publish quarantined message, rejecting it")
+		if err := msg.Nack(false, false); err != nil {
+			zlog.Logger.Error().Err(err).Msg("failed to reject malformed notification message")
+		}
+		return
+	}
+
+	if err := msg.Ack(false); err != nil {
+		zlog.Logger.Error().Err(err).Str("message_id", msg.MessageId).Msg("failed to ack quarantined message")
+	}
+}
+
+func (n *Notifier) publishQuarantined(msg amqp.Delivery, qErr *QuarantineError) error {
+	headers := amqp.Table{}
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	headers[headerQuarantineReason] = qErr.Reason
+	headers[headerQuarantineError] = qErr.Err.Error()
+	headers[headerQuarantineQueue] = n.opts.Queue
+	headers[headerQuarantinedAt] = time.Now().UTC()
+
+	// Без MessageId сообщение нельзя найти в DLQ по ID
+	messageID := msg.MessageId
+	if messageID == "" {
+		messageID = uuid.NewString()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.opts.ConfirmTimeout)
+	defer cancel()
+
+	return n.publishConfirmed(ctx, n.opts.DLQ, false, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    messageID,
+		Body:         msg.Body,
+	})
+}
+
+// quarantineFromHeaders дополняет сообщение DLQ причиной карантина.
+// Возвращает false, если сообщение попало в DLQ не через карантин.
+func quarantineFromHeaders(letter *DeadLetter, headers amqp.Table) bool {
+	reason, ok := headers[headerQuarantineReason].(string)
+	if !ok {
+		return false
+	}
+
+	letter.Reason = reason
+	letter.Error, _ = headers[headerQuarantineError].(string)
+	letter.Queue, _ = headers[headerQuarantineQueue].(string)
+	letter.Count = 1
+	if diedAt, ok := headers[headerQuarantinedAt].(time.Time); ok {
+		letter.DiedAt = &diedAt
+	}
+
+	return true
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"sync"
+	"time"
+)
+
+type sent struct {
+	id string
+	at time.Time
+}
+
+// MemoryLimiter - лимитер в памяти процесса для режима --backend=memory.
+type MemoryLimiter struct {
+	mu    sync.Mutex
+	rules Rules
+	log   map[string][]sent
+}
+
+func NewMemory(rules Rules) *MemoryLimiter {
+	return &MemoryLimiter{rules: rules, log: make(map[string][]sent)}
+}
+
+func (l *MemoryLimiter) Allow(_ context.Context, channel domain.NotificationChannel, recipient string, id string) (Result, error) {
+	rule, ok := l.rules[channel]
+	if !ok {
+		return Result{Allowed: true}, nil
+	}
+
+	now := time.Now()
+	key := string(channel) + ":" + recipient
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	window := l.log[key][:0]
+	for _, s := range l.log[key] {
+		if now.Sub(s.at) < rule.Window {
+			window = append(window, s)
+		}
+	}
+
+	for _, s := range window {
+		if s.id == id {
+			l.log[key] = window
+			return Result{Allowed: true}, nil
+		}
+	}
+
+	if len(window) < rule.Limit {
+		l.log[key] = append(window, sent{id: id, at: now})
+		return Result{Allowed: true}, nil
+	}
+
+	l.log[key] = window
+	return Result{Allowed: false, RetryAt: window[0].at.Add(rule.Window)}, nil
+}
+
+// Release возвращает место в окне, занятое уведомлением id.
+func (l *MemoryLimiter) Release(_ context.Context, channel domain.NotificationChannel, recipient string, id string) error {
+	if _, ok := l.rules[channel]; !ok {
+		return nil
+	}
+
+	key := string(channel) + ":" + recipient
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	window := l.log[key][:0]
+	for _, s := range l.log[key] {
+		if s.id != id {
+			window = append(window, s)
+		}
+	}
+	l.log[key] = window
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"delayed-notifier/internal/notification/types/domain"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy - что делать с уведомлением сверх лимита.
+type Policy string
+
+const (
+	PolicyDefer Policy = "defer"
+	PolicyDrop  Policy = "drop"
+)
+
+// Rule - не более Limit уведомлений одному получателю за Window.
+type Rule struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Rules - правила по каналам. Каналы без правила не ограничиваются.
+type Rules map[domain.NotificationChannel]Rule
+
+// Result - решение лимитера.
+type Result struct {
+	Allowed bool
+	RetryAt time.Time // когда освободится место в окне, если !Allowed
+}
+
+var ErrInvalidRules = errors.New("invalid rate limit rules")
+
+// ParseRules разбирает строку вида "email:10/1h,telegram:30/10m".
+func ParseRules(value string) (Rules, error) {
+	rules := make(Rules)
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		channel, spec, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRules, part)
+		}
+
+		limitStr, windowStr, ok := strings.Cut(spec, "/")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRules, part)
+		}
+
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("%w: bad limit in %q", ErrInvalidRules, part)
+		}
+
+		window, err := time.ParseDuration(windowStr)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("%w: bad window in %q", ErrInvalidRules, part)
+		}
+
+		rules[domain.NotificationChannel(strings.TrimSpace(channel))] = Rule{Limit: limit, Window: window}
+	}
+
+	return rules, nil
+}
+
+// ParsePolicy возвращает политику, по умолчанию - отложить.
+func ParsePolicy(value string) (Policy, error) {
+	switch Policy(value) {
+	case "", PolicyDefer:
+		return PolicyDefer, nil
+	case PolicyDrop:
+		return PolicyDrop, nil
+	default:
+		return "", fmt.Errorf("unknown rate limit policy %q", value)
+	}
+}
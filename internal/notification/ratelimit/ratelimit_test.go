@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("email:10/1h, telegram:30/10m")
+	if err != nil {
+		t.Fatalf("ParseRules: %v", err)
+	}
+
+	if got := rules[domain.Email]; got.Limit != 10 || got.Window != time.Hour {
+		t.Fatalf("email: got %+v", got)
+	}
+	if got := rules[domain.Telegram]; got.Limit != 30 || got.Window != 10*time.Minute {
+		t.Fatalf("telegram: got %+v", got)
+	}
+
+	for _, value := range []string{"email", "email:10", "email:0/1h", "email:10/soon", "email:10/-1h"} {
+		if _, err := ParseRules(value); !errors.Is(err, ErrInvalidRules) {
+			t.Fatalf("ParseRules(%q): got %v, want ErrInvalidRules", value, err)
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for value, want := range map[string]Policy{"": PolicyDefer, "defer": PolicyDefer, "drop": PolicyDrop} {
+		if got, err := ParsePolicy(value); err != nil || got != want {
+			t.Fatalf("ParsePolicy(%q): got %s, %v, want %s", value, got, err, want)
+		}
+	}
+	if _, err := ParsePolicy("ignore"); err == nil {
+		t.Fatal("ParsePolicy(ignore): want error")
+	}
+}
+
+// limiter - общий интерфейс лимитеров в памяти и на Redis.
+type limiter interface {
+	Allow(ctx context.Context, channel domain.NotificationChannel, recipient string, id string) (Result, error)
+	Release(ctx context.Context, channel domain.NotificationChannel, recipient string, id string) error
+}
+
+func allow(t *testing.T, l limiter, recipient string, id string) Result {
+	t.Helper()
+
+	res, err := l.Allow(context.Background(), domain.Email, recipient, id)
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	return res
+}
+
+// testLimiter проверяет лимитер с правилом email: 2 за window.
+func testLimiter(t *testing.T, newLimiter func(t *testing.T) limiter, window time.Duration) {
+	t.Run("limit per recipient", func(t *testing.T) {
+		l := newLimiter(t)
+		// Redis хранит время с точностью до миллисекунды
+		startedAt := time.Now().Truncate(time.Millisecond)
+
+		if !allow(t, l, "a@example.com", "1").Allowed || !allow(t, l, "a@example.com", "2").Allowed {
+			t.Fatal("sends within the limit were rejected")
+		}
+
+		res := allow(t, l, "a@example.com", "3")
+		if res.Allowed {
+			t.Fatal("send over the limit was allowed")
+		}
+		if res.RetryAt.Before(startedAt.Add(window)) || res.RetryAt.After(time.Now().Add(window)) {
+			t.Fatalf("RetryAt: got %s, want the oldest send plus window", res.RetryAt)
+		}
+
+		// Лимит считается по получателю
+		if !allow(t, l, "b@example.com", "4").Allowed {
+			t.Fatal("send to another recipient was rejected")
+		}
+	})
+
+	t.Run("repeated check does not spend the limit", func(t *testing.T) {
+		l := newLimiter(t)
+
+		for i := 0; i < 3; i++ {
+			if !allow(t, l, "a@example.com", "1").Allowed {
+				t.Fatalf("check %d of the same notification was rejected", i+1)
+			}
+		}
+		if !allow(t, l, "a@example.com", "2").Allowed {
+			t.Fatal("second notification was rejected")
+		}
+	})
+
+	t.Run("window slides", func(t *testing.T) {
+		l := newLimiter(t)
+
+		allow(t, l, "a@example.com", "1")
+		allow(t, l, "a@example.com", "2")
+
+		time.Sleep(window + 10*time.Millisecond)
+		if !allow(t, l, "a@example.com", "3").Allowed {
+			t.Fatal("send after the window was rejected")
+		}
+	})
+
+	t.Run("release frees the slot", func(t *testing.T) {
+		l := newLimiter(t)
+
+		allow(t, l, "a@example.com", "1")
+		allow(t, l, "a@example.com", "2")
+		if err := l.Release(context.Background(), domain.Email, "a@example.com", "2"); err != nil {
+			t.Fatalf("Release: %v", err)
+		}
+
+		if !allow(t, l, "a@example.com", "3").Allowed {
+			t.Fatal("send after release was rejected")
+		}
+		if allow(t, l, "a@example.com", "4").Allowed {
+			t.Fatal("release freed more than one slot")
+		}
+	})
+
+	t.Run("channel without rule", func(t *testing.T) {
+		l := newLimiter(t)
+
+		for i := 0; i < 5; i++ {
+			res, err := l.Allow(context.Background(), domain.Telegram, "a@example.com", string(rune('a'+i)))
+			if err != nil || !res.Allowed {
+				t.Fatalf("Allow: %v, allowed %v", err, res.Allowed)
+			}
+		}
+	})
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	const window = 50 * time.Millisecond
+
+	testLimiter(t, func(*testing.T) limiter {
+		return NewMemory(Rules{domain.Email: {Limit: 2, Window: window}})
+	}, window)
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"delayed-notifier/pkg/errutils"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/wb-go/wbf/redis"
+	"time"
+)
+
+// slidingWindowScript - скользящее окно на ZSET: score - время отправки.
+// Член множества - ID уведомления, поэтому повторная проверка того же
+// уведомления не расходует лимит дважды.
+var slidingWindowScript = goredis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+
+if redis.call('ZSCORE', KEYS[1], ARGV[4]) then
+	return {1, 0}
+end
+
+if redis.call('ZCARD', KEYS[1]) < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], window)
+	return {1, 0}
+end
+
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {0, tonumber(oldest[2]) + window}
+`)
+
+// Limiter - лимитер на Redis, общий для всех инстансов сервиса.
+type Limiter struct {
+	client    *redis.Client
+	rules     Rules
+	keyPrefix string
+}
+
+func New(client *redis.Client, rules Rules, keyPrefix string) *Limiter {
+	if keyPrefix == "" {
+		keyPrefix = "notifier:ratelimit"
+	}
+	return &Limiter{client: client, rules: rules, keyPrefix: keyPrefix}
+}
+
+func (l *Limiter) key(channel domain.NotificationChannel, recipient string) string {
+	return l.keyPrefix + ":" + string(channel) + ":" + recipient
+}
+
+func (l *Limiter) Allow(ctx context.Context, channel domain.NotificationChannel, recipient string, id string) (Result, error) {
+	rule, ok := l.rules[channel]
+	if !ok {
+		return Result{Allowed: true}, nil
+	}
+
+	now := time.Now()
+	res, err := slidingWindowScript.Run(
+		ctx,
+		l.client.Client,
+		[]string{l.key(channel, recipient)},
+		now.UnixMilli(),
+		rule.Window.Milliseconds(),
+		rule.Limit,
+		id,
+	).Int64Slice()
+	if err != nil {
+		return Result{}, errutils.Wrap("failed to check rate limit", err)
+	}
+
+	if res[0] == 1 {
+		return Result{Allowed: true}, nil
+	}
+
+	return Result{Allowed: false, RetryAt: time.UnixMilli(res[1])}, nil
+}
+
+// Release возвращает место в окне, занятое уведомлением id.
+func (l *Limiter) Release(ctx context.Context, channel domain.NotificationChannel, recipient string, id string) error {
+	if _, ok := l.rules[channel]; !ok {
+		return nil
+	}
+
+	if err := l.client.ZRem(ctx, l.key(channel, recipient), id).Err(); err != nil {
+		return errutils.Wrap("failed to release rate limit slot", err)
+	}
+
+	return nil
+}
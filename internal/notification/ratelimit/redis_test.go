@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/wb-go/wbf/redis"
+	"testing"
+	"time"
+)
+
+func newRedisLimiter(t *testing.T, rules Rules) (*Limiter, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := &redis.Client{Client: goredis.NewClient(&goredis.Options{Addr: server.Addr()})}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return New(client, rules, ""), server
+}
+
+func TestRedisLimiter(t *testing.T) {
+	const window = 50 * time.Millisecond
+
+	testLimiter(t, func(t *testing.T) limiter {
+		l, _ := newRedisLimiter(t, Rules{domain.Email: {Limit: 2, Window: window}})
+		return l
+	}, window)
+
+	t.Run("key expires with the window", func(t *testing.T) {
+		l, server := newRedisLimiter(t, Rules{domain.Email: {Limit: 2, Window: time.Minute}})
+		allow(t, l, "a@example.com", "1")
+
+		key := l.key(domain.Email, "a@example.com")
+		if ttl := server.TTL(key); ttl <= 0 || ttl > time.Minute {
+			t.Fatalf("TTL: got %s, want up to the window", ttl)
+		}
+	})
+
+	t.Run("unavailable redis", func(t *testing.T) {
+		l, server := newRedisLimiter(t, Rules{domain.Email: {Limit: 2, Window: time.Minute}})
+		server.Close()
+
+		if _, err := l.Allow(context.Background(), domain.Email, "a@example.com", "1"); err == nil {
+			t.Fatal("Allow: want error")
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package reconciler
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"delayed-notifier/pkg/errutils"
+	"github.com/wb-go/wbf/retry"
+	"github.com/wb-go/wbf/zlog"
+	"sync"
+	"time"
+)
+
+type Notification interface {
+	FindLost(ctx context.Context, olderThan time.Time, limit int) ([]domain.Notification, error)
+	Republish(ctx context.Context, notification domain.Notification, strategy retry.Strategy) error
+}
+
+type Opts struct {
+	Grace     time.Duration // насколько уведомление должно опоздать, чтобы считаться потерянным
+	BatchSize int
+	Interval  time.Duration // период фоновой сверки, 0 - только при старте
+	DryRun    bool          // фоновая сверка только составляет отчёт
+}
+
+// Item - потерянное уведомление в отчёте.
+type Item struct {
+	ID          string    `json:"id"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	Channel     string    `json:"channel"`
+	Recipient   string    `json:"recipient"`
+	Republished bool      `json:"republished"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Report - результат одного прохода сверки.
+type Report struct {
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	DryRun      bool      `json:"dry_run"`
+	Found       int       `json:"found"`
+	Republished int       `json:"republished"`
+	Failed      int       `json:"failed"`
+	Items       []Item    `json:"items"`
+}
+
+// Reconciler находит уведомления, оставшиеся в статусе scheduled после того,
+// как публикация не удалась или брокер потерял сообщение, и публикует их заново.
+type Reconciler struct {
+	notification Notification
+	strategy     retry.Strategy
+	opts         Opts
+
+	mu   sync.Mutex
+	last *Report
+}
+
+func New(notification Notification, strategy retry.Strategy, opts Opts) *Reconciler {
+	if opts.Grace <= 0 {
+		opts.Grace = 5 * time.Minute
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+
+	return &Reconciler{
+		notification: notification,
+		strategy:     strategy,
+		opts:         opts,
+	}
+}
+
+// Run выполняет один проход сверки. В режиме dryRun ничего не публикуется.
+func (r *Reconciler) Run(ctx context.Context, dryRun bool) (Report, error) {
+	const op = "reconciler.Run"
+
+	report := Report{StartedAt: time.Now().UTC(), DryRun: dryRun, Items: []Item{}}
+
+	lost, err := r.notification.FindLost(ctx, report.StartedAt.Add(-r.opts.Grace), r.opts.BatchSize)
+	if err != nil {
+		return Report{}, errutils.Wrap(op, err)
+	}
+
+	report.Found = len(lost)
+	for _, n := range lost {
+		item := Item{
+			ID:          n.ID.String(),
+			ScheduledAt: n.ScheduledAt,
+			Channel:     string(n.Channel),
+			Recipient:   n.Recipient,
+		}
+
+		if !dryRun {
+			if err := r.notification.Republish(ctx, n, r.strategy); err != nil {
+				zlog.Logger.Error().Err(err).Str("id", item.ID).Msg("failed to republish lost notification")
+				item.Error = err.Error()
+				report.Failed++
+			} else {
+				item.Republished = true
+				report.Republished++
+			}
+		}
+
+		report.Items = append(report.Items, item)
+	}
+
+	report.FinishedAt = time.Now().UTC()
+
+	r.mu.Lock()
+	r.last = &report
+	r.mu.Unlock()
+
+	if report.Found > 0 {
+		zlog.Logger.Warn().
+			Bool("dry_run", dryRun).
+			Int("found", report.Found).
+			Int("republished", report.Republished).
+			Int("failed", report.Failed).
+			Msg("reconciled lost notifications")
+	}
+
+	return report, nil
+}
+
+// Start запускает сверку сразу и затем с периодом Interval, пока не отменён ctx.
+func (r *Reconciler) Start(ctx context.Context) {
+	if _, err := r.Run(ctx, r.opts.DryRun); err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to reconcile lost notifications")
+	}
+
+	if r.opts.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Run(ctx, r.opts.DryRun); err != nil {
+				zlog.Logger.Error().Err(err).Msg("failed to reconcile lost notifications")
+			}
+		}
+	}
+}
+
+// LastReport возвращает отчёт последнего прохода или nil, если проходов не было.
+func (r *Reconciler) LastReport() *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.last
+}
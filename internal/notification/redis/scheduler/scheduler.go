@@ -0,0 +1,417 @@
+package scheduler
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/rabbitmq/notifier"
+	"delayed-notifier/pkg/errutils"
+	"encoding/json"
+	"errors"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/redis"
+	"github.com/wb-go/wbf/retry"
+	"github.com/wb-go/wbf/zlog"
+	"sort"
+	"time"
+)
+
+// claimScript атомарно забирает наступившие элементы из due в processing,
+// выставляя им дедлайн видимости. Возвращает пары token, payload.
+var claimScript = goredis.NewScript(`
+local tokens = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+local res = {}
+for _, token in ipairs(tokens) do
+	redis.call('ZREM', KEYS[1], token)
+	local payload = redis.call('HGET', KEYS[3], token)
+	if payload then
+		redis.call('ZADD', KEYS[2], ARGV[3], token)
+		table.insert(res, token)
+		table.insert(res, payload)
+	end
+end
+return res
+`)
+
+// requeueScript возвращает в due элементы, чей дедлайн видимости истёк
+// (воркер упал или завис, не подтвердив обработку).
+var requeueScript = goredis.NewScript(`
+local tokens = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, token in ipairs(tokens) do
+	redis.call('ZREM', KEYS[1], token)
+	redis.call('ZADD', KEYS[2], ARGV[1], token)
+end
+return #tokens
+`)
+
+// purgeScript атомарно удаляет весь DLQ и возвращает число удалённых сообщений:
+// сообщение, попавшее в DLQ между подсчётом и удалением, не потеряется из счёта.
+var purgeScript = goredis.NewScript(`
+local count = redis.call('HLEN', KEYS[1])
+redis.call('DEL', KEYS[1])
+return count
+`)
+
+type Opts struct {
+	KeyPrefix         string
+	PollInterval      time.Duration
+	VisibilityTimeout time.Duration
+	BatchSize         int
+}
+
+// Scheduler - планировщик уведомлений на Redis ZSET, где score - время отправки.
+// Реализует те же интерфейсы публикации и потребления, что и notifier.Notifier.
+type Scheduler struct {
+	client *redis.Client
+	opts   Opts
+
+	dueKey        string
+	processingKey string
+	payloadKey    string
+	deadKey       string
+}
+
+func New(client *redis.Client, opts Opts) *Scheduler {
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = "notifier:schedule"
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = 10 * time.Minute
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+
+	return &Scheduler{
+		client:        client,
+		opts:          opts,
+		dueKey:        opts.KeyPrefix + ":due",
+		processingKey: opts.KeyPrefix + ":processing",
+		payloadKey:    opts.KeyPrefix + ":payload",
+		deadKey:       opts.KeyPrefix + ":dead",
+	}
+}
+
+func (s *Scheduler) Publish(notification notifier.Message, strategy retry.Strategy) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return errutils.Wrap("failed to marshal notification message", err)
+	}
+
+	// Каждая публикация получает собственный токен, чтобы повторная публикация
+	// того же уведомления не затирала payload ещё не подтверждённой доставки.
+	token := uuid.NewString()
+	score := float64(notification.ScheduledAt.UnixMilli())
+
+	ctx := context.Background()
+	publishFunc := func() error {
+		_, err := s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.HSet(ctx, s.payloadKey, token, body)
+			pipe.ZAdd(ctx, s.dueKey, &goredis.Z{Score: score, Member: token})
+			return nil
+		})
+		return err
+	}
+
+	if err := retry.Do(publishFunc, strategy); err != nil {
+		return errutils.Wrap("failed to schedule message with retry", err)
+	}
+
+	return nil
+}
+
+// VisibilityTimeout - сколько элемент может оставаться неподтверждённым
+// до повторной доставки, с учётом значения по умолчанию.
+func (s *Scheduler) VisibilityTimeout() time.Duration {
+	return s.opts.VisibilityTimeout
+}
+
+// Consume отдаёт наступившие уведомления. Элемент остаётся в processing, пока
+// обработчик не подтвердит его; неподтверждённые за VisibilityTimeout
+// возвращаются в due и доставляются повторно.
+func (s *Scheduler) Consume(ctx context.Context, deliveries chan notifier.Delivery, strategy retry.Strategy) error {
+	defer close(deliveries)
+
+	ticker := time.NewTicker(s.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.requeueAbandoned(ctx); err != nil {
+			zlog.Logger.Error().Err(err).Msg("failed to requeue abandoned notifications")
+		}
+
+		for {
+			claimed, err := s.claim(ctx, strategy)
+			if err != nil {
+				zlog.Logger.Error().Err(err).Msg("failed to claim due notifications")
+				break
+			}
+
+			for i, item := range claimed {
+				acker := redisAcker{scheduler: s, item: item}
+
+				notification, err := notifier.Decode([]byte(item.payload))
+				if err != nil {
+					var qErr *notifier.QuarantineError
+					errors.As(err, &qErr)
+					s.quarantine(item, qErr)
+					continue
+				}
+
+				delivery := notifier.NewDelivery(notification, acker)
+				select {
+				case <-ctx.Done():
+					// Невыданные элементы пачки сразу возвращаем в due, не дожидаясь VisibilityTimeout
+					for _, rest := range claimed[i:] {
+						if err := (redisAcker{scheduler: s, item: rest}).Nack(true); err != nil {
+							zlog.Logger.Error().Err(err).Msg("failed to requeue claimed notification")
+						}
+					}
+					zlog.Logger.Info().Msg("consumer shutdown...")
+					return nil
+				case deliveries <- delivery:
+				}
+			}
+
+			if len(claimed) < s.opts.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			zlog.Logger.Info().Msg("consumer shutdown...")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+type claimedItem struct {
+	token   string
+	payload string
+}
+
+func (s *Scheduler) claim(ctx context.Context, strategy retry.Strategy) ([]claimedItem, error) {
+	now := time.Now()
+	deadline := now.Add(s.opts.VisibilityTimeout)
+
+	var res []string
+	claimFunc := func() error {
+		var err error
+		res, err = claimScript.Run(
+			ctx,
+			s.client.Client,
+			[]string{s.dueKey, s.processingKey, s.payloadKey},
+			now.UnixMilli(),
+			s.opts.BatchSize,
+			deadline.UnixMilli(),
+		).StringSlice()
+		return err
+	}
+
+	if err := retry.Do(claimFunc, strategy); err != nil {
+		return nil, err
+	}
+
+	claimed := make([]claimedItem, 0, len(res)/2)
+	for i := 0; i+1 < len(res); i += 2 {
+		claimed = append(claimed, claimedItem{token: res[i], payload: res[i+1]})
+	}
+
+	return claimed, nil
+}
+
+func (s *Scheduler) requeueAbandoned(ctx context.Context) error {
+	return requeueScript.Run(
+		ctx,
+		s.client.Client,
+		[]string{s.processingKey, s.dueKey},
+		time.Now().UnixMilli(),
+		s.opts.BatchSize,
+	).Err()
+}
+
+// redisAcker подтверждает элемент, забранный в processing.
+// Подтверждения не зависят от контекста консьюмера, чтобы пережить его отмену.
+type redisAcker struct {
+	scheduler *Scheduler
+	item      claimedItem
+}
+
+func (a redisAcker) Ack() error {
+	ctx := context.Background()
+	s := a.scheduler
+
+	_, err := s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.ZRem(ctx, s.processingKey, a.item.token)
+		pipe.HDel(ctx, s.payloadKey, a.item.token)
+		return nil
+	})
+	return err
+}
+
+func (a redisAcker) Nack(requeue bool) error {
+	ctx := context.Background()
+	s := a.scheduler
+
+	if !requeue {
+		return s.bury(ctx, a.item, deadRecord{Payload: a.item.payload, Reason: "rejected", DiedAt: time.Now().UTC()})
+	}
+
+	_, err := s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.ZRem(ctx, s.processingKey, a.item.token)
+		pipe.ZAdd(ctx, s.dueKey, &goredis.Z{Score: float64(time.Now().UnixMilli()), Member: a.item.token})
+		return nil
+	})
+	return err
+}
+
+// quarantine перекладывает неразбираемый элемент в DLQ как есть, с причиной и ошибкой разбора.
+func (s *Scheduler) quarantine(item claimedItem, qErr *notifier.QuarantineError) {
+	zlog.Logger.Error().
+		Err(qErr.Err).
+		Str("reason", qErr.Reason).
+		Str("token", item.token).
+		Msg("notification message quarantined")
+	notifier.CountQuarantined(qErr.Reason)
+
+	record := deadRecord{
+		Payload: item.payload,
+		Reason:  qErr.Reason,
+		Error:   qErr.Err.Error(),
+		DiedAt:  time.Now().UTC(),
+	}
+	if err := s.bury(context.Background(), item, record); err != nil {
+		// Элемент остаётся в processing и по истечении VisibilityTimeout попадёт сюда снова
+		zlog.Logger.Error().Err(err).Str("token", item.token).Msg("failed to quarantine notification message")
+	}
+}
+
+// bury переносит элемент из processing в DLQ.
+func (s *Scheduler) bury(ctx context.Context, item claimedItem, record deadRecord) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.ZRem(ctx, s.processingKey, item.token)
+		pipe.HDel(ctx, s.payloadKey, item.token)
+		pipe.HSet(ctx, s.deadKey, item.token, raw)
+		return nil
+	})
+	return err
+}
+
+// deadRecord - элемент DLQ, хранится в хэше dead по токену публикации.
+type deadRecord struct {
+	Payload string
+	Reason  string
+	Error   string `json:",omitempty"` // ошибка разбора для элементов из карантина
+	DiedAt  time.Time
+}
+
+func (r deadRecord) deadLetter(token string) notifier.DeadLetter {
+	letter := notifier.NewDeadLetter(token, []byte(r.Payload))
+	letter.Reason = r.Reason
+	letter.Error = r.Error
+	letter.Count = 1
+	letter.DiedAt = &r.DiedAt
+	return letter
+}
+
+// DeadLetters возвращает до limit сообщений DLQ, упорядоченных по времени попадания.
+// Хэш обходится HSCAN порциями по limit, пока не наберётся limit сообщений,
+// поэтому на большом DLQ это не обязательно самые старые сообщения.
+func (s *Scheduler) DeadLetters(ctx context.Context, limit int) ([]notifier.DeadLetter, error) {
+	letters := make([]notifier.DeadLetter, 0, limit)
+	var cursor uint64
+	for {
+		// HSCAN возвращает плоский список поле, значение
+		fields, next, err := s.client.Client.HScan(ctx, s.deadKey, cursor, "", int64(limit)).Result()
+		if err != nil {
+			return nil, errutils.Wrap("failed to scan dead letters", err)
+		}
+
+		for i := 0; i+1 < len(fields) && len(letters) < limit; i += 2 {
+			token, raw := fields[i], fields[i+1]
+			var record deadRecord
+			if err := json.Unmarshal([]byte(raw), &record); err != nil {
+				zlog.Logger.Error().Err(err).Str("token", token).Msg("failed to unmarshal dead letter")
+				continue
+			}
+			letters = append(letters, record.deadLetter(token))
+		}
+
+		cursor = next
+		if cursor == 0 || len(letters) >= limit {
+			break
+		}
+	}
+
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].DiedAt.Before(*letters[j].DiedAt)
+	})
+
+	return letters, nil
+}
+
+func (s *Scheduler) DeadLetter(ctx context.Context, ID string) (notifier.DeadLetter, error) {
+	raw, err := s.client.HGet(ctx, s.deadKey, ID).Result()
+	if err != nil {
+		if errors.Is(err, goredis.Nil) {
+			return notifier.DeadLetter{}, notifier.ErrDeadLetterNotFound
+		}
+		return notifier.DeadLetter{}, errutils.Wrap("failed to get dead letter", err)
+	}
+
+	var record deadRecord
+	if err := json.Unmarshal([]byte(raw), &record); err != nil {
+		return notifier.DeadLetter{}, errutils.Wrap("failed to unmarshal dead letter", err)
+	}
+
+	return record.deadLetter(ID), nil
+}
+
+func (s *Scheduler) ReplayDeadLetters(ctx context.Context, IDs []string, replay notifier.ReplayFunc) error {
+	for _, id := range IDs {
+		letter, err := s.DeadLetter(ctx, id)
+		if err != nil {
+			if errors.Is(err, notifier.ErrDeadLetterNotFound) {
+				continue
+			}
+			return err
+		}
+
+		if replay(letter) != nil {
+			continue
+		}
+
+		if err := s.client.HDel(ctx, s.deadKey, id).Err(); err != nil {
+			return errutils.Wrap("failed to delete replayed dead letter", err)
+		}
+	}
+
+	return nil
+}
+
+// PurgeDeadLetters удаляет выбранные сообщения DLQ, при пустом IDs - все.
+func (s *Scheduler) PurgeDeadLetters(ctx context.Context, IDs []string) (int, error) {
+	if len(IDs) == 0 {
+		count, err := purgeScript.Run(ctx, s.client.Client, []string{s.deadKey}).Int()
+		if err != nil {
+			return 0, errutils.Wrap("failed to purge dead letters", err)
+		}
+		return count, nil
+	}
+
+	purged, err := s.client.HDel(ctx, s.deadKey, IDs...).Result()
+	if err != nil {
+		return 0, errutils.Wrap("failed to purge dead letters", err)
+	}
+
+	return int(purged), nil
+}
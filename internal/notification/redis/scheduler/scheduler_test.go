@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/wb-go/wbf/redis"
+	"testing"
+	"time"
+)
+
+func newScheduler(t *testing.T) (*Scheduler, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := &redis.Client{Client: goredis.NewClient(&goredis.Options{Addr: server.Addr()})}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return New(client, Opts{}), server
+}
+
+func addDeadLetters(t *testing.T, s *Scheduler, count int) {
+	t.Helper()
+
+	diedAt := time.Now().Add(-time.Hour)
+	for i := 0; i < count; i++ {
+		raw, err := json.Marshal(deadRecord{
+			Payload: "{}",
+			Reason:  "rejected",
+			DiedAt:  diedAt.Add(time.Duration(i) * time.Second),
+		})
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		token := fmt.Sprintf("token-%03d", i)
+		if err := s.client.HSet(context.Background(), s.deadKey, token, raw).Err(); err != nil {
+			t.Fatalf("HSet: %v", err)
+		}
+	}
+}
+
+func TestDeadLettersRespectsLimit(t *testing.T) {
+	s, _ := newScheduler(t)
+	addDeadLetters(t, s, 25)
+
+	letters, err := s.DeadLetters(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("DeadLetters: %v", err)
+	}
+	if len(letters) != 10 {
+		t.Fatalf("letters: got %d, want 10", len(letters))
+	}
+	for i := 1; i < len(letters); i++ {
+		if letters[i].DiedAt.Before(*letters[i-1].DiedAt) {
+			t.Fatal("letters are not sorted by DiedAt")
+		}
+	}
+
+	all, err := s.DeadLetters(context.Background(), 100)
+	if err != nil {
+		t.Fatalf("DeadLetters: %v", err)
+	}
+	if len(all) != 25 {
+		t.Fatalf("letters: got %d, want 25", len(all))
+	}
+}
+
+func TestPurgeDeadLetters(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("all", func(t *testing.T) {
+		s, server := newScheduler(t)
+		addDeadLetters(t, s, 5)
+
+		purged, err := s.PurgeDeadLetters(ctx, nil)
+		if err != nil {
+			t.Fatalf("PurgeDeadLetters: %v", err)
+		}
+		if purged != 5 {
+			t.Fatalf("purged: got %d, want 5", purged)
+		}
+		if server.Exists(s.deadKey) {
+			t.Fatal("DLQ was not deleted")
+		}
+	})
+
+	t.Run("selected", func(t *testing.T) {
+		s, _ := newScheduler(t)
+		addDeadLetters(t, s, 3)
+
+		purged, err := s.PurgeDeadLetters(ctx, []string{"token-000", "token-002", "missing"})
+		if err != nil {
+			t.Fatalf("PurgeDeadLetters: %v", err)
+		}
+		if purged != 2 {
+			t.Fatalf("purged: got %d, want 2", purged)
+		}
+
+		letters, err := s.DeadLetters(ctx, 10)
+		if err != nil {
+			t.Fatalf("DeadLetters: %v", err)
+		}
+		if len(letters) != 1 || letters[0].ID != "token-001" {
+			t.Fatalf("letters left: %+v", letters)
+		}
+	})
+}
@@ -0,0 +1,572 @@
+package memory
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/repo"
+	"delayed-notifier/internal/notification/types/domain"
+	"delayed-notifier/pkg/errutils"
+	"github.com/google/uuid"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Repo - хранилище уведомлений в памяти процесса для разработки и тестов.
+type Repo struct {
+	mu              sync.RWMutex
+	notifications   map[uuid.UUID]domain.Notification
+	idempotencyKeys map[string]domain.IdempotencyKey
+	followUps       map[uuid.UUID][]domain.FollowUp
+	attempts        map[uuid.UUID][]domain.Attempt
+	outbox          []outboxEntry
+	outboxSeq       int64
+}
+
+type outboxEntry struct {
+	ID             int64
+	NotificationID uuid.UUID
+	ClaimedUntil   time.Time
+}
+
+func New() *Repo {
+	return &Repo{
+		notifications:   make(map[uuid.UUID]domain.Notification),
+		idempotencyKeys: make(map[string]domain.IdempotencyKey),
+		followUps:       make(map[uuid.UUID][]domain.FollowUp),
+		attempts:        make(map[uuid.UUID][]domain.Attempt),
+	}
+}
+
+func (r *Repo) CreateNotification(_ context.Context, notification domain.Notification) error {
+	const op = "repo.memory.Create"
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.insert(notification); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	return nil
+}
+
+func (r *Repo) CreateNotificationIdempotent(
+	_ context.Context,
+	key domain.IdempotencyKey,
+	notification domain.Notification,
+	ttl time.Duration,
+) (domain.IdempotencyKey, bool, error) {
+	const op = "repo.memory.CreateNotificationIdempotent"
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, ok := r.idempotencyKeys[key.Key]; ok && time.Since(stored.CreatedAt) < ttl {
+		return stored, false, nil
+	}
+
+	if err := r.insert(notification); err != nil {
+		return domain.IdempotencyKey{}, false, errutils.Wrap(op, err)
+	}
+
+	key.CreatedAt = time.Now().UTC()
+	r.idempotencyKeys[key.Key] = key
+
+	return key, true, nil
+}
+
+// insert вызывается под r.mu
+func (r *Repo) insert(notification domain.Notification) error {
+	if _, ok := r.notifications[notification.ID]; ok {
+		return repo.ErrNotifExists
+	}
+
+	now := time.Now().UTC()
+	if notification.Status == "" {
+		notification.Status = domain.Scheduled
+	}
+	notification.CreatedAt = now
+	notification.UpdatedAt = now
+
+	for _, followUp := range notification.FollowUps {
+		followUp.ParentID = notification.ID
+		r.followUps[notification.ID] = append(r.followUps[notification.ID], followUp)
+	}
+	notification.FollowUps = nil
+
+	r.notifications[notification.ID] = notification
+	r.outboxSeq++
+	r.outbox = append(r.outbox, outboxEntry{ID: r.outboxSeq, NotificationID: notification.ID})
+
+	return nil
+}
+
+func (r *Repo) GetStatusByID(_ context.Context, ID uuid.UUID) (domain.NotificationStatus, error) {
+	const op = "repo.memory.GetStatusByID"
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	notification, ok := r.notifications[ID]
+	if !ok {
+		return "", errutils.Wrap(op, repo.ErrNotifNotFound)
+	}
+
+	return notification.Status, nil
+}
+
+func (r *Repo) UpdateStatus(_ context.Context, ID uuid.UUID, version int64, status domain.NotificationStatus) error {
+	const op = "repo.memory.UpdateStatus"
+
+	return r.transition(op, ID, version, status, func(n *domain.Notification) {})
+}
+
+// CancelNotification отменяет уведомление и его follow-up'ы под одной блокировкой.
+// У завершённого уведомления follow-up'ы отменяются, только если followUpsOfFinished.
+// Follow-up'ы отменяются и тогда, когда само уведомление отменить уже нельзя.
+func (r *Repo) CancelNotification(
+	_ context.Context,
+	ID uuid.UUID,
+	cancellation domain.Cancellation,
+	followUpCancellation domain.Cancellation,
+	followUpsOfFinished bool,
+) ([]uuid.UUID, error) {
+	const op = "repo.memory.CancelNotification"
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	notification, ok := r.notifications[ID]
+	if !ok {
+		return nil, errutils.Wrap(op, repo.ErrNotifNotFound)
+	}
+
+	if !repo.CanTransition(notification.Status, domain.Canceled) {
+		transitionErr := errutils.Wrap(op, &repo.TransitionError{From: notification.Status, To: domain.Canceled})
+		if !followUpsOfFinished || !repo.IsFinal(notification.Status) {
+			return nil, transitionErr
+		}
+		return r.cancelFollowUps(ID, followUpCancellation), transitionErr
+	}
+
+	notification.Status = domain.Canceled
+	notification.Version++
+	notification.LeaseUntil = nil
+	notification.Cancellation = &cancellation
+	notification.UpdatedAt = time.Now().UTC()
+	r.notifications[ID] = notification
+
+	return r.cancelFollowUps(ID, followUpCancellation), nil
+}
+
+// ClaimForSending переводит уведомление в статус sending до leaseUntil, если оно
+// в статусе scheduled или его аренда истекла, и возвращает новую версию уведомления.
+// Уведомление, перенесённое на время позже now, не берётся: repo.ErrNotDue.
+func (r *Repo) ClaimForSending(_ context.Context, ID uuid.UUID, now time.Time, leaseUntil time.Time) (int64, error) {
+	const op = "repo.memory.ClaimForSending"
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	notification, ok := r.notifications[ID]
+	if !ok {
+		return 0, errutils.Wrap(op, repo.ErrNotifNotFound)
+	}
+
+	leaseExpired := notification.Status == domain.Sending &&
+		(notification.LeaseUntil == nil || notification.LeaseUntil.Before(now))
+	if notification.Status != domain.Scheduled && !leaseExpired {
+		return 0, errutils.Wrap(op, repo.ErrNotClaimable)
+	}
+	if notification.ScheduledAt.After(now) {
+		return 0, errutils.Wrap(op, repo.ErrNotDue)
+	}
+
+	notification.Status = domain.Sending
+	notification.Version++
+	notification.LeaseUntil = &leaseUntil
+	notification.UpdatedAt = time.Now().UTC()
+	r.notifications[ID] = notification
+
+	return notification.Version, nil
+}
+
+func (r *Repo) GetByID(_ context.Context, ID uuid.UUID) (domain.Notification, error) {
+	const op = "repo.memory.GetByID"
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	notification, ok := r.notifications[ID]
+	if !ok {
+		return domain.Notification{}, errutils.Wrap(op, repo.ErrNotifNotFound)
+	}
+
+	return notification, nil
+}
+
+func (r *Repo) DeferNotification(_ context.Context, ID uuid.UUID, version int64, until time.Time) error {
+	const op = "repo.memory.DeferNotification"
+
+	return r.transition(op, ID, version, domain.Scheduled, func(n *domain.Notification) {
+		n.ScheduledAt = until
+		n.DeferredUntil = &until
+		n.RateLimit = domain.RateLimitDeferred
+		n.LastAttemptAt = nil
+	})
+}
+
+func (r *Repo) RecordSendFailure(
+	_ context.Context,
+	ID uuid.UUID,
+	version int64,
+	retries int,
+	nextRetryAt *time.Time,
+	failure domain.SendFailure,
+) error {
+	const op = "repo.memory.RecordSendFailure"
+
+	apply := func(n *domain.Notification) {
+		n.Retries = retries
+		n.NextRetryAt = nextRetryAt
+		n.LastFailure = &failure
+		n.LastAttemptAt = nil
+	}
+
+	// При запланированном повторе уведомление возвращается в scheduled
+	if nextRetryAt != nil {
+		return r.transition(op, ID, version, domain.Scheduled, apply)
+	}
+
+	return r.update(op, ID, version, apply)
+}
+
+func (r *Repo) Reschedule(_ context.Context, ID uuid.UUID, version int64, scheduledAt time.Time) error {
+	const op = "repo.memory.Reschedule"
+
+	return r.transition(op, ID, version, domain.Scheduled, func(n *domain.Notification) {
+		n.ScheduledAt = scheduledAt
+		n.LastAttemptAt = nil
+	})
+}
+
+// ReviveNotification возвращает переопубликованное из DLQ уведомление в scheduled,
+// в том числе из конечных статусов failed, expired и dropped.
+func (r *Repo) ReviveNotification(_ context.Context, ID uuid.UUID, scheduledAt time.Time) error {
+	const op = "repo.memory.ReviveNotification"
+
+	return r.apply(op, ID, 0, domain.Scheduled, repo.CanRevive, func(n *domain.Notification) {
+		n.ScheduledAt = scheduledAt
+		n.LastAttemptAt = nil
+	})
+}
+
+func (r *Repo) DropNotification(_ context.Context, ID uuid.UUID, version int64) error {
+	const op = "repo.memory.DropNotification"
+
+	return r.transition(op, ID, version, domain.Dropped, func(n *domain.Notification) {
+		n.RateLimit = domain.RateLimitDropped
+	})
+}
+
+// transition меняет статус на to, если переход разрешён таблицей переходов, и применяет fn.
+// Ненулевая version - версия, полученная при взятии в отправку: если уведомление с тех
+// пор изменилось (аренда истекла и его взял другой воркер), возвращается repo.ErrNotClaimable.
+func (r *Repo) transition(op string, ID uuid.UUID, version int64, to domain.NotificationStatus, fn func(n *domain.Notification)) error {
+	return r.apply(op, ID, version, to, func(from domain.NotificationStatus) bool {
+		return repo.CanTransition(from, to)
+	}, fn)
+}
+
+// apply меняет статус на to, если allowed разрешает переход из текущего статуса, и применяет fn.
+func (r *Repo) apply(
+	op string,
+	ID uuid.UUID,
+	version int64,
+	to domain.NotificationStatus,
+	allowed func(from domain.NotificationStatus) bool,
+	fn func(n *domain.Notification),
+) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	notification, ok := r.notifications[ID]
+	if !ok {
+		return errutils.Wrap(op, repo.ErrNotifNotFound)
+	}
+
+	if version != 0 && notification.Version != version {
+		return errutils.Wrap(op, repo.ErrNotClaimable)
+	}
+
+	if !allowed(notification.Status) {
+		return errutils.Wrap(op, &repo.TransitionError{From: notification.Status, To: to})
+	}
+
+	fn(&notification)
+	notification.Status = to
+	notification.Version++
+	notification.LeaseUntil = nil
+	notification.UpdatedAt = time.Now().UTC()
+	r.notifications[ID] = notification
+
+	return nil
+}
+
+func (r *Repo) update(op string, ID uuid.UUID, version int64, fn func(n *domain.Notification)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	notification, ok := r.notifications[ID]
+	if !ok {
+		return errutils.Wrap(op, repo.ErrNotifNotFound)
+	}
+
+	if version != 0 && notification.Version != version {
+		return errutils.Wrap(op, repo.ErrNotClaimable)
+	}
+
+	fn(&notification)
+	notification.UpdatedAt = time.Now().UTC()
+	r.notifications[ID] = notification
+
+	return nil
+}
+
+// MarkDigestSent помечает отправленными уведомления дайджеста, которые всё ещё
+// в версии из claims. Перехваченные другим воркером пропускаются.
+func (r *Repo) MarkDigestSent(_ context.Context, digest domain.Digest, claims map[uuid.UUID]int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	for id, version := range claims {
+		notification, ok := r.notifications[id]
+		if !ok || notification.Version != version || !repo.CanTransition(notification.Status, domain.Sent) {
+			continue
+		}
+		digestID := digest.ID
+		notification.Status = domain.Sent
+		notification.Version++
+		notification.LeaseUntil = nil
+		notification.DigestID = &digestID
+		notification.UpdatedAt = now
+		r.notifications[id] = notification
+	}
+
+	return nil
+}
+
+func (r *Repo) GetPendingFollowUps(_ context.Context, parentID uuid.UUID, trigger domain.NotificationStatus) ([]domain.FollowUp, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var pending []domain.FollowUp
+	for _, followUp := range r.followUps[parentID] {
+		if followUp.Trigger == trigger && followUp.NotificationID == nil && !followUp.Canceled {
+			pending = append(pending, followUp)
+		}
+	}
+
+	return pending, nil
+}
+
+func (r *Repo) CreateFollowUpNotification(_ context.Context, followUpID uuid.UUID, notification domain.Notification) (bool, error) {
+	const op = "repo.memory.CreateFollowUpNotification"
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	followUps := r.followUps[*notification.ParentID]
+	for i := range followUps {
+		if followUps[i].ID != followUpID {
+			continue
+		}
+		if followUps[i].NotificationID != nil || followUps[i].Canceled {
+			return false, nil
+		}
+
+		if err := r.insert(notification); err != nil {
+			return false, errutils.Wrap(op, err)
+		}
+		notificationID := notification.ID
+		followUps[i].NotificationID = &notificationID
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// cancelFollowUps отменяет несработавшие follow-up'ы родителя и ещё не отправленные
+// уведомления, уже созданные по ним. Вызывается под mu.
+func (r *Repo) cancelFollowUps(parentID uuid.UUID, cancellation domain.Cancellation) []uuid.UUID {
+	followUps := r.followUps[parentID]
+	for i := range followUps {
+		if followUps[i].NotificationID == nil {
+			followUps[i].Canceled = true
+		}
+	}
+
+	var IDs []uuid.UUID
+	now := time.Now().UTC()
+	for id, notification := range r.notifications {
+		if notification.ParentID != nil && *notification.ParentID == parentID && notification.Status == domain.Scheduled {
+			notification.Status = domain.Canceled
+			notification.Version++
+			notification.Cancellation = &cancellation
+			notification.UpdatedAt = now
+			r.notifications[id] = notification
+			IDs = append(IDs, id)
+		}
+	}
+
+	return IDs
+}
+
+func (r *Repo) RecordAttempt(_ context.Context, ID uuid.UUID) error {
+	const op = "repo.memory.RecordAttempt"
+
+	return r.update(op, ID, 0, func(n *domain.Notification) {
+		now := time.Now().UTC()
+		n.LastAttemptAt = &now
+	})
+}
+
+func (r *Repo) SaveAttempts(_ context.Context, attempts []domain.Attempt) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, a := range attempts {
+		r.attempts[a.NotificationID] = append(r.attempts[a.NotificationID], a)
+	}
+
+	return nil
+}
+
+func (r *Repo) ListAttempts(_ context.Context, ID uuid.UUID) ([]domain.Attempt, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	attempts := append([]domain.Attempt(nil), r.attempts[ID]...)
+	sort.SliceStable(attempts, func(i, j int) bool {
+		return attempts[i].AttemptedAt.Before(attempts[j].AttemptedAt)
+	})
+
+	return attempts, nil
+}
+
+func (r *Repo) FindLost(_ context.Context, olderThan time.Time, limit int) ([]domain.Notification, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var lost []domain.Notification
+	for _, n := range r.notifications {
+		dueAt := n.ScheduledAt
+		if n.NextRetryAt != nil {
+			dueAt = *n.NextRetryAt
+		}
+		switch n.Status {
+		case domain.Scheduled:
+			if !dueAt.Before(olderThan) || n.LastAttemptAt != nil {
+				continue
+			}
+			if n.RepublishedAt != nil && !n.RepublishedAt.Before(olderThan) {
+				continue
+			}
+		case domain.Sending:
+			// Воркер упал, не закончив отправку
+			if n.LeaseUntil == nil || !n.LeaseUntil.Before(olderThan) {
+				continue
+			}
+			if n.RepublishedAt != nil && !n.RepublishedAt.Before(*n.LeaseUntil) {
+				continue
+			}
+		default:
+			continue
+		}
+		if r.inOutbox(n.ID) {
+			continue
+		}
+		lost = append(lost, n)
+	}
+
+	sort.Slice(lost, func(i, j int) bool {
+		return lost[i].ScheduledAt.Before(lost[j].ScheduledAt)
+	})
+	if len(lost) > limit {
+		lost = lost[:limit]
+	}
+
+	return lost, nil
+}
+
+func (r *Repo) MarkRepublished(_ context.Context, ID uuid.UUID) error {
+	const op = "repo.memory.MarkRepublished"
+
+	return r.update(op, ID, 0, func(n *domain.Notification) {
+		now := time.Now().UTC()
+		n.RepublishedAt = &now
+	})
+}
+
+// inOutbox вызывается под r.mu
+func (r *Repo) inOutbox(ID uuid.UUID) bool {
+	for _, entry := range r.outbox {
+		if entry.NotificationID == ID {
+			return true
+		}
+	}
+	return false
+}
+
+// ClaimOutbox забирает до limit незахваченных записей outbox до until.
+func (r *Repo) ClaimOutbox(_ context.Context, limit int, now time.Time, until time.Time) ([]domain.OutboxEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var entries []domain.OutboxEntry
+	for i := range r.outbox {
+		if len(entries) == limit {
+			break
+		}
+		if r.outbox[i].ClaimedUntil.After(now) {
+			continue
+		}
+		r.outbox[i].ClaimedUntil = until
+		entries = append(entries, domain.OutboxEntry{
+			ID:           r.outbox[i].ID,
+			Notification: r.notifications[r.outbox[i].NotificationID],
+		})
+	}
+
+	return entries, nil
+}
+
+func (r *Repo) DeleteOutbox(_ context.Context, IDs []int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.outbox = slices.DeleteFunc(r.outbox, func(entry outboxEntry) bool {
+		return slices.Contains(IDs, entry.ID)
+	})
+
+	return nil
+}
+
+func (r *Repo) FailOutbox(ctx context.Context, ID int64, _ string) error {
+	return r.ReleaseOutbox(ctx, []int64{ID})
+}
+
+func (r *Repo) ReleaseOutbox(_ context.Context, IDs []int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.outbox {
+		if slices.Contains(IDs, r.outbox[i].ID) {
+			r.outbox[i].ClaimedUntil = time.Time{}
+		}
+	}
+
+	return nil
+}
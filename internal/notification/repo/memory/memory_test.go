@@ -0,0 +1,353 @@
+package memory
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/repo"
+	"delayed-notifier/internal/notification/types/domain"
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func newNotification(t *testing.T, r *Repo) domain.Notification {
+	t.Helper()
+
+	notification := domain.Notification{
+		ID:          uuid.New(),
+		Message:     "hello",
+		ScheduledAt: time.Now().Add(-time.Minute),
+		Channel:     domain.Email,
+		Recipient:   "user@example.com",
+	}
+	if err := r.CreateNotification(context.Background(), notification); err != nil {
+		t.Fatalf("CreateNotification: %v", err)
+	}
+
+	return notification
+}
+
+func status(t *testing.T, r *Repo, ID uuid.UUID) domain.NotificationStatus {
+	t.Helper()
+
+	s, err := r.GetStatusByID(context.Background(), ID)
+	if err != nil {
+		t.Fatalf("GetStatusByID: %v", err)
+	}
+
+	return s
+}
+
+func TestClaimForSending(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	t.Run("only one worker claims a scheduled notification", func(t *testing.T) {
+		r := New()
+		n := newNotification(t, r)
+
+		if _, err := r.ClaimForSending(ctx, n.ID, now, now.Add(time.Minute)); err != nil {
+			t.Fatalf("first claim: %v", err)
+		}
+		if _, err := r.ClaimForSending(ctx, n.ID, now, now.Add(time.Minute)); !errors.Is(err, repo.ErrNotClaimable) {
+			t.Fatalf("second claim: got %v, want ErrNotClaimable", err)
+		}
+		if got := status(t, r, n.ID); got != domain.Sending {
+			t.Fatalf("status: got %s, want %s", got, domain.Sending)
+		}
+	})
+
+	t.Run("expired lease can be claimed again", func(t *testing.T) {
+		r := New()
+		n := newNotification(t, r)
+
+		if _, err := r.ClaimForSending(ctx, n.ID, now, now.Add(time.Minute)); err != nil {
+			t.Fatalf("first claim: %v", err)
+		}
+		later := now.Add(2 * time.Minute)
+		if _, err := r.ClaimForSending(ctx, n.ID, later, later.Add(time.Minute)); err != nil {
+			t.Fatalf("claim after lease expiry: %v", err)
+		}
+	})
+
+	t.Run("terminal and canceled notifications are not claimable", func(t *testing.T) {
+		for _, final := range []domain.NotificationStatus{domain.Sent, domain.Failed, domain.Dropped} {
+			r := New()
+			n := newNotification(t, r)
+
+			version, err := r.ClaimForSending(ctx, n.ID, now, now.Add(time.Minute))
+			if err != nil {
+				t.Fatalf("claim: %v", err)
+			}
+			if err := r.UpdateStatus(ctx, n.ID, version, final); err != nil {
+				t.Fatalf("UpdateStatus(%s): %v", final, err)
+			}
+			if _, err := r.ClaimForSending(ctx, n.ID, now, now.Add(time.Minute)); !errors.Is(err, repo.ErrNotClaimable) {
+				t.Fatalf("claim after %s: got %v, want ErrNotClaimable", final, err)
+			}
+		}
+
+		r := New()
+		n := newNotification(t, r)
+		if _, err := r.CancelNotification(ctx, n.ID, domain.Cancellation{By: "test"}, domain.Cancellation{}, false); err != nil {
+			t.Fatalf("CancelNotification: %v", err)
+		}
+		if _, err := r.ClaimForSending(ctx, n.ID, now, now.Add(time.Minute)); !errors.Is(err, repo.ErrNotClaimable) {
+			t.Fatalf("claim after cancel: got %v, want ErrNotClaimable", err)
+		}
+	})
+
+	t.Run("rescheduled notification is not claimed early", func(t *testing.T) {
+		r := New()
+		n := newNotification(t, r)
+
+		version, err := r.ClaimForSending(ctx, n.ID, now, now.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+		later := now.Add(time.Hour)
+		if err := r.Reschedule(ctx, n.ID, version, later); err != nil {
+			t.Fatalf("Reschedule: %v", err)
+		}
+
+		if _, err := r.ClaimForSending(ctx, n.ID, now, now.Add(time.Minute)); !errors.Is(err, repo.ErrNotDue) {
+			t.Fatalf("claim before new time: got %v, want ErrNotDue", err)
+		}
+		if _, err := r.ClaimForSending(ctx, n.ID, later, later.Add(time.Minute)); err != nil {
+			t.Fatalf("claim at new time: %v", err)
+		}
+	})
+
+	t.Run("unknown notification", func(t *testing.T) {
+		r := New()
+
+		if _, err := r.ClaimForSending(ctx, uuid.New(), now, now.Add(time.Minute)); !errors.Is(err, repo.ErrNotifNotFound) {
+			t.Fatalf("got %v, want ErrNotifNotFound", err)
+		}
+	})
+}
+
+func TestStaleClaimCannotWrite(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	r := New()
+	n := newNotification(t, r)
+
+	stale, err := r.ClaimForSending(ctx, n.ID, now, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("first claim: %v", err)
+	}
+
+	// Аренда первого воркера истекла, уведомление взял второй
+	later := now.Add(2 * time.Minute)
+	current, err := r.ClaimForSending(ctx, n.ID, later, later.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("second claim: %v", err)
+	}
+
+	if err := r.UpdateStatus(ctx, n.ID, stale, domain.Sent); !errors.Is(err, repo.ErrNotClaimable) {
+		t.Fatalf("UpdateStatus with stale claim: got %v, want ErrNotClaimable", err)
+	}
+	if err := r.Reschedule(ctx, n.ID, stale, later); !errors.Is(err, repo.ErrNotClaimable) {
+		t.Fatalf("Reschedule with stale claim: got %v, want ErrNotClaimable", err)
+	}
+	if err := r.RecordSendFailure(ctx, n.ID, stale, 1, nil, domain.SendFailure{Error: "failed"}); !errors.Is(err, repo.ErrNotClaimable) {
+		t.Fatalf("RecordSendFailure with stale claim: got %v, want ErrNotClaimable", err)
+	}
+	if got := status(t, r, n.ID); got != domain.Sending {
+		t.Fatalf("status: got %s, want %s", got, domain.Sending)
+	}
+
+	if err := r.UpdateStatus(ctx, n.ID, current, domain.Sent); err != nil {
+		t.Fatalf("UpdateStatus with current claim: %v", err)
+	}
+}
+
+func TestUpdateStatusRejectsInvalidTransition(t *testing.T) {
+	ctx := context.Background()
+	r := New()
+	n := newNotification(t, r)
+
+	err := r.UpdateStatus(ctx, n.ID, 0, domain.Sent)
+
+	var transitionErr *repo.TransitionError
+	if !errors.As(err, &transitionErr) {
+		t.Fatalf("got %v, want TransitionError", err)
+	}
+	if transitionErr.From != domain.Scheduled {
+		t.Fatalf("From: got %s, want %s", transitionErr.From, domain.Scheduled)
+	}
+}
+
+func TestOnlyReviveReturnsFinishedNotification(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	r := New()
+	n := newNotification(t, r)
+
+	version, err := r.ClaimForSending(ctx, n.ID, now, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if err := r.UpdateStatus(ctx, n.ID, version, domain.Failed); err != nil {
+		t.Fatalf("UpdateStatus: %v", err)
+	}
+
+	if err := r.Reschedule(ctx, n.ID, 0, now); !errors.Is(err, repo.ErrInvalidTransition) {
+		t.Fatalf("Reschedule: got %v, want ErrInvalidTransition", err)
+	}
+	if err := r.UpdateStatus(ctx, n.ID, 0, domain.Scheduled); !errors.Is(err, repo.ErrInvalidTransition) {
+		t.Fatalf("UpdateStatus: got %v, want ErrInvalidTransition", err)
+	}
+
+	if err := r.ReviveNotification(ctx, n.ID, now); err != nil {
+		t.Fatalf("ReviveNotification: %v", err)
+	}
+	if got := status(t, r, n.ID); got != domain.Scheduled {
+		t.Fatalf("status: got %s, want %s", got, domain.Scheduled)
+	}
+
+	// Отправленное уведомление не переопубликовывается
+	version, err = r.ClaimForSending(ctx, n.ID, now, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if err := r.UpdateStatus(ctx, n.ID, version, domain.Sent); err != nil {
+		t.Fatalf("UpdateStatus: %v", err)
+	}
+	if err := r.ReviveNotification(ctx, n.ID, now); !errors.Is(err, repo.ErrInvalidTransition) {
+		t.Fatalf("ReviveNotification after sent: got %v, want ErrInvalidTransition", err)
+	}
+}
+
+func TestCancelNotificationCascadesToFollowUps(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	create := func(t *testing.T, r *Repo) (domain.Notification, uuid.UUID) {
+		t.Helper()
+
+		parent := domain.Notification{
+			ID:          uuid.New(),
+			Message:     "parent",
+			ScheduledAt: now,
+			Channel:     domain.Email,
+			Recipient:   "user@example.com",
+			FollowUps: []domain.FollowUp{
+				{ID: uuid.New(), Trigger: domain.Sent, Delay: time.Hour, Message: "sent"},
+				{ID: uuid.New(), Trigger: domain.Failed, Delay: time.Hour, Message: "failed"},
+			},
+		}
+		if err := r.CreateNotification(ctx, parent); err != nil {
+			t.Fatalf("CreateNotification: %v", err)
+		}
+
+		// Первый follow-up уже сработал и создал уведомление
+		parentID := parent.ID
+		child := domain.Notification{ID: uuid.New(), Message: "sent", ScheduledAt: now, ParentID: &parentID}
+		created, err := r.CreateFollowUpNotification(ctx, parent.FollowUps[0].ID, child)
+		if err != nil || !created {
+			t.Fatalf("CreateFollowUpNotification: %v, created %v", err, created)
+		}
+
+		return parent, child.ID
+	}
+
+	t.Run("scheduled parent", func(t *testing.T) {
+		r := New()
+		parent, childID := create(t, r)
+
+		canceled, err := r.CancelNotification(ctx, parent.ID, domain.Cancellation{By: "test"}, domain.Cancellation{By: "parent"}, false)
+		if err != nil {
+			t.Fatalf("CancelNotification: %v", err)
+		}
+
+		if len(canceled) != 1 || canceled[0] != childID {
+			t.Fatalf("canceled: got %v, want [%s]", canceled, childID)
+		}
+		if got := status(t, r, parent.ID); got != domain.Canceled {
+			t.Fatalf("parent status: got %s", got)
+		}
+		if got := status(t, r, childID); got != domain.Canceled {
+			t.Fatalf("child status: got %s", got)
+		}
+		if pending, _ := r.GetPendingFollowUps(ctx, parent.ID, domain.Failed); len(pending) != 0 {
+			t.Fatalf("pending follow-ups: got %d, want 0", len(pending))
+		}
+	})
+
+	sent := func(t *testing.T, r *Repo, ID uuid.UUID) {
+		t.Helper()
+
+		version, err := r.ClaimForSending(ctx, ID, now, now.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+		if err := r.UpdateStatus(ctx, ID, version, domain.Sent); err != nil {
+			t.Fatalf("UpdateStatus: %v", err)
+		}
+	}
+
+	t.Run("parent already sent", func(t *testing.T) {
+		r := New()
+		parent, childID := create(t, r)
+		sent(t, r, parent.ID)
+
+		canceled, err := r.CancelNotification(ctx, parent.ID, domain.Cancellation{By: "test"}, domain.Cancellation{By: "parent"}, false)
+		if !errors.Is(err, repo.ErrInvalidTransition) {
+			t.Fatalf("got %v, want ErrInvalidTransition", err)
+		}
+
+		// Без явного запроса отказ ничего не меняет
+		if len(canceled) != 0 {
+			t.Fatalf("canceled: got %v, want none", canceled)
+		}
+		if got := status(t, r, childID); got != domain.Scheduled {
+			t.Fatalf("child status: got %s, want %s", got, domain.Scheduled)
+		}
+		if pending, _ := r.GetPendingFollowUps(ctx, parent.ID, domain.Failed); len(pending) != 1 {
+			t.Fatalf("pending follow-ups: got %d, want 1", len(pending))
+		}
+	})
+
+	t.Run("follow-ups of sent parent", func(t *testing.T) {
+		r := New()
+		parent, childID := create(t, r)
+		sent(t, r, parent.ID)
+
+		canceled, err := r.CancelNotification(ctx, parent.ID, domain.Cancellation{By: "test"}, domain.Cancellation{By: "parent"}, true)
+		if !errors.Is(err, repo.ErrInvalidTransition) {
+			t.Fatalf("got %v, want ErrInvalidTransition", err)
+		}
+
+		if len(canceled) != 1 || canceled[0] != childID {
+			t.Fatalf("canceled: got %v, want [%s]", canceled, childID)
+		}
+		if got := status(t, r, parent.ID); got != domain.Sent {
+			t.Fatalf("parent status: got %s, want %s", got, domain.Sent)
+		}
+		if pending, _ := r.GetPendingFollowUps(ctx, parent.ID, domain.Failed); len(pending) != 0 {
+			t.Fatalf("pending follow-ups: got %d, want 0", len(pending))
+		}
+	})
+
+	t.Run("follow-ups of sending parent", func(t *testing.T) {
+		r := New()
+		parent, childID := create(t, r)
+		if _, err := r.ClaimForSending(ctx, parent.ID, now, now.Add(time.Minute)); err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+
+		// Исход отправки ещё не известен: follow-up'ы не трогаем и по запросу
+		canceled, err := r.CancelNotification(ctx, parent.ID, domain.Cancellation{By: "test"}, domain.Cancellation{By: "parent"}, true)
+		if !errors.Is(err, repo.ErrInvalidTransition) {
+			t.Fatalf("got %v, want ErrInvalidTransition", err)
+		}
+		if len(canceled) != 0 {
+			t.Fatalf("canceled: got %v, want none", canceled)
+		}
+		if got := status(t, r, childID); got != domain.Scheduled {
+			t.Fatalf("child status: got %s, want %s", got, domain.Scheduled)
+		}
+	})
+}
@@ -8,7 +8,9 @@ import (
 	"delayed-notifier/pkg/errutils"
 	"errors"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/wb-go/wbf/dbpg"
+	"time"
 )
 
 type Repo struct {
@@ -22,11 +24,104 @@ func New(db *dbpg.DB) *Repo {
 func (r *Repo) CreateNotification(ctx context.Context, notification domain.Notification) error {
 	const op = "repo.notification.Create"
 
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return errutils.Wrap(op, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := insertNotification(ctx, tx, notification); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// CreateNotificationIdempotent в одной транзакции занимает ключ идемпотентности
+// и создаёт уведомление. Если ключ уже занят (и не старше ttl), уведомление
+// не создаётся и возвращается сохранённая под ключом запись, created = false.
+// Уникальный ключ таблицы сериализует конкурентные запросы с разных инстансов.
+func (r *Repo) CreateNotificationIdempotent(
+	ctx context.Context,
+	key domain.IdempotencyKey,
+	notification domain.Notification,
+	ttl time.Duration,
+) (domain.IdempotencyKey, bool, error) {
+	const op = "repo.notification.CreateNotificationIdempotent"
+
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return domain.IdempotencyKey{}, false, errutils.Wrap(op, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Ключи старше окна хранения можно использовать заново
+	deleteQuery := `DELETE FROM idempotency_key WHERE key = $1 AND created_at < NOW() - $2 * INTERVAL '1 millisecond'`
+	if _, err := tx.ExecContext(ctx, deleteQuery, key.Key, ttl.Milliseconds()); err != nil {
+		return domain.IdempotencyKey{}, false, errutils.Wrap(op, err)
+	}
+
+	insertQuery := `
+    INSERT INTO idempotency_key(key, request_hash, notification_id)
+    VALUES ($1, $2, $3)
+    ON CONFLICT (key) DO NOTHING`
+
+	res, err := tx.ExecContext(ctx, insertQuery, key.Key, key.RequestHash, key.NotificationID)
+	if err != nil {
+		return domain.IdempotencyKey{}, false, errutils.Wrap(op, err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return domain.IdempotencyKey{}, false, errutils.Wrap(op, err)
+	}
+
+	if rows == 0 {
+		selectQuery := `
+    SELECT key, request_hash, notification_id, created_at
+    FROM idempotency_key
+    WHERE key = $1`
+
+		var stored domain.IdempotencyKey
+		if err := tx.QueryRowContext(ctx, selectQuery, key.Key).Scan(
+			&stored.Key,
+			&stored.RequestHash,
+			&stored.NotificationID,
+			&stored.CreatedAt,
+		); err != nil {
+			return domain.IdempotencyKey{}, false, errutils.Wrap(op, err)
+		}
+
+		return stored, false, nil
+	}
+
+	if err := insertNotification(ctx, tx, notification); err != nil {
+		return domain.IdempotencyKey{}, false, errutils.Wrap(op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.IdempotencyKey{}, false, errutils.Wrap(op, err)
+	}
+
+	return key, true, nil
+}
+
+// insertNotification вставляет уведомление вместе с описаниями его follow-up'ов
+// и записью в outbox, по которой relay опубликует уведомление в брокер.
+func insertNotification(ctx context.Context, tx *sql.Tx, notification domain.Notification) error {
 	query := `
-    INSERT INTO notification(id, message, scheduled_at, channel, recipient)
-    VALUES ($1, $2, $3, $4, $5)`
+    INSERT INTO notification(id, message, scheduled_at, channel, recipient, expires_at, digest, priority, parent_id)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
 
-	if _, err := r.db.ExecContext(
+	if _, err := tx.ExecContext(
 		ctx,
 		query,
 		notification.ID,
@@ -34,8 +129,37 @@ func (r *Repo) CreateNotification(ctx context.Context, notification domain.Notif
 		notification.ScheduledAt,
 		notification.Channel,
 		notification.Recipient,
+		notification.ExpiresAt,
+		notification.Digest,
+		notification.Priority,
+		notification.ParentID,
 	); err != nil {
-		return errutils.Wrap(op, err)
+		return err
+	}
+
+	followUpQuery := `
+    INSERT INTO notification_follow_up(id, parent_id, trigger, delay_ms, message, channel, recipient)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	for _, followUp := range notification.FollowUps {
+		if _, err := tx.ExecContext(
+			ctx,
+			followUpQuery,
+			followUp.ID,
+			notification.ID,
+			followUp.Trigger,
+			followUp.Delay.Milliseconds(),
+			followUp.Message,
+			followUp.Channel,
+			followUp.Recipient,
+		); err != nil {
+			return err
+		}
+	}
+
+	outboxQuery := `INSERT INTO outbox(notification_id) VALUES ($1)`
+	if _, err := tx.ExecContext(ctx, outboxQuery, notification.ID); err != nil {
+		return err
 	}
 
 	return nil
@@ -57,12 +181,353 @@ func (r *Repo) GetStatusByID(ctx context.Context, ID uuid.UUID) (domain.Notifica
 	return status, nil
 }
 
-func (r *Repo) UpdateStatus(ctx context.Context, ID uuid.UUID, status domain.NotificationStatus) error {
+func (r *Repo) UpdateStatus(ctx context.Context, ID uuid.UUID, version int64, status domain.NotificationStatus) error {
 	const op = "repo.notification.UpdateStatus"
 
-	query := `UPDATE notification SET status = $1 WHERE id = $2`
+	return r.transition(ctx, op, ID, version, status, "")
+}
+
+// CancelNotification в одной транзакции отменяет уведомление в статусе scheduled
+// и его follow-up'ы с отметкой followUpCancellation. Если уведомление отменить
+// нельзя, возвращается repo.TransitionError с его текущим статусом и ничего не меняется.
+// Исключение - завершённое уведомление при followUpsOfFinished: его follow-up'ы
+// отменяются, и их ID возвращаются вместе с repo.TransitionError.
+func (r *Repo) CancelNotification(
+	ctx context.Context,
+	ID uuid.UUID,
+	cancellation domain.Cancellation,
+	followUpCancellation domain.Cancellation,
+	followUpsOfFinished bool,
+) ([]uuid.UUID, error) {
+	const op = "repo.notification.CancelNotification"
+
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Строка родителя блокируется: статус не изменится между проверкой и отменой
+	var status domain.NotificationStatus
+	lockQuery := `SELECT status FROM notification WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, lockQuery, ID).Scan(&status); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errutils.Wrap(op, repo.ErrNotifNotFound)
+		}
+		return nil, errutils.Wrap(op, err)
+	}
+
+	var transitionErr error
+	if repo.CanTransition(status, domain.Canceled) {
+		cancelQuery := `
+        UPDATE notification
+        SET status = 'canceled', version = version + 1, lease_until = NULL, canceled_at = $2,
+            canceled_by = $3, cancel_reason = NULLIF($4, ''), updated_at = NOW()
+        WHERE id = $1`
+
+		if _, err := tx.ExecContext(ctx, cancelQuery, ID, cancellation.At, cancellation.By, cancellation.Reason); err != nil {
+			return nil, errutils.Wrap(op, err)
+		}
+	} else {
+		transitionErr = &repo.TransitionError{From: status, To: domain.Canceled}
+		if !followUpsOfFinished || !repo.IsFinal(status) {
+			return nil, errutils.Wrap(op, transitionErr)
+		}
+	}
+
+	IDs, err := cancelFollowUps(ctx, tx, ID, followUpCancellation)
+	if err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+
+	if transitionErr != nil {
+		return IDs, errutils.Wrap(op, transitionErr)
+	}
+
+	return IDs, nil
+}
+
+// ClaimForSending переводит уведомление в статус sending до leaseUntil. Забрать
+// можно уведомление в статусе scheduled или sending с истёкшей арендой (воркер
+// упал во время отправки). Обновление условное по версии, поэтому из нескольких
+// воркеров с одним уведомлением его получает только один, остальным - ErrNotClaimable.
+// Возвращает новую версию: ею обусловлены все записи взявшего воркера.
+// Уведомление, перенесённое на время позже now, не берётся: repo.ErrNotDue.
+func (r *Repo) ClaimForSending(ctx context.Context, ID uuid.UUID, now time.Time, leaseUntil time.Time) (int64, error) {
+	const op = "repo.notification.ClaimForSending"
 
-	res, err := r.db.ExecContext(ctx, query, status, ID)
+	selectQuery := `SELECT status, version, lease_until, scheduled_at <= $2 FROM notification WHERE id = $1`
+
+	var (
+		status  domain.NotificationStatus
+		version int64
+		lease   *time.Time
+		due     bool
+	)
+	if err := r.db.QueryRowContext(ctx, selectQuery, ID, now).Scan(&status, &version, &lease, &due); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errutils.Wrap(op, repo.ErrNotifNotFound)
+		}
+		return 0, errutils.Wrap(op, err)
+	}
+
+	leaseExpired := status == domain.Sending && (lease == nil || lease.Before(now))
+	if status != domain.Scheduled && !leaseExpired {
+		return 0, errutils.Wrap(op, repo.ErrNotClaimable)
+	}
+	if !due {
+		return 0, errutils.Wrap(op, repo.ErrNotDue)
+	}
+
+	updateQuery := `
+    UPDATE notification
+    SET status = 'sending', version = version + 1, lease_until = $1, updated_at = NOW()
+    WHERE id = $2 AND status = $3 AND version = $4 AND scheduled_at <= $5`
+
+	res, err := r.db.ExecContext(ctx, updateQuery, leaseUntil, ID, status, version, now)
+	if err != nil {
+		return 0, errutils.Wrap(op, err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, errutils.Wrap(op, err)
+	}
+
+	if rows == 0 {
+		// Другой воркер успел раньше
+		return 0, errutils.Wrap(op, repo.ErrNotClaimable)
+	}
+
+	return version + 1, nil
+}
+
+// transition меняет статус на to, если переход разрешён таблицей переходов.
+// Ненулевая version - версия, полученная при взятии в отправку: если уведомление с тех
+// пор изменилось (аренда истекла и его взял другой воркер), возвращается repo.ErrNotClaimable.
+// set - дополнительные присваивания, их аргументы нумеруются с $5.
+func (r *Repo) transition(
+	ctx context.Context,
+	op string,
+	ID uuid.UUID,
+	version int64,
+	to domain.NotificationStatus,
+	set string,
+	args ...interface{},
+) error {
+	return r.transitionFrom(ctx, op, ID, version, repo.SourcesOf(to), to, set, args...)
+}
+
+// transitionFrom меняет статус на to, если текущий статус входит в sources.
+func (r *Repo) transitionFrom(
+	ctx context.Context,
+	op string,
+	ID uuid.UUID,
+	version int64,
+	sources []string,
+	to domain.NotificationStatus,
+	set string,
+	args ...interface{},
+) error {
+	query := `
+    UPDATE notification
+    SET status = $1, version = version + 1, lease_until = NULL, updated_at = NOW()` + set + `
+    WHERE id = $2 AND status::text = ANY($3) AND ($4::bigint = 0 OR version = $4)`
+
+	args = append([]interface{}{to, ID, pq.Array(sources), version}, args...)
+
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	if rows > 0 {
+		return nil
+	}
+
+	if version != 0 {
+		return errutils.Wrap(op, repo.ErrNotClaimable)
+	}
+
+	from, err := r.GetStatusByID(ctx, ID)
+	if err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	return errutils.Wrap(op, &repo.TransitionError{From: from, To: to})
+}
+
+func (r *Repo) GetByID(ctx context.Context, ID uuid.UUID) (domain.Notification, error) {
+	const op = "repo.notification.GetByID"
+
+	query := `
+    SELECT id, message, scheduled_at, channel, recipient, priority, status, expires_at,
+           COALESCE(rate_limit::text, ''), deferred_until, digest, digest_id, parent_id,
+           last_attempt_at, republished_at, retries, next_retry_at,
+           last_error, COALESCE(error_class, ''), COALESCE(provider_response, ''),
+           version, lease_until, canceled_at, COALESCE(canceled_by, ''), COALESCE(cancel_reason, ''),
+           created_at, updated_at
+    FROM notification
+    WHERE id = $1`
+
+	var (
+		n            domain.Notification
+		lastError    sql.NullString
+		failure      domain.SendFailure
+		canceledAt   *time.Time
+		cancellation domain.Cancellation
+	)
+	if err := r.db.QueryRowContext(ctx, query, ID).Scan(
+		&n.ID,
+		&n.Message,
+		&n.ScheduledAt,
+		&n.Channel,
+		&n.Recipient,
+		&n.Priority,
+		&n.Status,
+		&n.ExpiresAt,
+		&n.RateLimit,
+		&n.DeferredUntil,
+		&n.Digest,
+		&n.DigestID,
+		&n.ParentID,
+		&n.LastAttemptAt,
+		&n.RepublishedAt,
+		&n.Retries,
+		&n.NextRetryAt,
+		&lastError,
+		&failure.Class,
+		&failure.Response,
+		&n.Version,
+		&n.LeaseUntil,
+		&canceledAt,
+		&cancellation.By,
+		&cancellation.Reason,
+		&n.CreatedAt,
+		&n.UpdatedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Notification{}, errutils.Wrap(op, repo.ErrNotifNotFound)
+		}
+		return domain.Notification{}, errutils.Wrap(op, err)
+	}
+
+	if lastError.Valid {
+		failure.Error = lastError.String
+		n.LastFailure = &failure
+	}
+
+	if canceledAt != nil {
+		cancellation.At = *canceledAt
+		n.Cancellation = &cancellation
+	}
+
+	return n, nil
+}
+
+func (r *Repo) DeferNotification(ctx context.Context, ID uuid.UUID, version int64, until time.Time) error {
+	const op = "repo.notification.DeferNotification"
+
+	set := `, scheduled_at = $5, deferred_until = $5, rate_limit = 'deferred', last_attempt_at = NULL`
+
+	return r.transition(ctx, op, ID, version, domain.Scheduled, set, until)
+}
+
+// RecordSendFailure записывает неудачную попытку отправки. nextRetryAt - время
+// следующей попытки, уведомление возвращается в scheduled; nil - попыток больше
+// не будет, статус выставляет вызывающий.
+func (r *Repo) RecordSendFailure(
+	ctx context.Context,
+	ID uuid.UUID,
+	version int64,
+	retries int,
+	nextRetryAt *time.Time,
+	failure domain.SendFailure,
+) error {
+	const op = "repo.notification.RecordSendFailure"
+
+	if nextRetryAt != nil {
+		set := `, retries = $5, next_retry_at = $6, last_error = $7, error_class = $8, provider_response = $9,
+        last_attempt_at = NULL`
+
+		return r.transition(
+			ctx,
+			op,
+			ID,
+			version,
+			domain.Scheduled,
+			set,
+			retries,
+			nextRetryAt,
+			failure.Error,
+			failure.Class,
+			failure.Response,
+		)
+	}
+
+	query := `
+    UPDATE notification
+    SET retries = $1, next_retry_at = $2, last_error = $3, error_class = $4, provider_response = $5,
+        last_attempt_at = NULL, updated_at = NOW()
+    WHERE id = $6 AND ($7::bigint = 0 OR version = $7)`
+
+	err := r.execAffectingOne(
+		ctx,
+		op,
+		query,
+		retries,
+		nextRetryAt,
+		failure.Error,
+		failure.Class,
+		failure.Response,
+		ID,
+		version,
+	)
+	if version != 0 && errors.Is(err, repo.ErrNotifNotFound) {
+		return errutils.Wrap(op, repo.ErrNotClaimable)
+	}
+
+	return err
+}
+
+// Reschedule возвращает уведомление в статус scheduled с новым временем отправки.
+func (r *Repo) Reschedule(ctx context.Context, ID uuid.UUID, version int64, scheduledAt time.Time) error {
+	const op = "repo.notification.Reschedule"
+
+	set := `, scheduled_at = $5, last_attempt_at = NULL`
+
+	return r.transition(ctx, op, ID, version, domain.Scheduled, set, scheduledAt)
+}
+
+// ReviveNotification возвращает переопубликованное из DLQ уведомление в scheduled,
+// в том числе из конечных статусов failed, expired и dropped.
+func (r *Repo) ReviveNotification(ctx context.Context, ID uuid.UUID, scheduledAt time.Time) error {
+	const op = "repo.notification.ReviveNotification"
+
+	set := `, scheduled_at = $5, last_attempt_at = NULL`
+
+	return r.transitionFrom(ctx, op, ID, 0, repo.RevivableSources(), domain.Scheduled, set, scheduledAt)
+}
+
+func (r *Repo) DropNotification(ctx context.Context, ID uuid.UUID, version int64) error {
+	const op = "repo.notification.DropNotification"
+
+	return r.transition(ctx, op, ID, version, domain.Dropped, `, rate_limit = 'dropped'`)
+}
+
+func (r *Repo) execAffectingOne(ctx context.Context, op string, query string, args ...interface{}) error {
+	res, err := r.db.ExecContext(ctx, query, args...)
 	if err != nil {
 		return errutils.Wrap(op, err)
 	}
@@ -78,3 +543,462 @@ func (r *Repo) UpdateStatus(ctx context.Context, ID uuid.UUID, status domain.Not
 
 	return nil
 }
+
+// MarkDigestSent сохраняет дайджест и помечает отправленными его уведомления, которые
+// всё ещё в версии из claims. Перехваченные другим воркером пропускаются.
+func (r *Repo) MarkDigestSent(ctx context.Context, digest domain.Digest, claims map[uuid.UUID]int64) error {
+	const op = "repo.notification.MarkDigestSent"
+
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return errutils.Wrap(op, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	insertQuery := `
+    INSERT INTO digest(id, channel, recipient, message)
+    VALUES ($1, $2, $3, $4)
+    ON CONFLICT (id) DO NOTHING`
+
+	if _, err := tx.ExecContext(ctx, insertQuery, digest.ID, digest.Channel, digest.Recipient, digest.Message); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	IDs := make([]uuid.UUID, 0, len(claims))
+	versions := make([]int64, 0, len(claims))
+	for id, version := range claims {
+		IDs = append(IDs, id)
+		versions = append(versions, version)
+	}
+
+	updateQuery := `
+    UPDATE notification n
+    SET status = 'sent', digest_id = $1, version = n.version + 1, lease_until = NULL, updated_at = NOW()
+    FROM unnest($2::uuid[], $3::bigint[]) AS c(id, version)
+    WHERE n.id = c.id AND n.version = c.version AND n.status::text = ANY($4)`
+
+	if _, err := tx.ExecContext(
+		ctx,
+		updateQuery,
+		digest.ID,
+		pq.Array(IDs),
+		pq.Array(versions),
+		pq.Array(repo.SourcesOf(domain.Sent)),
+	); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	return nil
+}
+
+func (r *Repo) GetPendingFollowUps(ctx context.Context, parentID uuid.UUID, trigger domain.NotificationStatus) ([]domain.FollowUp, error) {
+	const op = "repo.notification.GetPendingFollowUps"
+
+	query := `
+    SELECT id, parent_id, trigger, delay_ms, message, channel, recipient
+    FROM notification_follow_up
+    WHERE parent_id = $1 AND trigger = $2 AND notification_id IS NULL AND NOT canceled`
+
+	rows, err := r.db.QueryContext(ctx, query, parentID, trigger)
+	if err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var followUps []domain.FollowUp
+	for rows.Next() {
+		var (
+			f       domain.FollowUp
+			delayMs int64
+		)
+		if err := rows.Scan(&f.ID, &f.ParentID, &f.Trigger, &delayMs, &f.Message, &f.Channel, &f.Recipient); err != nil {
+			return nil, errutils.Wrap(op, err)
+		}
+		f.Delay = time.Duration(delayMs) * time.Millisecond
+		followUps = append(followUps, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+
+	return followUps, nil
+}
+
+// CreateFollowUpNotification создаёт уведомление для сработавшего follow-up'а.
+// Возвращает false, если follow-up уже сработал или отменён.
+func (r *Repo) CreateFollowUpNotification(ctx context.Context, followUpID uuid.UUID, notification domain.Notification) (bool, error) {
+	const op = "repo.notification.CreateFollowUpNotification"
+
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return false, errutils.Wrap(op, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := insertNotification(ctx, tx, notification); err != nil {
+		return false, errutils.Wrap(op, err)
+	}
+
+	query := `
+    UPDATE notification_follow_up
+    SET notification_id = $1
+    WHERE id = $2 AND notification_id IS NULL AND NOT canceled`
+
+	res, err := tx.ExecContext(ctx, query, notification.ID, followUpID)
+	if err != nil {
+		return false, errutils.Wrap(op, err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, errutils.Wrap(op, err)
+	}
+
+	if rows == 0 {
+		return false, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, errutils.Wrap(op, err)
+	}
+
+	return true, nil
+}
+
+// cancelFollowUps отменяет несработавшие follow-up'ы родителя и ещё не отправленные
+// уведомления, уже созданные по ним. Возвращает ID отменённых уведомлений.
+func cancelFollowUps(ctx context.Context, tx *sql.Tx, parentID uuid.UUID, cancellation domain.Cancellation) ([]uuid.UUID, error) {
+	followUpQuery := `
+    UPDATE notification_follow_up
+    SET canceled = TRUE
+    WHERE parent_id = $1 AND notification_id IS NULL`
+
+	if _, err := tx.ExecContext(ctx, followUpQuery, parentID); err != nil {
+		return nil, err
+	}
+
+	notificationQuery := `
+    UPDATE notification
+    SET status = 'canceled', version = version + 1, canceled_at = $2, canceled_by = $3,
+        cancel_reason = NULLIF($4, ''), updated_at = NOW()
+    WHERE parent_id = $1 AND status = 'scheduled'
+    RETURNING id`
+
+	rows, err := tx.QueryContext(
+		ctx,
+		notificationQuery,
+		parentID,
+		cancellation.At,
+		cancellation.By,
+		cancellation.Reason,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var IDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		IDs = append(IDs, id)
+	}
+	_ = rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return IDs, nil
+}
+
+func (r *Repo) RecordAttempt(ctx context.Context, ID uuid.UUID) error {
+	const op = "repo.notification.RecordAttempt"
+
+	query := `UPDATE notification SET last_attempt_at = NOW() WHERE id = $1`
+
+	return r.execAffectingOne(ctx, op, query, ID)
+}
+
+// SaveAttempts записывает попытки доставки. Попытка дайджеста - одна строка
+// на каждое вошедшее в него уведомление.
+func (r *Repo) SaveAttempts(ctx context.Context, attempts []domain.Attempt) error {
+	const op = "repo.notification.SaveAttempts"
+
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return errutils.Wrap(op, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	query := `
+    INSERT INTO notification_attempt(
+        notification_id, attempted_at, channel, recipient, duration_ms, outcome,
+        error_class, error, provider_response, digest)
+    VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), $10)`
+
+	for _, a := range attempts {
+		if _, err := tx.ExecContext(
+			ctx,
+			query,
+			a.NotificationID,
+			a.AttemptedAt,
+			a.Channel,
+			a.Recipient,
+			a.Duration.Milliseconds(),
+			a.Outcome,
+			a.ErrorClass,
+			a.Error,
+			a.ProviderResponse,
+			a.Digest,
+		); err != nil {
+			return errutils.Wrap(op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// ListAttempts возвращает попытки доставки уведомления от ранних к поздним.
+func (r *Repo) ListAttempts(ctx context.Context, ID uuid.UUID) ([]domain.Attempt, error) {
+	const op = "repo.notification.ListAttempts"
+
+	query := `
+    SELECT notification_id, attempted_at, channel, recipient, duration_ms, outcome,
+           COALESCE(error_class, ''), COALESCE(error, ''), COALESCE(provider_response, ''), digest
+    FROM notification_attempt
+    WHERE notification_id = $1
+    ORDER BY attempted_at, id`
+
+	rows, err := r.db.QueryContext(ctx, query, ID)
+	if err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var attempts []domain.Attempt
+	for rows.Next() {
+		var (
+			a          domain.Attempt
+			durationMs int64
+		)
+		if err := rows.Scan(
+			&a.NotificationID,
+			&a.AttemptedAt,
+			&a.Channel,
+			&a.Recipient,
+			&durationMs,
+			&a.Outcome,
+			&a.ErrorClass,
+			&a.Error,
+			&a.ProviderResponse,
+			&a.Digest,
+		); err != nil {
+			return nil, errutils.Wrap(op, err)
+		}
+		a.Duration = time.Duration(durationMs) * time.Millisecond
+		attempts = append(attempts, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+
+	return attempts, nil
+}
+
+// FindLost возвращает уведомления, застрявшие в статусе scheduled: время отправки
+// прошло раньше olderThan, воркер их не брал, и сверка не переопубликовывала
+// их позже olderThan. Сюда же попадают уведомления в статусе sending, аренда
+// которых истекла раньше olderThan: воркер упал, не закончив отправку.
+func (r *Repo) FindLost(ctx context.Context, olderThan time.Time, limit int) ([]domain.Notification, error) {
+	const op = "repo.notification.FindLost"
+
+	query := `
+    SELECT id, message, scheduled_at, channel, recipient, priority, expires_at, digest, status
+    FROM notification
+    WHERE (
+        (status = 'scheduled'
+          AND COALESCE(next_retry_at, scheduled_at) < $1
+          AND last_attempt_at IS NULL
+          AND (republished_at IS NULL OR republished_at < $1))
+        OR (status = 'sending'
+          AND lease_until < $1
+          AND (republished_at IS NULL OR republished_at < lease_until))
+      )
+      AND NOT EXISTS (SELECT 1 FROM outbox WHERE outbox.notification_id = notification.id)
+    ORDER BY scheduled_at
+    LIMIT $2`
+
+	rows, err := r.db.QueryContext(ctx, query, olderThan, limit)
+	if err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var lost []domain.Notification
+	for rows.Next() {
+		var n domain.Notification
+		if err := rows.Scan(
+			&n.ID,
+			&n.Message,
+			&n.ScheduledAt,
+			&n.Channel,
+			&n.Recipient,
+			&n.Priority,
+			&n.ExpiresAt,
+			&n.Digest,
+			&n.Status,
+		); err != nil {
+			return nil, errutils.Wrap(op, err)
+		}
+		lost = append(lost, n)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+
+	return lost, nil
+}
+
+func (r *Repo) MarkRepublished(ctx context.Context, ID uuid.UUID) error {
+	const op = "repo.notification.MarkRepublished"
+
+	query := `UPDATE notification SET republished_at = NOW() WHERE id = $1`
+
+	return r.execAffectingOne(ctx, op, query, ID)
+}
+
+// ClaimOutbox забирает до limit записей outbox в порядке создания до until и
+// возвращает их с уведомлениями. Захват фиксируется сразу, поэтому публикация идёт
+// вне транзакции, а другие инстансы пропускают захваченные записи до until.
+func (r *Repo) ClaimOutbox(ctx context.Context, limit int, now time.Time, until time.Time) ([]domain.OutboxEntry, error) {
+	const op = "repo.notification.ClaimOutbox"
+
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	query := `
+    SELECT o.id, n.id, n.message, n.scheduled_at, n.channel, n.recipient, n.priority, n.expires_at, n.digest, n.status
+    FROM outbox o
+    JOIN notification n ON n.id = o.notification_id
+    WHERE o.claimed_until IS NULL OR o.claimed_until < $2
+    ORDER BY o.id
+    LIMIT $1
+    FOR UPDATE OF o SKIP LOCKED`
+
+	rows, err := tx.QueryContext(ctx, query, limit, now)
+	if err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+
+	var (
+		entries []domain.OutboxEntry
+		IDs     []int64
+	)
+	for rows.Next() {
+		var entry domain.OutboxEntry
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.Notification.ID,
+			&entry.Notification.Message,
+			&entry.Notification.ScheduledAt,
+			&entry.Notification.Channel,
+			&entry.Notification.Recipient,
+			&entry.Notification.Priority,
+			&entry.Notification.ExpiresAt,
+			&entry.Notification.Digest,
+			&entry.Notification.Status,
+		); err != nil {
+			_ = rows.Close()
+			return nil, errutils.Wrap(op, err)
+		}
+		entries = append(entries, entry)
+		IDs = append(IDs, entry.ID)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return nil, errutils.Wrap(op, err)
+	}
+	_ = rows.Close()
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	claimQuery := `UPDATE outbox SET claimed_until = $1 WHERE id = ANY($2::bigint[])`
+	if _, err := tx.ExecContext(ctx, claimQuery, until, pq.Array(IDs)); err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+
+	return entries, nil
+}
+
+// DeleteOutbox удаляет опубликованные записи outbox.
+func (r *Repo) DeleteOutbox(ctx context.Context, IDs []int64) error {
+	const op = "repo.notification.DeleteOutbox"
+
+	query := `DELETE FROM outbox WHERE id = ANY($1::bigint[])`
+	if _, err := r.db.ExecContext(ctx, query, pq.Array(IDs)); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// FailOutbox записывает неудачную публикацию и снимает захват записи.
+func (r *Repo) FailOutbox(ctx context.Context, ID int64, lastError string) error {
+	const op = "repo.notification.FailOutbox"
+
+	query := `UPDATE outbox SET attempts = attempts + 1, last_error = $1, claimed_until = NULL WHERE id = $2`
+	if _, err := r.db.ExecContext(ctx, query, lastError, ID); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// ReleaseOutbox снимает захват с записей, до которых публикация не дошла.
+func (r *Repo) ReleaseOutbox(ctx context.Context, IDs []int64) error {
+	const op = "repo.notification.ReleaseOutbox"
+
+	query := `UPDATE outbox SET claimed_until = NULL WHERE id = ANY($1::bigint[])`
+	if _, err := r.db.ExecContext(ctx, query, pq.Array(IDs)); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	return nil
+}
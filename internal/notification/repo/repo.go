@@ -1,7 +1,82 @@
 package repo
 
-import "errors"
+import (
+	"delayed-notifier/internal/notification/types/domain"
+	"errors"
+	"fmt"
+	"slices"
+)
 
 var (
-	ErrNotifNotFound = errors.New("notification not found")
+	ErrNotifNotFound     = errors.New("notification not found")
+	ErrNotifExists       = errors.New("notification already exists")
+	ErrInvalidTransition = errors.New("invalid notification status transition")
+	ErrNotClaimable      = errors.New("notification is already sent or being sent")
+	ErrNotDue            = errors.New("notification is scheduled for later")
 )
+
+// TransitionError - отклонённый переход статуса, From - текущий статус уведомления.
+type TransitionError struct {
+	From domain.NotificationStatus
+	To   domain.NotificationStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s: %s -> %s", ErrInvalidTransition, e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// transitions - допустимые переходы статусов, которые проверяют репозитории.
+// sent, canceled, failed, expired и dropped - конечные статусы.
+var transitions = map[domain.NotificationStatus][]domain.NotificationStatus{
+	domain.Scheduled: {domain.Sending, domain.Canceled, domain.Expired},
+	// sending -> scheduled: повтор, отсрочка или освобождение при остановке
+	domain.Sending: {domain.Scheduled, domain.Sent, domain.Failed, domain.Expired, domain.Dropped},
+}
+
+// revivable - статусы, из которых уведомление возвращается в scheduled только
+// переопубликацией из DLQ. В общую таблицу переходов они не входят, чтобы
+// обычные записи статуса не могли оживить завершённое уведомление.
+var revivable = []domain.NotificationStatus{domain.Scheduled, domain.Failed, domain.Expired, domain.Dropped}
+
+// CanTransition - разрешён ли переход из статуса from в статус to.
+func CanTransition(from, to domain.NotificationStatus) bool {
+	return slices.Contains(transitions[from], to)
+}
+
+// IsFinal - конечный ли статус: из него нет обычных переходов.
+func IsFinal(status domain.NotificationStatus) bool {
+	return len(transitions[status]) == 0
+}
+
+// SourcesOf возвращает статусы, из которых разрешён переход в to.
+func SourcesOf(to domain.NotificationStatus) []string {
+	var sources []string
+	for from, allowed := range transitions {
+		if slices.Contains(allowed, to) {
+			sources = append(sources, string(from))
+		}
+	}
+	slices.Sort(sources)
+
+	return sources
+}
+
+// CanRevive - можно ли переопубликовать уведомление в статусе from.
+func CanRevive(from domain.NotificationStatus) bool {
+	return slices.Contains(revivable, from)
+}
+
+// RevivableSources возвращает статусы, из которых разрешена переопубликация.
+func RevivableSources() []string {
+	sources := make([]string, 0, len(revivable))
+	for _, from := range revivable {
+		sources = append(sources, string(from))
+	}
+	slices.Sort(sources)
+
+	return sources
+}
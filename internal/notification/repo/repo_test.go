@@ -0,0 +1,64 @@
+package repo
+
+import (
+	"delayed-notifier/internal/notification/types/domain"
+	"slices"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to domain.NotificationStatus
+		want     bool
+	}{
+		{domain.Scheduled, domain.Sending, true},
+		{domain.Scheduled, domain.Canceled, true},
+		{domain.Scheduled, domain.Sent, false},
+		{domain.Sending, domain.Sent, true},
+		{domain.Sending, domain.Scheduled, true},
+		{domain.Sending, domain.Canceled, false},
+		{domain.Scheduled, domain.Scheduled, false},
+		{domain.Failed, domain.Scheduled, false},
+		{domain.Expired, domain.Scheduled, false},
+		{domain.Dropped, domain.Scheduled, false},
+		{domain.Sent, domain.Scheduled, false},
+		{domain.Sent, domain.Canceled, false},
+		{domain.Canceled, domain.Scheduled, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestSourcesOf(t *testing.T) {
+	got := SourcesOf(domain.Sent)
+	if !slices.Equal(got, []string{string(domain.Sending)}) {
+		t.Fatalf("SourcesOf(sent) = %v, want [sending]", got)
+	}
+
+	got = SourcesOf(domain.Scheduled)
+	if !slices.Equal(got, []string{string(domain.Sending)}) {
+		t.Fatalf("SourcesOf(scheduled) = %v, want [sending]", got)
+	}
+}
+
+func TestCanRevive(t *testing.T) {
+	for _, from := range []domain.NotificationStatus{domain.Scheduled, domain.Failed, domain.Expired, domain.Dropped} {
+		if !CanRevive(from) {
+			t.Errorf("CanRevive(%s) = false, want true", from)
+		}
+	}
+	for _, from := range []domain.NotificationStatus{domain.Sending, domain.Sent, domain.Canceled} {
+		if CanRevive(from) {
+			t.Errorf("CanRevive(%s) = true, want false", from)
+		}
+	}
+
+	want := []string{"dropped", "expired", "failed", "scheduled"}
+	if got := RevivableSources(); !slices.Equal(got, want) {
+		t.Fatalf("RevivableSources() = %v, want %v", got, want)
+	}
+}
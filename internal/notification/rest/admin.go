@@ -0,0 +1,69 @@
+package rest
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/breaker"
+	"delayed-notifier/internal/notification/reconciler"
+	"delayed-notifier/internal/response"
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"net/http"
+	"strconv"
+)
+
+type Reconciler interface {
+	Run(ctx context.Context, dryRun bool) (reconciler.Report, error)
+	LastReport() *reconciler.Report
+}
+
+type Breakers interface {
+	Snapshots() []breaker.Snapshot
+}
+
+// Admin - служебные эндпоинты для сопровождения сервиса.
+type Admin struct {
+	reconciler Reconciler
+	breakers   Breakers
+}
+
+func NewAdmin(reconciler Reconciler, breakers Breakers) *Admin {
+	return &Admin{reconciler: reconciler, breakers: breakers}
+}
+
+// GetBreakers отдаёт состояние автоматов отправщиков по каналам.
+func (a *Admin) GetBreakers(c *ginext.Context) {
+	c.JSON(http.StatusOK, response.Success(a.breakers.Snapshots()))
+}
+
+// GetReconcileReport отдаёт отчёт последнего прохода сверки.
+func (a *Admin) GetReconcileReport(c *ginext.Context) {
+	report := a.reconciler.LastReport()
+	if report == nil {
+		c.JSON(http.StatusNotFound, response.Error("reconciliation has not run yet"))
+		return
+	}
+
+	c.JSON(http.StatusOK, response.Success(report))
+}
+
+// Reconcile запускает проход сверки; ?dry_run=true только показывает, что было бы переопубликовано.
+func (a *Admin) Reconcile(c *ginext.Context) {
+	dryRun := false
+	if value := c.Query("dry_run"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, response.Error("dry_run must be boolean"))
+			return
+		}
+		dryRun = parsed
+	}
+
+	report, err := a.reconciler.Run(c.Request.Context(), dryRun)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to reconcile lost notifications")
+		c.JSON(http.StatusInternalServerError, response.Error("failed to reconcile notifications"))
+		return
+	}
+
+	c.JSON(http.StatusOK, response.Success(report))
+}
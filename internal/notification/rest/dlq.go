@@ -0,0 +1,196 @@
+package rest
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/rabbitmq/notifier"
+	"delayed-notifier/internal/notification/types/dto"
+	"delayed-notifier/internal/response"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/retry"
+	"github.com/wb-go/wbf/zlog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const defaultDeadLettersLimit = 100
+
+type DeadLetters interface {
+	DeadLetters(ctx context.Context, limit int) ([]notifier.DeadLetter, error)
+	DeadLetter(ctx context.Context, ID string) (notifier.DeadLetter, error)
+	ReplayDeadLetters(ctx context.Context, IDs []string, replay notifier.ReplayFunc) error
+	PurgeDeadLetters(ctx context.Context, IDs []string) (int, error)
+}
+
+type DeadLetterReplayer interface {
+	ReplayDeadLetter(ctx context.Context, message notifier.Message, scheduledAt *time.Time, strategy retry.Strategy) error
+}
+
+// DLQ - эндпоинты для разбора сообщений, попавших в DLQ.
+type DLQ struct {
+	deadLetters DeadLetters
+	replayer    DeadLetterReplayer
+	validator   Validator
+	strategy    retry.Strategy
+}
+
+func NewDLQ(deadLetters DeadLetters, replayer DeadLetterReplayer, validator Validator, strategy retry.Strategy) *DLQ {
+	return &DLQ{
+		deadLetters: deadLetters,
+		replayer:    replayer,
+		validator:   validator,
+		strategy:    strategy,
+	}
+}
+
+// ListDeadLetters отдаёт до ?limit= сообщений DLQ с причинами попадания туда.
+func (d *DLQ) ListDeadLetters(c *ginext.Context) {
+	limit := defaultDeadLettersLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, response.Error("limit must be positive integer"))
+			return
+		}
+		limit = parsed
+	}
+
+	letters, err := d.deadLetters.DeadLetters(c.Request.Context(), limit)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to list dead letters")
+		c.JSON(http.StatusInternalServerError, response.Error("failed to list dead letters"))
+		return
+	}
+
+	views := make([]dto.DeadLetter, 0, len(letters))
+	for _, letter := range letters {
+		views = append(views, deadLetterToDTO(letter))
+	}
+
+	c.JSON(http.StatusOK, response.Success(views))
+}
+
+func (d *DLQ) GetDeadLetter(c *ginext.Context) {
+	letter, err := d.deadLetters.DeadLetter(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, notifier.ErrDeadLetterNotFound) {
+			c.JSON(http.StatusNotFound, response.Error("dead letter with such id not found"))
+			return
+		}
+		zlog.Logger.Error().Err(err).Str("id", c.Param("id")).Msg("failed to get dead letter")
+		c.JSON(http.StatusInternalServerError, response.Error("failed to get dead letter"))
+		return
+	}
+
+	c.JSON(http.StatusOK, response.Success(deadLetterToDTO(letter)))
+}
+
+// ReplayDeadLetters публикует выбранные сообщения заново и удаляет их из DLQ.
+func (d *DLQ) ReplayDeadLetters(c *ginext.Context) {
+	var req dto.ReplayDeadLetters
+
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to decode request body")
+		c.JSON(http.StatusBadRequest, response.Error("invalid request body"))
+		return
+	}
+
+	if err := d.validator.Validate(req); err != nil {
+		c.JSON(http.StatusBadRequest, response.Error(fmt.Sprintf("validation error: %s", err.Error())))
+		return
+	}
+
+	var scheduledAt *time.Time
+	if req.ScheduledAt != "" {
+		parsed, err := time.Parse(time.RFC3339, req.ScheduledAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, response.Error("scheduled_at must be RFC3339"))
+			return
+		}
+		scheduledAt = &parsed
+	}
+
+	ctx := c.Request.Context()
+	result := dto.ReplayedDeadLetters{Replayed: []string{}, Failed: map[string]string{}}
+
+	err := d.deadLetters.ReplayDeadLetters(ctx, req.IDs, func(letter notifier.DeadLetter) error {
+		err := errors.New("message body is not a notification")
+		if letter.Message != nil {
+			err = d.replayer.ReplayDeadLetter(ctx, *letter.Message, scheduledAt, d.strategy)
+		}
+		if err != nil {
+			zlog.Logger.Error().Err(err).Str("id", letter.ID).Msg("failed to replay dead letter")
+			result.Failed[letter.ID] = err.Error()
+			return err
+		}
+		result.Replayed = append(result.Replayed, letter.ID)
+		return nil
+	})
+	if err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to replay dead letters")
+		c.JSON(http.StatusInternalServerError, response.Error("failed to replay dead letters"))
+		return
+	}
+
+	for _, id := range req.IDs {
+		if _, ok := result.Failed[id]; ok || slices.Contains(result.Replayed, id) {
+			continue
+		}
+		result.Failed[id] = notifier.ErrDeadLetterNotFound.Error()
+	}
+
+	c.JSON(http.StatusOK, response.Success(result))
+}
+
+// PurgeDeadLetters удаляет сообщения ?id=...&id=... из DLQ; ?all=true очищает DLQ целиком.
+func (d *DLQ) PurgeDeadLetters(c *ginext.Context) {
+	ids := c.QueryArray("id")
+	all, _ := strconv.ParseBool(c.Query("all"))
+	if len(ids) == 0 && !all {
+		c.JSON(http.StatusBadRequest, response.Error("specify id or all=true"))
+		return
+	}
+	if all {
+		ids = nil
+	}
+
+	purged, err := d.deadLetters.PurgeDeadLetters(c.Request.Context(), ids)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to purge dead letters")
+		c.JSON(http.StatusInternalServerError, response.Error("failed to purge dead letters"))
+		return
+	}
+
+	c.JSON(http.StatusOK, response.Success(dto.PurgedDeadLetters{Purged: purged}))
+}
+
+func deadLetterToDTO(letter notifier.DeadLetter) dto.DeadLetter {
+	view := dto.DeadLetter{
+		ID:     letter.ID,
+		Reason: letter.Reason,
+		Error:  letter.Error,
+		Queue:  letter.Queue,
+		Count:  letter.Count,
+		DiedAt: letter.DiedAt,
+	}
+
+	if letter.Message == nil {
+		view.Body = letter.Body
+		return view
+	}
+
+	view.Notification = &dto.DeadLetterNotification{
+		ID:          letter.Message.ID.String(),
+		Message:     letter.Message.Message,
+		ScheduledAt: letter.Message.ScheduledAt,
+		Channel:     letter.Message.Channel,
+		Recipient:   letter.Message.Recipient,
+		Priority:    letter.Message.Priority,
+	}
+
+	return view
+}
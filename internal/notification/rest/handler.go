@@ -3,6 +3,7 @@ package rest
 import (
 	"context"
 	"delayed-notifier/internal/notification/service"
+	"delayed-notifier/internal/notification/types/domain"
 	"delayed-notifier/internal/notification/types/dto"
 	"delayed-notifier/internal/response"
 	"encoding/json"
@@ -12,13 +13,28 @@ import (
 	"github.com/wb-go/wbf/ginext"
 	"github.com/wb-go/wbf/retry"
 	"github.com/wb-go/wbf/zlog"
+	"io"
 	"net/http"
 )
 
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
 type Notification interface {
-	Create(ctx context.Context, notification dto.Notification, strategy retry.Strategy) error
-	GetStatusByID(ctx context.Context, ID string) (string, error)
-	SetStatus(ctx context.Context, ID string, status string, strategy retry.Strategy) error
+	Create(ctx context.Context, notification dto.Notification) (string, error)
+	CreateIdempotent(ctx context.Context, key string, notification dto.Notification) (string, bool, error)
+	GetByID(ctx context.Context, ID string) (domain.Notification, error)
+	ListAttempts(ctx context.Context, ID string) ([]domain.Attempt, error)
+	Cancel(
+		ctx context.Context,
+		ID string,
+		by string,
+		reason string,
+		followUps bool,
+		strategy retry.Strategy,
+	) (domain.CancelResult, error)
 }
 
 type Validator interface {
@@ -53,33 +69,115 @@ func (h *Handler) CreateNotification(c *ginext.Context) {
 		return
 	}
 
-	if err := h.notification.Create(c.Request.Context(), dtoNotif, h.strategy); err != nil {
+	var (
+		id       string
+		replayed bool
+		err      error
+	)
+
+	if key := c.GetHeader(idempotencyKeyHeader); key != "" {
+		id, replayed, err = h.notification.CreateIdempotent(c.Request.Context(), key, dtoNotif)
+	} else {
+		id, err = h.notification.Create(c.Request.Context(), dtoNotif)
+	}
+
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidNotification) {
+			c.JSON(http.StatusBadRequest, response.Error(fmt.Sprintf("validation error: %s", err.Error())))
+			return
+		}
+		if errors.Is(err, service.ErrIdempotencyMismatch) {
+			c.JSON(http.StatusUnprocessableEntity, response.Error("idempotency key was already used with a different request body"))
+			return
+		}
 		zlog.Logger.Error().Err(err).Msg("failed to create notification")
 		c.JSON(http.StatusInternalServerError, response.Error("failed to create notification"))
+		return
+	}
+
+	if replayed {
+		c.Header(idempotentReplayedHeader, "true")
 	}
 
-	c.Status(http.StatusCreated)
+	c.JSON(http.StatusCreated, response.Success(dto.CreatedNotification{ID: id}))
 }
 
+// GetNotificationStatus отдаёт только статус строкой, как и раньше.
+// Подробности - в GetNotificationDetails.
 func (h *Handler) GetNotificationStatus(c *ginext.Context) {
+	notification, ok := h.getNotification(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, response.Success(string(notification.Status)))
+}
+
+func (h *Handler) GetNotificationDetails(c *ginext.Context) {
+	notification, ok := h.getNotification(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, response.Success(domainToStatus(notification)))
+}
+
+// getNotification читает уведомление по id из пути и сам отвечает на ошибку.
+func (h *Handler) getNotification(c *ginext.Context) (domain.Notification, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response.Error("id must be UUID format"))
+		return domain.Notification{}, false
+	}
+
+	notification, err := h.notification.GetByID(c.Request.Context(), id.String())
+	if err != nil {
+		if errors.Is(err, service.ErrNotifNotFound) {
+			c.JSON(http.StatusNotFound, response.Error("notification with such id not found"))
+			return domain.Notification{}, false
+		}
+		zlog.Logger.Error().Err(err).Str("id", id.String()).Msg("failed to get notification")
+		c.JSON(http.StatusInternalServerError, response.Error("failed to get notification status"))
+		return domain.Notification{}, false
+	}
+
+	return notification, true
+}
+
+func (h *Handler) GetNotificationAttempts(c *ginext.Context) {
 	id, err := uuid.Parse(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, response.Error("id must be UUID format"))
 		return
 	}
 
-	status, err := h.notification.GetStatusByID(c.Request.Context(), id.String())
+	attempts, err := h.notification.ListAttempts(c.Request.Context(), id.String())
 	if err != nil {
 		if errors.Is(err, service.ErrNotifNotFound) {
 			c.JSON(http.StatusNotFound, response.Error("notification with such id not found"))
 			return
 		}
-		zlog.Logger.Error().Err(err).Str("id", id.String()).Msg("failed to cancel notification")
-		c.JSON(http.StatusInternalServerError, response.Error("failed to get notification status"))
+		zlog.Logger.Error().Err(err).Str("id", id.String()).Msg("failed to list notification attempts")
+		c.JSON(http.StatusInternalServerError, response.Error("failed to get notification attempts"))
 		return
 	}
 
-	c.JSON(http.StatusOK, response.Success(status))
+	views := make([]dto.Attempt, 0, len(attempts))
+	for _, attempt := range attempts {
+		views = append(views, dto.Attempt{
+			AttemptedAt:      attempt.AttemptedAt,
+			Channel:          string(attempt.Channel),
+			Recipient:        attempt.Recipient,
+			DurationMs:       attempt.Duration.Milliseconds(),
+			Outcome:          string(attempt.Outcome),
+			ErrorClass:       attempt.ErrorClass,
+			Error:            attempt.Error,
+			ProviderResponse: attempt.ProviderResponse,
+			Digest:           attempt.Digest,
+		})
+	}
+
+	c.JSON(http.StatusOK, response.Success(views))
 }
 
 func (h *Handler) CancelNotification(c *ginext.Context) {
@@ -89,15 +187,127 @@ func (h *Handler) CancelNotification(c *ginext.Context) {
 		return
 	}
 
-	if err := h.notification.SetStatus(c.Request.Context(), id.String(), "canceled", h.strategy); err != nil {
+	// Тело необязательно
+	var req dto.CancelNotification
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, response.Error("invalid request body"))
+		return
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		c.JSON(http.StatusBadRequest, response.Error(fmt.Sprintf("validation error: %s", err.Error())))
+		return
+	}
+
+	result, err := h.notification.Cancel(
+		c.Request.Context(),
+		id.String(),
+		req.CanceledBy,
+		req.Reason,
+		req.CancelFollowUps,
+		h.strategy,
+	)
+	if err != nil {
 		if errors.Is(err, service.ErrNotifNotFound) {
 			c.JSON(http.StatusNotFound, response.Error("notification with such id not found"))
 			return
 		}
+		var conflict *service.ConflictError
+		if errors.As(err, &conflict) {
+			c.JSON(http.StatusConflict, response.Error(dto.CancelConflict{
+				Error:  "notification can no longer be canceled",
+				Status: conflict.Status,
+			}))
+			return
+		}
 		zlog.Logger.Error().Err(err).Str("id", id.String()).Msg("failed to cancel notification")
 		c.JSON(http.StatusInternalServerError, response.Error("failed to cancel notification"))
 		return
 	}
 
-	c.Status(http.StatusOK)
+	var cancellation *dto.Cancellation
+	if result.Cancellation != nil {
+		view := cancellationToDTO(*result.Cancellation)
+		cancellation = &view
+	}
+	followUps := make([]string, 0, len(result.FollowUps))
+	for _, followUpID := range result.FollowUps {
+		followUps = append(followUps, followUpID.String())
+	}
+
+	c.JSON(http.StatusOK, response.Success(dto.CanceledNotification{
+		ID:                id.String(),
+		Status:            string(result.Status),
+		Cancellation:      cancellation,
+		CanceledFollowUps: followUps,
+	}))
+}
+
+func cancellationToDTO(cancellation domain.Cancellation) dto.Cancellation {
+	return dto.Cancellation{
+		Reason:     cancellation.Reason,
+		CanceledBy: cancellation.By,
+		CanceledAt: cancellation.At,
+	}
+}
+
+func domainToStatus(notification domain.Notification) dto.NotificationStatus {
+	var digestID, parentID string
+	if notification.DigestID != nil {
+		digestID = notification.DigestID.String()
+	}
+	if notification.ParentID != nil {
+		parentID = notification.ParentID.String()
+	}
+
+	// Ожидающее повтора уведомление показываем как retrying
+	status := string(notification.Status)
+	var retryStatus *dto.RetryStatus
+	if notification.Retries > 0 {
+		retryStatus = &dto.RetryStatus{
+			Attempt:     notification.Retries + 1,
+			MaxAttempts: notification.MaxAttempts,
+		}
+		switch notification.Status {
+		case domain.Scheduled:
+			status = "retrying"
+			retryStatus.NextAttemptAt = notification.NextRetryAt
+		case domain.Failed:
+			// Неудачной была и последняя попытка
+			retryStatus.Attempt = notification.Retries
+		}
+	}
+
+	var lastFailure *dto.SendFailure
+	if notification.LastFailure != nil {
+		lastFailure = &dto.SendFailure{
+			Class:    notification.LastFailure.Class,
+			Error:    notification.LastFailure.Error,
+			Response: notification.LastFailure.Response,
+		}
+	}
+
+	var cancellation *dto.Cancellation
+	if notification.Cancellation != nil {
+		view := cancellationToDTO(*notification.Cancellation)
+		cancellation = &view
+	}
+
+	return dto.NotificationStatus{
+		ID:            notification.ID.String(),
+		Status:        status,
+		Channel:       string(notification.Channel),
+		Recipient:     notification.Recipient,
+		Priority:      string(notification.Priority),
+		ScheduledAt:   notification.ScheduledAt,
+		ExpiresAt:     notification.ExpiresAt,
+		RateLimit:     string(notification.RateLimit),
+		DeferredUntil: notification.DeferredUntil,
+		Digest:        notification.Digest,
+		DigestID:      digestID,
+		ParentID:      parentID,
+		Retry:         retryStatus,
+		LastFailure:   lastFailure,
+		Cancellation:  cancellation,
+	}
 }
@@ -0,0 +1,76 @@
+package rest
+
+import (
+	"delayed-notifier/internal/response"
+	"github.com/wb-go/wbf/ginext"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Dependency - внешняя зависимость, без которой сервис не готов принимать запросы.
+type Dependency interface {
+	Ready() bool
+}
+
+// Health - проверка готовности сервиса по состоянию зависимостей.
+type Health struct {
+	deps map[string]Dependency
+}
+
+func NewHealth(deps map[string]Dependency) *Health {
+	return &Health{deps: deps}
+}
+
+// Ready отдаёт 200, если все зависимости доступны, иначе 503 со списком состояний.
+func (h *Health) Ready(c *ginext.Context) {
+	states := make(map[string]string, len(h.deps))
+	for name := range h.deps {
+		states[name] = "up"
+	}
+
+	if down := h.down(); len(down) > 0 {
+		for _, name := range down {
+			states[name] = "down"
+		}
+		c.JSON(http.StatusServiceUnavailable, response.Error(states))
+		return
+	}
+
+	c.JSON(http.StatusOK, response.Success(states))
+}
+
+// RequireReady сразу отвечает 503, пока недоступна какая-либо из зависимостей
+// names (все, если не заданы), вместо того чтобы держать запрос до таймаута.
+func (h *Health) RequireReady(names ...string) ginext.HandlerFunc {
+	return func(c *ginext.Context) {
+		if down := h.down(names...); len(down) > 0 {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable,
+				response.Error("service unavailable: "+strings.Join(down, ", ")+" is down"))
+			return
+		}
+		c.Next()
+	}
+}
+
+func (h *Health) down(names ...string) []string {
+	deps := h.deps
+	if len(names) > 0 {
+		deps = make(map[string]Dependency, len(names))
+		for _, name := range names {
+			if dep, ok := h.deps[name]; ok {
+				deps[name] = dep
+			}
+		}
+	}
+
+	var down []string
+	for name, dep := range deps {
+		if !dep.Ready() {
+			down = append(down, name)
+		}
+	}
+	sort.Strings(down)
+
+	return down
+}
@@ -0,0 +1,39 @@
+package senders
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+)
+
+// CircuitBreaker отклоняет отправки по каналу, провайдер которого недоступен.
+// Allow возвращает ошибку, пока цепь разомкнута; Record учитывает результат
+// разрешённой отправки.
+type CircuitBreaker interface {
+	Allow(channel domain.NotificationChannel) error
+	Record(ctx context.Context, channel domain.NotificationChannel, err error)
+}
+
+type breakerSender struct {
+	next    NotificationSender
+	breaker CircuitBreaker
+	channel domain.NotificationChannel
+}
+
+// WithBreaker возвращает отправщик, не обращающийся к провайдеру, пока цепь канала разомкнута.
+func WithBreaker(breaker CircuitBreaker) func(domain.NotificationChannel, NotificationSender) NotificationSender {
+	return func(channel domain.NotificationChannel, sender NotificationSender) NotificationSender {
+		return &breakerSender{next: sender, breaker: breaker, channel: channel}
+	}
+}
+
+func (s *breakerSender) Send(ctx context.Context, message string, recipient string) error {
+	// Ошибку отказа не оборачиваем: по ней воркер откладывает уведомление
+	if err := s.breaker.Allow(s.channel); err != nil {
+		return err
+	}
+
+	err := s.next.Send(ctx, message, recipient)
+	s.breaker.Record(ctx, s.channel, err)
+
+	return err
+}
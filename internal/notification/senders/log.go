@@ -0,0 +1,15 @@
+package senders
+
+import (
+	"context"
+	"github.com/wb-go/wbf/zlog"
+)
+
+// LogSender пишет уведомления в лог вместо реальной отправки.
+// Используется в режиме разработки, когда внешние сервисы недоступны.
+type LogSender struct{}
+
+func (LogSender) Send(_ context.Context, message string, recipient string) error {
+	zlog.Logger.Info().Str("recipient", recipient).Str("text", message).Msg("notification delivered to log")
+	return nil
+}
@@ -1,11 +1,12 @@
 package senders
 
 import (
+	"context"
 	"delayed-notifier/internal/notification/types/domain"
 )
 
 type NotificationSender interface {
-	Send(message string, recipient string) error
+	Send(ctx context.Context, message string, recipient string) error
 }
 
 type NotificationSenders struct {
@@ -24,3 +25,11 @@ func New(emailClient NotificationSender) NotificationSenders {
 func (s *NotificationSenders) ForChannel(channel domain.NotificationChannel) NotificationSender {
 	return s.senders[channel]
 }
+
+// Use оборачивает отправщик каждого канала декоратором (троттлинг и т.п.).
+// Декоратор последнего вызова Use оказывается внешним.
+func (s *NotificationSenders) Use(wrap func(channel domain.NotificationChannel, sender NotificationSender) NotificationSender) {
+	for channel, sender := range s.senders {
+		s.senders[channel] = wrap(channel, sender)
+	}
+}
@@ -0,0 +1,46 @@
+package senders
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/breaker"
+	"delayed-notifier/internal/notification/types/domain"
+	"errors"
+	"testing"
+	"time"
+)
+
+type failingSender struct{ calls int }
+
+func (s *failingSender) Send(context.Context, string, string) error {
+	s.calls++
+	return errors.New("timeout")
+}
+
+type countingThrottle struct{ acquired int }
+
+func (t *countingThrottle) Acquire(context.Context, domain.NotificationChannel) (func(), error) {
+	t.acquired++
+	return func() {}, nil
+}
+
+func TestOpenBreakerDoesNotTakeThrottleSlot(t *testing.T) {
+	ctx := context.Background()
+	sender := &failingSender{}
+	throttle := &countingThrottle{}
+
+	s := New(sender)
+	s.Use(WithThrottle(throttle))
+	s.Use(WithBreaker(breaker.New(breaker.Opts{FailureThreshold: 1, CoolDown: time.Hour})))
+
+	if err := s.ForChannel(domain.Email).Send(ctx, "hello", "user@example.com"); err == nil {
+		t.Fatal("first send: want provider error")
+	}
+
+	err := s.ForChannel(domain.Email).Send(ctx, "hello", "user@example.com")
+	if !errors.Is(err, breaker.ErrOpen) {
+		t.Fatalf("second send: got %v, want ErrOpen", err)
+	}
+	if throttle.acquired != 1 || sender.calls != 1 {
+		t.Fatalf("acquired %d, sent %d: want the open circuit to stop before the throttle", throttle.acquired, sender.calls)
+	}
+}
@@ -0,0 +1,43 @@
+package senders
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"delayed-notifier/pkg/errutils"
+	"delayed-notifier/pkg/senderr"
+	"time"
+)
+
+// throttleRetryAfter - через сколько повторить отправку, если троттлер недоступен.
+const throttleRetryAfter = 5 * time.Second
+
+// Throttle ограничивает пропускную способность провайдера канала.
+// Acquire блокируется, пока отправка не будет разрешена; release
+// освобождает занятое соединение.
+type Throttle interface {
+	Acquire(ctx context.Context, channel domain.NotificationChannel) (release func(), err error)
+}
+
+type throttledSender struct {
+	next     NotificationSender
+	throttle Throttle
+	channel  domain.NotificationChannel
+}
+
+// WithThrottle возвращает отправщик, ожидающий разрешения throttle перед каждой отправкой.
+func WithThrottle(throttle Throttle) func(domain.NotificationChannel, NotificationSender) NotificationSender {
+	return func(channel domain.NotificationChannel, sender NotificationSender) NotificationSender {
+		return &throttledSender{next: sender, throttle: throttle, channel: channel}
+	}
+}
+
+func (s *throttledSender) Send(ctx context.Context, message string, recipient string) error {
+	release, err := s.throttle.Acquire(ctx, s.channel)
+	if err != nil {
+		// Провайдер не вызывался: ошибка не должна тратить попытку и размыкать цепь
+		return senderr.NewThrottled(throttleRetryAfter, errutils.Wrap("failed to acquire send throttle", err))
+	}
+	defer release()
+
+	return s.next.Send(ctx, message, recipient)
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/retry"
+	"testing"
+	"time"
+)
+
+func TestCancelFinishedParent(t *testing.T) {
+	ctx := context.Background()
+	strategy := retry.Strategy{Attempts: 1}
+
+	// create возвращает отправленного родителя и созданный им follow-up
+	create := func(t *testing.T) (*Notification, uuid.UUID, uuid.UUID) {
+		t.Helper()
+
+		service, repo := newOutboxService(t, &fakeNotifier{})
+		parent := domain.Notification{
+			ID:          uuid.New(),
+			Message:     "parent",
+			ScheduledAt: time.Now().Add(-time.Minute),
+			Channel:     domain.Email,
+			Recipient:   "user@example.com",
+			FollowUps:   []domain.FollowUp{{ID: uuid.New(), Trigger: domain.Sent, Delay: time.Hour, Message: "sent"}},
+		}
+		if err := repo.CreateNotification(ctx, parent); err != nil {
+			t.Fatalf("CreateNotification: %v", err)
+		}
+
+		claim, claimed, err := service.Claim(ctx, parent.ID.String(), parent.ScheduledAt, strategy)
+		if err != nil || !claimed {
+			t.Fatalf("Claim: %v, claimed %v", err, claimed)
+		}
+		if err := service.SetStatus(ctx, parent.ID.String(), claim.Version, string(domain.Sent), strategy); err != nil {
+			t.Fatalf("SetStatus: %v", err)
+		}
+
+		// Follow-up на отправку уже сработал и создал уведомление
+		child := domain.Notification{
+			ID:          uuid.New(),
+			Message:     "sent",
+			ScheduledAt: time.Now().Add(time.Hour),
+			Channel:     domain.Email,
+			Recipient:   "user@example.com",
+			Status:      domain.Scheduled,
+			ParentID:    &parent.ID,
+		}
+		if created, err := repo.CreateFollowUpNotification(ctx, parent.FollowUps[0].ID, child); err != nil || !created {
+			t.Fatalf("CreateFollowUpNotification: %v, created %v", err, created)
+		}
+
+		return service, parent.ID, child.ID
+	}
+
+	t.Run("conflict without follow-ups option", func(t *testing.T) {
+		service, parentID, childID := create(t)
+
+		_, err := service.Cancel(ctx, parentID.String(), "test", "", false, strategy)
+		var conflict *ConflictError
+		if !errors.As(err, &conflict) || conflict.Status != string(domain.Sent) {
+			t.Fatalf("got %v, want conflict with status sent", err)
+		}
+
+		if child, _ := service.notifRepo.GetByID(ctx, childID); child.Status != domain.Scheduled {
+			t.Fatalf("follow-up status: got %s, want %s", child.Status, domain.Scheduled)
+		}
+	})
+
+	t.Run("follow-ups option", func(t *testing.T) {
+		service, parentID, childID := create(t)
+
+		result, err := service.Cancel(ctx, parentID.String(), "test", "", true, strategy)
+		if err != nil {
+			t.Fatalf("Cancel: %v", err)
+		}
+
+		if result.Status != domain.Sent || result.Cancellation != nil {
+			t.Fatalf("result: got status %s, cancellation %v", result.Status, result.Cancellation)
+		}
+		if len(result.FollowUps) != 1 || result.FollowUps[0] != childID {
+			t.Fatalf("follow-ups: got %v, want [%s]", result.FollowUps, childID)
+		}
+		if child, _ := service.notifRepo.GetByID(ctx, childID); child.Status != domain.Canceled {
+			t.Fatalf("follow-up status: got %s, want %s", child.Status, domain.Canceled)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/cache"
+	"delayed-notifier/internal/notification/repo/memory"
+	"delayed-notifier/internal/notification/senders"
+	"delayed-notifier/internal/notification/types/domain"
+	"delayed-notifier/internal/notification/types/dto"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/retry"
+	"testing"
+	"time"
+)
+
+// flakyDigestRepo отказывает в записи дайджеста первые failures раз.
+type flakyDigestRepo struct {
+	*memory.Repo
+	failures int
+	calls    int
+}
+
+func (r *flakyDigestRepo) MarkDigestSent(ctx context.Context, digest domain.Digest, claims map[uuid.UUID]int64) error {
+	r.calls++
+	if r.calls <= r.failures {
+		return errors.New("database is unavailable")
+	}
+	return r.Repo.MarkDigestSent(ctx, digest, claims)
+}
+
+func claimDigestItems(t *testing.T, service *Notification, repo *memory.Repo, count int) map[uuid.UUID]int64 {
+	t.Helper()
+
+	ctx := context.Background()
+	claims := make(map[uuid.UUID]int64, count)
+	for _, ID := range createNotifications(t, repo, count) {
+		claim, claimed, err := service.Claim(ctx, ID.String(), time.Now(), retry.Strategy{Attempts: 1})
+		if err != nil || !claimed {
+			t.Fatalf("Claim: %v, claimed %v", err, claimed)
+		}
+		claims[ID] = claim.Version
+	}
+
+	return claims
+}
+
+func TestMarkDigestSentRetriesWithinLease(t *testing.T) {
+	ctx := context.Background()
+	repo := &flakyDigestRepo{Repo: memory.New(), failures: 2}
+	service := NewNotification(repo, &fakeNotifier{}, cache.NewMemory(cache.Opts{}), senders.New(senders.LogSender{}), Opts{})
+	claims := claimDigestItems(t, service, repo.Repo, 2)
+
+	strategy := retry.Strategy{Attempts: 1, Delay: time.Millisecond, Backoff: 2}
+	leaseUntil := time.Now().Add(time.Minute)
+	if _, err := service.MarkDigestSent(ctx, dto.SendNotification{Channel: "email"}, claims, leaseUntil, strategy); err != nil {
+		t.Fatalf("MarkDigestSent: %v", err)
+	}
+
+	if repo.calls != 3 {
+		t.Fatalf("calls: got %d, want 3", repo.calls)
+	}
+	for ID := range claims {
+		if status, _ := repo.GetStatusByID(ctx, ID); status != domain.Sent {
+			t.Fatalf("status: got %s, want %s", status, domain.Sent)
+		}
+	}
+}
+
+func TestMarkDigestSentGivesUpBeforeLeaseEnds(t *testing.T) {
+	ctx := context.Background()
+	repo := &flakyDigestRepo{Repo: memory.New(), failures: 1000}
+	service := NewNotification(repo, &fakeNotifier{}, cache.NewMemory(cache.Opts{}), senders.New(senders.LogSender{}), Opts{})
+	claims := claimDigestItems(t, service, repo.Repo, 1)
+
+	strategy := retry.Strategy{Attempts: 1, Delay: minDigestMarkDelay, Backoff: 1}
+	leaseUntil := time.Now().Add(digestMarkMargin + 3*minDigestMarkDelay)
+
+	startedAt := time.Now()
+	if _, err := service.MarkDigestSent(ctx, dto.SendNotification{Channel: "email"}, claims, leaseUntil, strategy); err == nil {
+		t.Fatal("MarkDigestSent: want error")
+	}
+
+	if elapsed := time.Since(startedAt); elapsed > 4*minDigestMarkDelay {
+		t.Fatalf("gave up after %s, want before the lease margin", elapsed)
+	}
+	if repo.calls < 2 {
+		t.Fatalf("calls: got %d, want retries until the lease margin", repo.calls)
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/cache"
+	"delayed-notifier/internal/notification/rabbitmq/notifier"
+	"delayed-notifier/internal/notification/repo/memory"
+	"delayed-notifier/internal/notification/senders"
+	"delayed-notifier/internal/notification/types/domain"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/retry"
+	"testing"
+	"time"
+)
+
+// fakeNotifier запоминает опубликованные уведомления и отказывает на failOn.
+type fakeNotifier struct {
+	published []uuid.UUID
+	failOn    map[uuid.UUID]bool
+}
+
+func (f *fakeNotifier) Publish(notification notifier.Message, _ retry.Strategy) error {
+	if f.failOn[notification.ID] {
+		return errors.New("broker is unavailable")
+	}
+	f.published = append(f.published, notification.ID)
+	return nil
+}
+
+func newOutboxService(t *testing.T, pub *fakeNotifier) (*Notification, *memory.Repo) {
+	t.Helper()
+
+	repo := memory.New()
+	service := NewNotification(repo, pub, cache.NewMemory(cache.Opts{}), senders.New(senders.LogSender{}), Opts{})
+
+	return service, repo
+}
+
+func createNotifications(t *testing.T, repo *memory.Repo, count int) []uuid.UUID {
+	t.Helper()
+
+	IDs := make([]uuid.UUID, 0, count)
+	for i := 0; i < count; i++ {
+		notification := domain.Notification{
+			ID:          uuid.New(),
+			Message:     "hello",
+			ScheduledAt: time.Now(),
+			Channel:     domain.Email,
+			Recipient:   "user@example.com",
+		}
+		if err := repo.CreateNotification(context.Background(), notification); err != nil {
+			t.Fatalf("CreateNotification: %v", err)
+		}
+		IDs = append(IDs, notification.ID)
+	}
+
+	return IDs
+}
+
+func TestRelayOutboxPublishesInOrder(t *testing.T) {
+	ctx := context.Background()
+	pub := &fakeNotifier{}
+	service, repo := newOutboxService(t, pub)
+	IDs := createNotifications(t, repo, 3)
+
+	published, err := service.RelayOutbox(ctx, 10, retry.Strategy{Attempts: 1})
+	if err != nil {
+		t.Fatalf("RelayOutbox: %v", err)
+	}
+	if published != 3 || len(pub.published) != 3 {
+		t.Fatalf("published: got %d (%d to broker), want 3", published, len(pub.published))
+	}
+	for i := range IDs {
+		if pub.published[i] != IDs[i] {
+			t.Fatalf("publish order: got %v, want %v", pub.published, IDs)
+		}
+	}
+
+	// Опубликованные записи удалены
+	published, err = service.RelayOutbox(ctx, 10, retry.Strategy{Attempts: 1})
+	if err != nil || published != 0 {
+		t.Fatalf("second pass: published %d, err %v", published, err)
+	}
+}
+
+func TestRelayOutboxStopsOnFailureAndReleasesTheRest(t *testing.T) {
+	ctx := context.Background()
+	pub := &fakeNotifier{}
+	service, repo := newOutboxService(t, pub)
+	IDs := createNotifications(t, repo, 3)
+	pub.failOn = map[uuid.UUID]bool{IDs[1]: true}
+
+	published, err := service.RelayOutbox(ctx, 10, retry.Strategy{Attempts: 1})
+	if err == nil {
+		t.Fatal("RelayOutbox: want publish error")
+	}
+	if published != 1 || len(pub.published) != 1 || pub.published[0] != IDs[0] {
+		t.Fatalf("published: got %d, %v", published, pub.published)
+	}
+
+	// Неопубликованные записи сразу доступны следующему проходу
+	pub.failOn = nil
+	published, err = service.RelayOutbox(ctx, 10, retry.Strategy{Attempts: 1})
+	if err != nil {
+		t.Fatalf("second pass: %v", err)
+	}
+	if published != 2 || pub.published[1] != IDs[1] || pub.published[2] != IDs[2] {
+		t.Fatalf("second pass: published %d, %v", published, pub.published)
+	}
+}
+
+func TestRelayOutboxSkipsClaimedAndCanceled(t *testing.T) {
+	ctx := context.Background()
+	pub := &fakeNotifier{}
+	service, repo := newOutboxService(t, pub)
+	IDs := createNotifications(t, repo, 3)
+
+	// Первую запись забрал другой relay
+	now := time.Now().UTC()
+	if _, err := repo.ClaimOutbox(ctx, 1, now, now.Add(time.Hour)); err != nil {
+		t.Fatalf("ClaimOutbox: %v", err)
+	}
+
+	if _, err := service.Cancel(ctx, IDs[1].String(), "test", "", false, retry.Strategy{Attempts: 1}); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+
+	published, err := service.RelayOutbox(ctx, 10, retry.Strategy{Attempts: 1})
+	if err != nil {
+		t.Fatalf("RelayOutbox: %v", err)
+	}
+
+	// Отменённое уведомление убрано из outbox, но в брокер не ушло
+	if published != 2 || len(pub.published) != 1 || pub.published[0] != IDs[2] {
+		t.Fatalf("published: got %d, %v", published, pub.published)
+	}
+}
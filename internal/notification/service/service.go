@@ -2,17 +2,24 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"delayed-notifier/internal/notification/breaker"
 	"delayed-notifier/internal/notification/rabbitmq/notifier"
 	"delayed-notifier/internal/notification/repo"
 	"delayed-notifier/internal/notification/senders"
 	"delayed-notifier/internal/notification/types/domain"
 	"delayed-notifier/internal/notification/types/dto"
 	"delayed-notifier/pkg/errutils"
+	"delayed-notifier/pkg/senderr"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"github.com/google/uuid"
 	"github.com/wb-go/wbf/redis"
 	"github.com/wb-go/wbf/retry"
 	"github.com/wb-go/wbf/zlog"
+	"golang.org/x/sync/singleflight"
+	"math/rand/v2"
 	"time"
 )
 
@@ -22,13 +29,69 @@ type Notifier interface {
 
 type Repo interface {
 	CreateNotification(ctx context.Context, notification domain.Notification) error
-	GetStatusByID(ctx context.Context, ID uuid.UUID) (domain.NotificationStatus, error)
-	UpdateStatus(ctx context.Context, ID uuid.UUID, status domain.NotificationStatus) error
+	UpdateStatus(ctx context.Context, ID uuid.UUID, version int64, status domain.NotificationStatus) error
+	ClaimForSending(ctx context.Context, ID uuid.UUID, now time.Time, leaseUntil time.Time) (int64, error)
+	GetByID(ctx context.Context, ID uuid.UUID) (domain.Notification, error)
+	DeferNotification(ctx context.Context, ID uuid.UUID, version int64, until time.Time) error
+	DropNotification(ctx context.Context, ID uuid.UUID, version int64) error
+	Reschedule(ctx context.Context, ID uuid.UUID, version int64, scheduledAt time.Time) error
+	ReviveNotification(ctx context.Context, ID uuid.UUID, scheduledAt time.Time) error
+	RecordSendFailure(
+		ctx context.Context,
+		ID uuid.UUID,
+		version int64,
+		retries int,
+		nextRetryAt *time.Time,
+		failure domain.SendFailure,
+	) error
+	MarkDigestSent(ctx context.Context, digest domain.Digest, claims map[uuid.UUID]int64) error
+	CreateNotificationIdempotent(
+		ctx context.Context,
+		key domain.IdempotencyKey,
+		notification domain.Notification,
+		ttl time.Duration,
+	) (domain.IdempotencyKey, bool, error)
+	GetPendingFollowUps(ctx context.Context, parentID uuid.UUID, trigger domain.NotificationStatus) ([]domain.FollowUp, error)
+	CreateFollowUpNotification(ctx context.Context, followUpID uuid.UUID, notification domain.Notification) (bool, error)
+	CancelNotification(
+		ctx context.Context,
+		ID uuid.UUID,
+		cancellation, followUpCancellation domain.Cancellation,
+		followUpsOfFinished bool,
+	) ([]uuid.UUID, error)
+	RecordAttempt(ctx context.Context, ID uuid.UUID) error
+	SaveAttempts(ctx context.Context, attempts []domain.Attempt) error
+	ListAttempts(ctx context.Context, ID uuid.UUID) ([]domain.Attempt, error)
+	FindLost(ctx context.Context, olderThan time.Time, limit int) ([]domain.Notification, error)
+	MarkRepublished(ctx context.Context, ID uuid.UUID) error
+	ClaimOutbox(ctx context.Context, limit int, now time.Time, until time.Time) ([]domain.OutboxEntry, error)
+	DeleteOutbox(ctx context.Context, IDs []int64) error
+	FailOutbox(ctx context.Context, ID int64, lastError string) error
+	ReleaseOutbox(ctx context.Context, IDs []int64) error
 }
 
+// Cache - кэш записей уведомлений для GET. Промах возвращает redis.NoMatches.
 type Cache interface {
-	SetStatusWithRetry(ctx context.Context, id string, status string, strategy retry.Strategy) error
-	GetStatus(ctx context.Context, id string) (string, error)
+	Set(ctx context.Context, notification domain.Notification) error
+	Get(ctx context.Context, id string) (domain.Notification, error)
+	DeleteWithRetry(ctx context.Context, id string, strategy retry.Strategy) error
+}
+
+// Opts - дополнительные настройки сервиса уведомлений.
+type Opts struct {
+	IdempotencyTTL time.Duration // сколько хранится результат запроса с Idempotency-Key
+	SendLease      time.Duration // сколько уведомление в статусе sending принадлежит взявшему его воркеру
+	OutboxLease    time.Duration // сколько пачка outbox принадлежит взявшему её relay
+	SendRetry      SendRetryOpts
+}
+
+// SendRetryOpts - повторы неудачной отправки через брокер. Задержка перед
+// попыткой n равна BaseDelay * 2^(n-1), но не больше MaxDelay, со случайным
+// разбросом в нижнюю половину, чтобы повторы не приходили пачкой.
+type SendRetryOpts struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
 }
 
 type Notification struct {
@@ -36,6 +99,8 @@ type Notification struct {
 	notifier  Notifier
 	cache     Cache
 	senders   senders.NotificationSenders
+	opts      Opts
+	lookups   singleflight.Group // поиск уведомления для GetByID
 }
 
 func NewNotification(
@@ -43,104 +108,788 @@ func NewNotification(
 	notifier Notifier,
 	cache Cache,
 	senders senders.NotificationSenders,
+	opts Opts,
 ) *Notification {
+	if opts.IdempotencyTTL <= 0 {
+		opts.IdempotencyTTL = 24 * time.Hour
+	}
+	if opts.SendLease <= 0 {
+		opts.SendLease = 5 * time.Minute
+	}
+	if opts.OutboxLease <= 0 {
+		opts.OutboxLease = time.Minute
+	}
+	if opts.SendRetry.MaxAttempts <= 0 {
+		opts.SendRetry.MaxAttempts = 1
+	}
+	if opts.SendRetry.BaseDelay <= 0 {
+		opts.SendRetry.BaseDelay = 30 * time.Second
+	}
+	if opts.SendRetry.MaxDelay < opts.SendRetry.BaseDelay {
+		opts.SendRetry.MaxDelay = opts.SendRetry.BaseDelay
+	}
+
 	return &Notification{
 		notifRepo: notifRepo,
 		notifier:  notifier,
 		cache:     cache,
 		senders:   senders,
+		opts:      opts,
 	}
 }
 
 var (
-	ErrNotifNotFound = errors.New("notification not found")
+	ErrNotifNotFound       = errors.New("notification not found")
+	ErrInvalidNotification = errors.New("invalid notification")
+	ErrIdempotencyMismatch = errors.New("idempotency key reused with a different request")
+	ErrInvalidTransition   = errors.New("invalid notification status transition")
+	// ErrClaimLost - аренда воркера истекла, и уведомление взял другой воркер
+	ErrClaimLost = errors.New("notification was claimed by another worker")
+	// ErrStaleDelivery - сообщение устарело: уведомление перенесено на более позднее время
+	ErrStaleDelivery = errors.New("notification was rescheduled after this message")
 )
 
-func (n *Notification) Create(ctx context.Context, notification dto.Notification, strategy retry.Strategy) error {
+// defaultCanceledBy - кем отменено уведомление, если клиент не указал себя.
+const defaultCanceledBy = "api"
+
+const (
+	// digestMarkMargin - запас до конца аренды, после которого запись об отправленном
+	// дайджесте больше не повторяется.
+	digestMarkMargin = 5 * time.Second
+	// minDigestMarkDelay - пауза между повторами записи, если стратегия её не задаёт.
+	minDigestMarkDelay = 100 * time.Millisecond
+)
+
+// ConflictError - операция недопустима в текущем статусе уведомления.
+type ConflictError struct {
+	Status string
+	err    error
+}
+
+func (e *ConflictError) Error() string {
+	return e.err.Error()
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.err
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+func (n *Notification) Create(ctx context.Context, notification dto.Notification) (string, error) {
 	const op = "service.notification.Create"
 
 	domainNotif, err := dtoToDomain(notification)
 	if err != nil {
-		return errutils.Wrap(op, err)
+		return "", errutils.Wrap(op, errors.Join(ErrInvalidNotification, err))
 	}
 	if err := n.notifRepo.CreateNotification(ctx, domainNotif); err != nil {
-		return errutils.Wrap(op, err)
+		return "", errutils.Wrap(op, err)
 	}
 
-	err = n.cache.SetStatusWithRetry(ctx, domainNotif.ID.String(), string(domainNotif.Status), strategy)
+	n.cacheScheduled(ctx, domainNotif)
+
+	return domainNotif.ID.String(), nil
+}
+
+// CreateIdempotent создаёт уведомление не более одного раза на ключ.
+// Повтор с тем же ключом и телом в пределах IdempotencyTTL возвращает ID
+// ранее созданного уведомления и replayed = true; с другим телом -
+// ErrIdempotencyMismatch.
+func (n *Notification) CreateIdempotent(
+	ctx context.Context,
+	key string,
+	notification dto.Notification,
+) (id string, replayed bool, err error) {
+	const op = "service.notification.CreateIdempotent"
+
+	requestHash, err := hashRequest(notification)
 	if err != nil {
-		zlog.Logger.Error().Err(err).Str("id", domainNotif.ID.String()).Msg("failed to cache notification status")
+		return "", false, errutils.Wrap(op, err)
 	}
 
-	message := domainToMessage(domainNotif)
-	if err = n.notifier.Publish(message, strategy); err != nil {
-		return errutils.Wrap(op, err)
+	domainNotif, err := dtoToDomain(notification)
+	if err != nil {
+		return "", false, errutils.Wrap(op, errors.Join(ErrInvalidNotification, err))
 	}
 
-	return nil
+	idempotencyKey := domain.IdempotencyKey{
+		Key:            key,
+		RequestHash:    requestHash,
+		NotificationID: domainNotif.ID,
+	}
+
+	stored, created, err := n.notifRepo.CreateNotificationIdempotent(ctx, idempotencyKey, domainNotif, n.opts.IdempotencyTTL)
+	if err != nil {
+		return "", false, errutils.Wrap(op, err)
+	}
+
+	if !created {
+		if stored.RequestHash != requestHash {
+			return "", false, errutils.Wrap(op, ErrIdempotencyMismatch)
+		}
+		return stored.NotificationID.String(), true, nil
+	}
+
+	n.cacheScheduled(ctx, domainNotif)
+
+	return domainNotif.ID.String(), false, nil
+}
+
+// cacheScheduled кэширует только что созданное уведомление. В брокер
+// уведомление публикует relay по записи outbox, созданной вместе с ним.
+func (n *Notification) cacheScheduled(ctx context.Context, notification domain.Notification) {
+	if err := n.cache.Set(ctx, notification); err != nil {
+		zlog.Logger.Error().Err(err).Str("id", notification.ID.String()).Msg("failed to cache notification")
+	}
+}
+
+// invalidate удаляет запись из кэша после смены статуса: следующий GET перечитает
+// её из базы. Запись не обновляется на месте, чтобы параллельные изменения
+// не перезаписывали друг друга в кэше.
+func (n *Notification) invalidate(ctx context.Context, ID string, strategy retry.Strategy) {
+	if err := n.cache.DeleteWithRetry(ctx, ID, strategy); err != nil {
+		zlog.Logger.Error().Err(err).Str("id", ID).Msg("failed to invalidate cached notification")
+	}
+}
+
+// RelayOutbox публикует в брокер до limit уведомлений из outbox.
+// Пачка захватывается на OutboxLease и публикуется вне транзакции, опубликованные
+// записи удаляются по ID. На первой ошибке проход останавливается, а неопубликованные
+// записи освобождаются для следующего. Если захват истечёт во время публикации,
+// уведомление может быть опубликовано дважды: повтор отсеет ClaimForSending.
+// Возвращает число обработанных записей.
+func (n *Notification) RelayOutbox(ctx context.Context, limit int, strategy retry.Strategy) (int, error) {
+	const op = "service.notification.RelayOutbox"
+
+	now := time.Now().UTC()
+	entries, err := n.notifRepo.ClaimOutbox(ctx, limit, now, now.Add(n.opts.OutboxLease))
+	if err != nil {
+		return 0, errutils.Wrap(op, err)
+	}
+
+	var (
+		published  []int64
+		publishErr error
+	)
+	for _, entry := range entries {
+		// Отменённые до публикации уведомления в брокер не отправляем
+		if entry.Notification.Status == domain.Scheduled {
+			if publishErr = n.notifier.Publish(domainToMessage(entry.Notification), strategy); publishErr != nil {
+				break
+			}
+		}
+		published = append(published, entry.ID)
+	}
+
+	if len(published) > 0 {
+		if err := n.notifRepo.DeleteOutbox(ctx, published); err != nil {
+			// Записи останутся захваченными и будут опубликованы повторно после OutboxLease
+			return 0, errutils.Wrap(op, err)
+		}
+	}
+
+	if publishErr != nil {
+		n.releaseOutbox(ctx, entries[len(published):], publishErr)
+		return len(published), errutils.Wrap(op, publishErr)
+	}
+
+	return len(published), nil
+}
+
+// releaseOutbox отдаёт неопубликованные записи следующему проходу, не дожидаясь
+// конца захвата. Первая из них - та, публикация которой не удалась.
+func (n *Notification) releaseOutbox(ctx context.Context, entries []domain.OutboxEntry, publishErr error) {
+	if err := n.notifRepo.FailOutbox(ctx, entries[0].ID, publishErr.Error()); err != nil {
+		zlog.Logger.Error().Err(err).Int64("outbox_id", entries[0].ID).Msg("failed to record outbox publish failure")
+	}
+
+	rest := make([]int64, 0, len(entries)-1)
+	for _, entry := range entries[1:] {
+		rest = append(rest, entry.ID)
+	}
+	if len(rest) == 0 {
+		return
+	}
+
+	if err := n.notifRepo.ReleaseOutbox(ctx, rest); err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to release outbox entries")
+	}
 }
 
-func (n *Notification) GetStatusByID(ctx context.Context, ID string) (string, error) {
-	const op = "service.notification.GetStatusByID"
+// GetByID отдаёт уведомление из кэша, при промахе читает его из базы и кэширует.
+// Одновременные запросы одного ID выполняют один поиск и получают общий результат.
+func (n *Notification) GetByID(ctx context.Context, ID string) (domain.Notification, error) {
+	const op = "service.notification.GetByID"
+
+	parsedID, err := uuid.Parse(ID)
+	if err != nil {
+		return domain.Notification{}, errutils.Wrap(op, err)
+	}
+
+	// Результат общий, поэтому отмена запроса, начавшего поиск, не должна прерывать его для остальных
+	lookupCtx := context.WithoutCancel(ctx)
+	result, err, _ := n.lookups.Do(ID, func() (interface{}, error) {
+		return n.lookup(lookupCtx, parsedID)
+	})
+	if err != nil {
+		return domain.Notification{}, errutils.Wrap(op, err)
+	}
+
+	notification := result.(domain.Notification)
+	notification.MaxAttempts = n.opts.SendRetry.MaxAttempts
 
-	status, err := n.cache.GetStatus(ctx, ID)
+	return notification, nil
+}
+
+func (n *Notification) lookup(ctx context.Context, ID uuid.UUID) (domain.Notification, error) {
+	notification, err := n.cache.Get(ctx, ID.String())
 	if err == nil {
-		return status, nil
+		return notification, nil
 	}
 	if !errors.Is(err, redis.NoMatches) {
-		zlog.Logger.Error().Err(err).Str("id", ID).Msg("failed to get notification status from cache")
+		zlog.Logger.Error().Err(err).Str("id", ID.String()).Msg("failed to get notification from cache")
+	}
+
+	notification, err = n.notifRepo.GetByID(ctx, ID)
+	if err != nil {
+		return domain.Notification{}, fromRepoErr(err)
+	}
+
+	if err := n.cache.Set(ctx, notification); err != nil {
+		zlog.Logger.Error().Err(err).Str("id", ID.String()).Msg("failed to cache notification")
 	}
 
+	return notification, nil
+}
+
+// SetStatus записывает статус уведомления. version - версия, полученная от Claim:
+// запись из sending проходит, только пока уведомление не взял другой воркер,
+// иначе возвращается ErrClaimLost. Для не взятого в отправку уведомления version равна 0.
+func (n *Notification) SetStatus(ctx context.Context, ID string, version int64, status string, strategy retry.Strategy) error {
+	const op = "service.notification.SetStatus"
+
 	parsedID, err := uuid.Parse(ID)
 	if err != nil {
-		return "", errutils.Wrap(op, err)
+		return errutils.Wrap(op, err)
 	}
 
-	domainStatus, err := n.notifRepo.GetStatusByID(ctx, parsedID)
+	if err := n.notifRepo.UpdateStatus(ctx, parsedID, version, domain.NotificationStatus(status)); err != nil {
+		return errutils.Wrap(op, fromRepoErr(err))
+	}
+
+	n.invalidate(ctx, ID, strategy)
+
+	return nil
+}
+
+// Claim переводит уведомление в статус sending перед отправкой и возвращает его
+// версию, которую нужно передавать в последующие записи, и конец аренды. Возвращает false,
+// если уведомление уже отправлено, отменено или его отправляет другой воркер:
+// тогда отправлять его не нужно. scheduledAt - время из сообщения: если уведомление
+// с тех пор перенесено позже, возвращается ErrStaleDelivery.
+func (n *Notification) Claim(
+	ctx context.Context,
+	ID string,
+	scheduledAt time.Time,
+	strategy retry.Strategy,
+) (domain.Claim, bool, error) {
+	const op = "service.notification.Claim"
+
+	parsedID, err := uuid.Parse(ID)
 	if err != nil {
-		if errors.Is(err, repo.ErrNotifNotFound) {
-			return "", errutils.Wrap(op, ErrNotifNotFound)
+		return domain.Claim{}, false, errutils.Wrap(op, err)
+	}
+
+	// Сообщение, доставленное чуть раньше своего времени из-за расхождения часов,
+	// устаревшим не считается
+	now := time.Now().UTC()
+	due := now
+	if scheduledAt.After(due) {
+		due = scheduledAt.UTC()
+	}
+
+	leaseUntil := now.Add(n.opts.SendLease)
+	version, err := n.notifRepo.ClaimForSending(ctx, parsedID, due, leaseUntil)
+	if err != nil {
+		if errors.Is(err, repo.ErrNotClaimable) {
+			return domain.Claim{}, false, nil
 		}
-		return "", errutils.Wrap(op, err)
+		if errors.Is(err, repo.ErrNotDue) {
+			return domain.Claim{}, false, errutils.Wrap(op, ErrStaleDelivery)
+		}
+		return domain.Claim{}, false, errutils.Wrap(op, fromRepoErr(err))
 	}
 
-	return string(domainStatus), nil
+	n.invalidate(ctx, ID, strategy)
+
+	return domain.Claim{Version: version, LeaseUntil: leaseUntil}, true, nil
 }
 
-func (n *Notification) SetStatus(ctx context.Context, ID string, status string, strategy retry.Strategy) error {
-	const op = "service.notification.SetStatus"
+// Release возвращает взятое воркером уведомление в статус scheduled, чтобы
+// повторная доставка сообщения могла его отправить.
+func (n *Notification) Release(ctx context.Context, ID string, version int64, strategy retry.Strategy) error {
+	const op = "service.notification.Release"
+
+	if err := n.SetStatus(ctx, ID, version, string(domain.Scheduled), strategy); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// Cancel отменяет уведомление вместе с его ещё не сработавшими follow-up'ами.
+// Отменить можно только уведомление в статусе scheduled: для остальных возвращается
+// *ConflictError с текущим статусом, и ничего не меняется. Если уведомление уже
+// завершено и followUps, отменяются только его follow-up'ы, ошибки нет.
+// Сообщение в брокере остаётся, но воркер не сможет взять уведомление в отправку.
+func (n *Notification) Cancel(
+	ctx context.Context,
+	ID string,
+	by string,
+	reason string,
+	followUps bool,
+	strategy retry.Strategy,
+) (domain.CancelResult, error) {
+	const op = "service.notification.Cancel"
 
 	parsedID, err := uuid.Parse(ID)
+	if err != nil {
+		return domain.CancelResult{}, errutils.Wrap(op, err)
+	}
+
+	if by == "" {
+		by = defaultCanceledBy
+	}
+	cancellation := domain.Cancellation{By: by, Reason: reason, At: time.Now().UTC()}
+
+	followUpCancellation := domain.Cancellation{
+		By:     "parent:" + ID,
+		Reason: "parent notification canceled",
+		At:     cancellation.At,
+	}
+	canceled, err := n.notifRepo.CancelNotification(ctx, parsedID, cancellation, followUpCancellation, followUps)
+
+	for _, id := range canceled {
+		n.invalidate(ctx, id.String(), strategy)
+	}
+
+	var transitionErr *repo.TransitionError
+	if err != nil && followUps && errors.As(err, &transitionErr) && repo.IsFinal(transitionErr.From) {
+		return domain.CancelResult{Status: transitionErr.From, FollowUps: canceled}, nil
+	}
+	if err != nil {
+		return domain.CancelResult{}, errutils.Wrap(op, fromRepoErr(err))
+	}
+
+	n.invalidate(ctx, ID, strategy)
+
+	return domain.CancelResult{Status: domain.Canceled, Cancellation: &cancellation, FollowUps: canceled}, nil
+}
+
+// ScheduleFollowUps создаёт и публикует follow-up'ы, ожидавшие статуса outcome
+// у родительского уведомления.
+func (n *Notification) ScheduleFollowUps(ctx context.Context, parentID string, outcome string, strategy retry.Strategy) error {
+	const op = "service.notification.ScheduleFollowUps"
+
+	parsedID, err := uuid.Parse(parentID)
+	if err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	followUps, err := n.notifRepo.GetPendingFollowUps(ctx, parsedID, domain.NotificationStatus(outcome))
+	if err != nil {
+		return errutils.Wrap(op, err)
+	}
+	if len(followUps) == 0 {
+		return nil
+	}
+
+	parent, err := n.notifRepo.GetByID(ctx, parsedID)
 	if err != nil {
 		return errutils.Wrap(op, err)
 	}
 
-	if err := n.notifRepo.UpdateStatus(ctx, parsedID, domain.NotificationStatus(status)); err != nil {
+	now := time.Now().UTC()
+	for _, followUp := range followUps {
+		child := domain.Notification{
+			ID:          uuid.New(),
+			Message:     followUp.Message,
+			ScheduledAt: now.Add(followUp.Delay),
+			Channel:     followUp.Channel,
+			Recipient:   followUp.Recipient,
+			Priority:    parent.Priority,
+			Status:      domain.Scheduled,
+			ParentID:    &parent.ID,
+		}
+
+		created, err := n.notifRepo.CreateFollowUpNotification(ctx, followUp.ID, child)
+		if err != nil {
+			return errutils.Wrap(op, err)
+		}
+		if !created {
+			continue
+		}
+
+		n.cacheScheduled(ctx, child)
+	}
+
+	return nil
+}
+
+// RecordAttempt отмечает, что воркер взял уведомление в работу.
+func (n *Notification) RecordAttempt(ctx context.Context, ID uuid.UUID) error {
+	const op = "service.notification.RecordAttempt"
+
+	if err := n.notifRepo.RecordAttempt(ctx, ID); err != nil {
 		if errors.Is(err, repo.ErrNotifNotFound) {
 			return errutils.Wrap(op, ErrNotifNotFound)
 		}
 		return errutils.Wrap(op, err)
 	}
 
-	if err := n.cache.SetStatusWithRetry(ctx, ID, status, strategy); err != nil {
-		zlog.Logger.Error().Err(err).Str("id", ID).Msg("failed to cache notification status")
+	return nil
+}
+
+// FindLost ищет уведомления, которые должны были уйти раньше olderThan,
+// но так и не дошли до воркеров.
+func (n *Notification) FindLost(ctx context.Context, olderThan time.Time, limit int) ([]domain.Notification, error) {
+	const op = "service.notification.FindLost"
+
+	lost, err := n.notifRepo.FindLost(ctx, olderThan, limit)
+	if err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+
+	return lost, nil
+}
+
+// Republish повторно публикует потерянное уведомление для немедленной отправки.
+func (n *Notification) Republish(ctx context.Context, notification domain.Notification, strategy retry.Strategy) error {
+	const op = "service.notification.Republish"
+
+	if err := n.notifier.Publish(domainToMessage(notification), strategy); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	if err := n.notifRepo.MarkRepublished(ctx, notification.ID); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// RetrySend записывает неудачную попытку отправки и, если ошибка временная
+// и попытки не исчерпаны, публикует уведомление в брокер с задержкой.
+// Возвращает false, если уведомление нужно считать неотправленным.
+func (n *Notification) RetrySend(
+	ctx context.Context,
+	notification notifier.Message,
+	version int64,
+	sendErr error,
+	strategy retry.Strategy,
+) (bool, error) {
+	const op = "service.notification.RetrySend"
+
+	classified := senderr.Classify(sendErr)
+	failure := domain.SendFailure{
+		Class:    string(classified.Class),
+		Error:    sendErr.Error(),
+		Response: classified.Response,
+	}
+
+	attempt := notification.Attempt + 1
+	var nextAt *time.Time
+	if classified.Retryable() && attempt < n.opts.SendRetry.MaxAttempts {
+		delay := n.retryDelay(attempt)
+		if classified.RetryAfter > delay {
+			delay = classified.RetryAfter
+		}
+		at := time.Now().UTC().Add(delay)
+		nextAt = &at
+	}
+
+	if err := n.notifRepo.RecordSendFailure(ctx, notification.ID, version, attempt, nextAt, failure); err != nil {
+		return false, errutils.Wrap(op, fromRepoErr(err))
+	}
+
+	if nextAt == nil {
+		return false, nil
+	}
+
+	ID := notification.ID.String()
+	n.invalidate(ctx, ID, strategy)
+
+	notification.Attempt = attempt
+	notification.ScheduledAt = *nextAt
+	if err := n.notifier.Publish(notification, strategy); err != nil {
+		return false, errutils.Wrap(op, err)
+	}
+
+	return true, nil
+}
+
+// retryDelay - задержка перед попыткой attempt+1 с разбросом.
+func (n *Notification) retryDelay(attempt int) time.Duration {
+	delay := n.opts.SendRetry.MaxDelay
+	if shift := attempt - 1; shift < 32 {
+		if d := n.opts.SendRetry.BaseDelay << shift; d > 0 && d < delay {
+			delay = d
+		}
+	}
+
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// ReplayDeadLetter возвращает уведомление из DLQ в статус scheduled и публикует
+// его заново: в исходное время или в scheduledAt, если оно задано.
+func (n *Notification) ReplayDeadLetter(
+	ctx context.Context,
+	message notifier.Message,
+	scheduledAt *time.Time,
+	strategy retry.Strategy,
+) error {
+	const op = "service.notification.ReplayDeadLetter"
+
+	if scheduledAt != nil {
+		message.ScheduledAt = scheduledAt.UTC()
+	}
+
+	if err := n.notifRepo.ReviveNotification(ctx, message.ID, message.ScheduledAt); err != nil {
+		return errutils.Wrap(op, fromRepoErr(err))
+	}
+
+	n.invalidate(ctx, message.ID.String(), strategy)
+
+	if err := n.notifier.Publish(message, strategy); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// Defer откладывает уведомление, упёршееся в лимит получателя, до until
+// и заново публикует его в планировщик.
+func (n *Notification) Defer(
+	ctx context.Context,
+	notification notifier.Message,
+	version int64,
+	until time.Time,
+	strategy retry.Strategy,
+) error {
+	const op = "service.notification.Defer"
+
+	if err := n.notifRepo.DeferNotification(ctx, notification.ID, version, until); err != nil {
+		return errutils.Wrap(op, fromRepoErr(err))
+	}
+
+	ID := notification.ID.String()
+	n.invalidate(ctx, ID, strategy)
+
+	notification.ScheduledAt = until
+	if err := n.notifier.Publish(notification, strategy); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// Postpone возвращает взятое уведомление в scheduled и публикует его заново на until,
+// не расходуя попытку отправки: используется, пока провайдер канала недоступен.
+func (n *Notification) Postpone(
+	ctx context.Context,
+	notification notifier.Message,
+	version int64,
+	until time.Time,
+	strategy retry.Strategy,
+) error {
+	const op = "service.notification.Postpone"
+
+	until = until.UTC()
+	if err := n.notifRepo.Reschedule(ctx, notification.ID, version, until); err != nil {
+		return errutils.Wrap(op, fromRepoErr(err))
+	}
+
+	ID := notification.ID.String()
+	n.invalidate(ctx, ID, strategy)
+
+	notification.ScheduledAt = until
+	if err := n.notifier.Publish(notification, strategy); err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// Drop отбрасывает уведомление, упёршееся в лимит получателя.
+func (n *Notification) Drop(ctx context.Context, ID string, version int64, strategy retry.Strategy) error {
+	const op = "service.notification.Drop"
+
+	parsedID, err := uuid.Parse(ID)
+	if err != nil {
+		return errutils.Wrap(op, err)
+	}
+
+	if err := n.notifRepo.DropNotification(ctx, parsedID, version); err != nil {
+		return errutils.Wrap(op, fromRepoErr(err))
 	}
 
+	n.invalidate(ctx, ID, strategy)
+
 	return nil
 }
 
-func (n *Notification) Send(notification dto.SendNotification) error {
+// MarkDigestSent сохраняет доставленный дайджест и помечает вошедшие в него
+// уведомления отправленными со ссылкой на дайджест. claims - версии уведомлений от Claim.
+// Запись повторяется, пока до leaseUntil остаётся больше digestMarkMargin:
+// потом уведомления может взять другой воркер.
+func (n *Notification) MarkDigestSent(
+	ctx context.Context,
+	digest dto.SendNotification,
+	claims map[uuid.UUID]int64,
+	leaseUntil time.Time,
+	strategy retry.Strategy,
+) (uuid.UUID, error) {
+	const op = "service.notification.MarkDigestSent"
+
+	domainDigest := domain.Digest{
+		ID:        uuid.New(),
+		Channel:   domain.NotificationChannel(digest.Channel),
+		Recipient: digest.Recipient,
+		Message:   digest.Message,
+	}
+
+	// Дайджест уже отправлен, повторяем только запись о нём. ID дайджеста общий
+	// для всех попыток, поэтому запись, прошедшая до обрыва ответа, не задвоится
+	deadline := leaseUntil.Add(-digestMarkMargin)
+	delay := max(strategy.Delay, minDigestMarkDelay)
+	for {
+		err := n.notifRepo.MarkDigestSent(ctx, domainDigest, claims)
+		if err == nil {
+			break
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return uuid.Nil, errutils.Wrap(op, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return uuid.Nil, errutils.Wrap(op, err)
+		case <-time.After(delay):
+		}
+		if strategy.Backoff > 1 {
+			delay = time.Duration(float64(delay) * strategy.Backoff)
+		}
+	}
+
+	for id := range claims {
+		n.invalidate(ctx, id.String(), strategy)
+	}
+
+	return domainDigest.ID, nil
+}
+
+func (n *Notification) Send(ctx context.Context, notification dto.SendNotification) error {
 	const op = "service.notification.Send"
 
 	channel := domain.NotificationChannel(notification.Channel)
-	if err := n.senders.ForChannel(channel).
-		Send(notification.Message, notification.Recipient); err != nil {
+	startedAt := time.Now()
+	err := n.senders.ForChannel(channel).
+		Send(ctx, notification.Message, notification.Recipient)
+	n.appendAttemptHistory(ctx, notification, startedAt, err)
+	if err != nil {
 		return errutils.Wrap(op, err)
 	}
 
 	return nil
 }
 
+// appendAttemptHistory записывает попытку доставки в историю каждого уведомления отправки.
+// Попыткой не считаются отправка, прерванная остановкой сервиса, и отправка, до
+// провайдера не дошедшая: цепь канала разомкнута или троттлинг недоступен.
+// Ошибка записи только логируется: на доставку она не влияет.
+func (n *Notification) appendAttemptHistory(
+	ctx context.Context,
+	notification dto.SendNotification,
+	startedAt time.Time,
+	sendErr error,
+) {
+	if len(notification.IDs) == 0 || (sendErr != nil && ctx.Err() != nil) {
+		return
+	}
+	if sendErr != nil && (errors.Is(sendErr, breaker.ErrOpen) || senderr.Classify(sendErr).Class == senderr.Throttled) {
+		return
+	}
+
+	attempt := domain.Attempt{
+		AttemptedAt: startedAt.UTC(),
+		Channel:     domain.NotificationChannel(notification.Channel),
+		Recipient:   notification.Recipient,
+		Duration:    time.Since(startedAt),
+		Outcome:     domain.AttemptSent,
+		Digest:      notification.Digest,
+	}
+	if sendErr != nil {
+		classified := senderr.Classify(sendErr)
+		attempt.Outcome = domain.AttemptFailed
+		attempt.ErrorClass = string(classified.Class)
+		attempt.Error = sendErr.Error()
+		attempt.ProviderResponse = classified.Response
+	}
+
+	attempts := make([]domain.Attempt, 0, len(notification.IDs))
+	for _, ID := range notification.IDs {
+		attempt.NotificationID = ID
+		attempts = append(attempts, attempt)
+	}
+
+	if err := n.notifRepo.SaveAttempts(context.WithoutCancel(ctx), attempts); err != nil {
+		zlog.Logger.Error().Err(err).Str("channel", notification.Channel).Msg("failed to save delivery attempts")
+	}
+}
+
+// ListAttempts возвращает историю попыток доставки уведомления.
+func (n *Notification) ListAttempts(ctx context.Context, ID string) ([]domain.Attempt, error) {
+	const op = "service.notification.ListAttempts"
+
+	parsedID, err := uuid.Parse(ID)
+	if err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+
+	attempts, err := n.notifRepo.ListAttempts(ctx, parsedID)
+	if err != nil {
+		return nil, errutils.Wrap(op, err)
+	}
+
+	// Пустая история у несуществующего уведомления - это 404, а не пустой список
+	if len(attempts) == 0 {
+		if _, err := n.notifRepo.GetByID(ctx, parsedID); err != nil {
+			return nil, errutils.Wrap(op, fromRepoErr(err))
+		}
+	}
+
+	return attempts, nil
+}
+
+// fromRepoErr переводит ошибки репозитория в ошибки сервиса.
+func fromRepoErr(err error) error {
+	var transitionErr *repo.TransitionError
+	switch {
+	case errors.Is(err, repo.ErrNotifNotFound):
+		return ErrNotifNotFound
+	case errors.Is(err, repo.ErrNotClaimable):
+		return ErrClaimLost
+	case errors.As(err, &transitionErr):
+		return &ConflictError{Status: string(transitionErr.From), err: err}
+	default:
+		return err
+	}
+}
+
 func domainToMessage(notification domain.Notification) notifier.Message {
 	message := notifier.Message{
 		ID:          notification.ID,
@@ -148,38 +897,140 @@ func domainToMessage(notification domain.Notification) notifier.Message {
 		ScheduledAt: notification.ScheduledAt,
 		Channel:     string(notification.Channel),
 		Recipient:   notification.Recipient,
+		ExpiresAt:   notification.ExpiresAt,
+		Digest:      notification.Digest,
+		Priority:    string(notification.Priority),
 	}
 
 	return message
 }
 
 func dtoToDomain(dto dto.Notification) (domain.Notification, error) {
-	var domainCh domain.NotificationChannel
-	if dto.Channel == "telegram" {
-		domainCh = domain.Telegram
-	} else {
-		domainCh = domain.Email
+	domainCh := channelFromDTO(dto.Channel)
+
+	scheduledAt, err := parseTime(dto.ScheduledAt)
+	if err != nil {
+		return domain.Notification{}, err
 	}
 
-	parsedTime, err := time.Parse(time.RFC3339, dto.ScheduledAt)
+	expiresAt, err := expiryFromDTO(dto, scheduledAt)
 	if err != nil {
-		location, locErr := time.LoadLocation("Europe/Moscow")
-		if locErr != nil {
-			return domain.Notification{}, locErr
-		}
-		parsedTime, err = time.ParseInLocation(time.DateTime, dto.ScheduledAt, location)
+		return domain.Notification{}, err
+	}
+
+	priority := domain.NotificationPriority(dto.Priority)
+	if priority == "" {
+		priority = domain.Normal
+	}
+
+	followUps := make([]domain.FollowUp, 0, len(dto.FollowUps))
+	for _, f := range dto.FollowUps {
+		delay, err := time.ParseDuration(f.Delay)
 		if err != nil {
 			return domain.Notification{}, err
 		}
-	}
+		if delay < 0 {
+			return domain.Notification{}, errors.New("follow-up delay must not be negative")
+		}
+
+		channel := domainCh
+		if f.Channel != "" {
+			channel = channelFromDTO(f.Channel)
+		}
+
+		recipient := dto.Recipient
+		if f.Recipient != "" {
+			recipient = f.Recipient
+		}
 
-	parsedTime = parsedTime.UTC()
+		followUps = append(followUps, domain.FollowUp{
+			ID:        uuid.New(),
+			Trigger:   domain.NotificationStatus(f.On),
+			Delay:     delay,
+			Message:   f.Message,
+			Channel:   channel,
+			Recipient: recipient,
+		})
+	}
 
 	return domain.Notification{
 		ID:          uuid.New(),
 		Message:     dto.Message,
-		ScheduledAt: parsedTime,
+		ScheduledAt: scheduledAt,
 		Channel:     domainCh,
 		Recipient:   dto.Recipient,
+		ExpiresAt:   expiresAt,
+		Digest:      dto.Digest,
+		Priority:    priority,
+		Status:      domain.Scheduled,
+		FollowUps:   followUps,
 	}, nil
 }
+
+func channelFromDTO(channel string) domain.NotificationChannel {
+	if channel == "telegram" {
+		return domain.Telegram
+	}
+	return domain.Email
+}
+
+// expiryFromDTO вычисляет момент, после которого уведомление теряет смысл.
+// Если заданы и expires_at, и max_lateness, берётся более ранний момент.
+func expiryFromDTO(dto dto.Notification, scheduledAt time.Time) (*time.Time, error) {
+	var expiresAt *time.Time
+
+	if dto.ExpiresAt != "" {
+		t, err := parseTime(dto.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		expiresAt = &t
+	}
+
+	if dto.MaxLateness != "" {
+		lateness, err := time.ParseDuration(dto.MaxLateness)
+		if err != nil {
+			return nil, err
+		}
+		if lateness <= 0 {
+			return nil, errors.New("max_lateness must be positive")
+		}
+		t := scheduledAt.Add(lateness)
+		if expiresAt == nil || t.Before(*expiresAt) {
+			expiresAt = &t
+		}
+	}
+
+	if expiresAt != nil && !expiresAt.After(scheduledAt) {
+		return nil, errors.New("expires_at must be after scheduled_at")
+	}
+
+	return expiresAt, nil
+}
+
+// parseTime принимает RFC3339 или "2006-01-02 15:04:05" по московскому времени.
+func parseTime(value string) (time.Time, error) {
+	parsedTime, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		location, locErr := time.LoadLocation("Europe/Moscow")
+		if locErr != nil {
+			return time.Time{}, locErr
+		}
+		parsedTime, err = time.ParseInLocation(time.DateTime, value, location)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return parsedTime.UTC(), nil
+}
+
+func hashRequest(notification dto.Notification) (string, error) {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
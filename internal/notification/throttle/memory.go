@@ -0,0 +1,75 @@
+package throttle
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"sync"
+	"time"
+)
+
+// MemoryThrottle - ограничитель в памяти процесса для режима --backend=memory.
+type MemoryThrottle struct {
+	limits Limits
+
+	mu    sync.Mutex
+	next  map[domain.NotificationChannel]time.Time
+	conns map[domain.NotificationChannel]chan struct{}
+}
+
+func NewMemory(limits Limits) *MemoryThrottle {
+	conns := make(map[domain.NotificationChannel]chan struct{})
+	for channel, limit := range limits {
+		if limit.Concurrency > 0 {
+			conns[channel] = make(chan struct{}, limit.Concurrency)
+		}
+	}
+
+	return &MemoryThrottle{
+		limits: limits,
+		next:   make(map[domain.NotificationChannel]time.Time),
+		conns:  conns,
+	}
+}
+
+func (t *MemoryThrottle) Acquire(ctx context.Context, channel domain.NotificationChannel) (func(), error) {
+	limit, ok := t.limits[channel]
+	if !ok {
+		return noop, nil
+	}
+
+	release := noop
+	if conns, ok := t.conns[channel]; ok {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case conns <- struct{}{}:
+		}
+		release = func() { <-conns }
+	}
+
+	if limit.PerSecond > 0 {
+		if err := sleep(ctx, t.reserve(channel, limit.PerSecond)); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	return release, nil
+}
+
+// reserve занимает ближайший свободный слот и возвращает, сколько до него ждать.
+func (t *MemoryThrottle) reserve(channel domain.NotificationChannel, perSecond float64) time.Duration {
+	interval := time.Duration(float64(time.Second) / perSecond)
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	at := t.next[channel]
+	if at.Before(now) {
+		at = now
+	}
+	t.next[channel] = at.Add(interval)
+
+	return at.Sub(now)
+}
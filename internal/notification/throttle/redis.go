@@ -0,0 +1,151 @@
+package throttle
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"delayed-notifier/pkg/errutils"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/redis"
+	"github.com/wb-go/wbf/zlog"
+	"time"
+)
+
+// gcraScript - GCRA: хранит теоретическое время следующей отправки (TAT).
+// Возвращает 0, если отправка разрешена, иначе сколько миллисекунд подождать.
+var gcraScript = goredis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+
+if tat > now then
+	return math.ceil(tat - now)
+end
+
+redis.call('SET', KEYS[1], tat + interval, 'PX', math.ceil(interval) + 1000)
+return 0
+`)
+
+// acquireScript - семафор на ZSET: score - момент истечения аренды соединения,
+// чтобы слоты упавших инстансов освобождались сами.
+var acquireScript = goredis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call('ZADD', KEYS[1], ARGV[3], ARGV[4])
+	return 1
+end
+return 0
+`)
+
+// Throttle - ограничитель пропускной способности провайдеров, общий для всех инстансов.
+type Throttle struct {
+	client    *redis.Client
+	limits    Limits
+	keyPrefix string
+	lease     time.Duration
+}
+
+func New(client *redis.Client, limits Limits, keyPrefix string, lease time.Duration) *Throttle {
+	if keyPrefix == "" {
+		keyPrefix = "notifier:throttle"
+	}
+	if lease <= 0 {
+		lease = time.Minute
+	}
+	return &Throttle{client: client, limits: limits, keyPrefix: keyPrefix, lease: lease}
+}
+
+func (t *Throttle) Acquire(ctx context.Context, channel domain.NotificationChannel) (func(), error) {
+	limit, ok := t.limits[channel]
+	if !ok {
+		return noop, nil
+	}
+
+	release := noop
+	if limit.Concurrency > 0 {
+		var err error
+		release, err = t.acquireConn(ctx, channel, limit.Concurrency)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if limit.PerSecond > 0 {
+		if err := t.waitRate(ctx, channel, limit.PerSecond); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	return release, nil
+}
+
+func (t *Throttle) waitRate(ctx context.Context, channel domain.NotificationChannel, perSecond float64) error {
+	key := t.keyPrefix + ":" + string(channel) + ":rate"
+	interval := 1000 / perSecond
+
+	for {
+		wait, err := gcraScript.Run(ctx, t.client.Client, []string{key}, time.Now().UnixMilli(), interval).Int64()
+		if err != nil {
+			return errutils.Wrap("failed to check send rate", err)
+		}
+		if wait == 0 {
+			return nil
+		}
+
+		if err := sleep(ctx, time.Duration(wait)*time.Millisecond); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *Throttle) acquireConn(ctx context.Context, channel domain.NotificationChannel, max int) (func(), error) {
+	key := t.keyPrefix + ":" + string(channel) + ":conns"
+	token := uuid.NewString()
+
+	for {
+		now := time.Now()
+		ok, err := acquireScript.Run(
+			ctx,
+			t.client.Client,
+			[]string{key},
+			now.UnixMilli(),
+			max,
+			now.Add(t.lease).UnixMilli(),
+			token,
+		).Int()
+		if err != nil {
+			return nil, errutils.Wrap("failed to acquire provider connection", err)
+		}
+		if ok == 1 {
+			break
+		}
+
+		if err := sleep(ctx, pollInterval); err != nil {
+			return nil, err
+		}
+	}
+
+	return func() {
+		// Освобождаем слот даже если контекст отправки уже отменён.
+		if err := t.client.ZRem(context.Background(), key, token).Err(); err != nil {
+			zlog.Logger.Error().Err(err).Str("channel", string(channel)).Msg("failed to release provider connection")
+		}
+	}, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,62 @@
+package throttle
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/wb-go/wbf/redis"
+	"testing"
+	"time"
+)
+
+func newRedisThrottle(t *testing.T, limits Limits, lease time.Duration) (*Throttle, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := &redis.Client{Client: goredis.NewClient(&goredis.Options{Addr: server.Addr()})}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return New(client, limits, "", lease), server
+}
+
+func TestRedisThrottle(t *testing.T) {
+	t.Run("unlimited channel", func(t *testing.T) {
+		throttle, _ := newRedisThrottle(t, Limits{domain.Email: {Concurrency: 1}}, 0)
+		testUnlimitedChannel(t, throttle)
+	})
+	t.Run("concurrency", func(t *testing.T) {
+		throttle, _ := newRedisThrottle(t, Limits{domain.Email: {Concurrency: 1}}, 0)
+		testConcurrency(t, throttle)
+	})
+	t.Run("rate", func(t *testing.T) {
+		throttle, _ := newRedisThrottle(t, Limits{domain.Email: {PerSecond: 20}}, 0)
+		testRate(t, throttle, 20)
+	})
+
+	t.Run("expired lease frees the connection", func(t *testing.T) {
+		throttle, _ := newRedisThrottle(t, Limits{domain.Email: {Concurrency: 1}}, 50*time.Millisecond)
+
+		// Инстанс упал, не освободив соединение
+		if _, err := throttle.Acquire(context.Background(), domain.Email); err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		release, err := throttle.Acquire(ctx, domain.Email)
+		if err != nil {
+			t.Fatalf("Acquire after lease expiry: %v", err)
+		}
+		release()
+	})
+
+	t.Run("unavailable redis", func(t *testing.T) {
+		throttle, server := newRedisThrottle(t, Limits{domain.Email: {Concurrency: 1}}, 0)
+		server.Close()
+
+		if _, err := throttle.Acquire(context.Background(), domain.Email); err == nil {
+			t.Fatal("Acquire: want error")
+		}
+	})
+}
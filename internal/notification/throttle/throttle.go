@@ -0,0 +1,79 @@
+package throttle
+
+import (
+	"delayed-notifier/internal/notification/types/domain"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit - ограничения провайдера одного канала. Нулевое значение - без ограничения.
+type Limit struct {
+	PerSecond   float64 // сообщений в секунду
+	Concurrency int     // одновременных соединений
+}
+
+type Limits map[domain.NotificationChannel]Limit
+
+var ErrInvalidLimits = errors.New("invalid throttle limits")
+
+// pollInterval - как часто ожидающий воркер повторно пытается занять соединение.
+const pollInterval = 50 * time.Millisecond
+
+// ParseLimits собирает лимиты из строк вида "email:14,telegram:30" (сообщений в секунду)
+// и "email:5" (одновременных соединений).
+func ParseLimits(rates, concurrency string) (Limits, error) {
+	limits := make(Limits)
+
+	if err := parseChannelValues(rates, func(channel domain.NotificationChannel, value string) error {
+		perSecond, err := strconv.ParseFloat(value, 64)
+		if err != nil || perSecond <= 0 {
+			return fmt.Errorf("%w: bad rate %q", ErrInvalidLimits, value)
+		}
+		limit := limits[channel]
+		limit.PerSecond = perSecond
+		limits[channel] = limit
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := parseChannelValues(concurrency, func(channel domain.NotificationChannel, value string) error {
+		conns, err := strconv.Atoi(value)
+		if err != nil || conns <= 0 {
+			return fmt.Errorf("%w: bad concurrency %q", ErrInvalidLimits, value)
+		}
+		limit := limits[channel]
+		limit.Concurrency = conns
+		limits[channel] = limit
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return limits, nil
+}
+
+func parseChannelValues(value string, fn func(channel domain.NotificationChannel, value string) error) error {
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		channel, v, ok := strings.Cut(part, ":")
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrInvalidLimits, part)
+		}
+
+		if err := fn(domain.NotificationChannel(strings.TrimSpace(channel)), strings.TrimSpace(v)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func noop() {}
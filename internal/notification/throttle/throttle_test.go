@@ -0,0 +1,105 @@
+package throttle
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/types/domain"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits("email:14, telegram:30", "email:5")
+	if err != nil {
+		t.Fatalf("ParseLimits: %v", err)
+	}
+
+	if got := limits[domain.Email]; got.PerSecond != 14 || got.Concurrency != 5 {
+		t.Fatalf("email: got %+v", got)
+	}
+	if got := limits[domain.Telegram]; got.PerSecond != 30 || got.Concurrency != 0 {
+		t.Fatalf("telegram: got %+v", got)
+	}
+
+	for _, tc := range []struct{ rates, concurrency string }{
+		{"email", ""},
+		{"email:fast", ""},
+		{"email:-1", ""},
+		{"", "email:0"},
+	} {
+		if _, err := ParseLimits(tc.rates, tc.concurrency); !errors.Is(err, ErrInvalidLimits) {
+			t.Fatalf("ParseLimits(%q, %q): got %v, want ErrInvalidLimits", tc.rates, tc.concurrency, err)
+		}
+	}
+}
+
+// acquirer - общий интерфейс ограничителей в памяти и на Redis.
+type acquirer interface {
+	Acquire(ctx context.Context, channel domain.NotificationChannel) (func(), error)
+}
+
+func testUnlimitedChannel(t *testing.T, throttle acquirer) {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		release, err := throttle.Acquire(context.Background(), domain.Telegram)
+		if err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+		release()
+	}
+}
+
+func testConcurrency(t *testing.T, throttle acquirer) {
+	t.Helper()
+
+	release, err := throttle.Acquire(context.Background(), domain.Email)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	// Единственное соединение занято: ожидание прерывается контекстом
+	ctx, cancel := context.WithTimeout(context.Background(), 3*pollInterval)
+	defer cancel()
+	if _, err := throttle.Acquire(ctx, domain.Email); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire over concurrency: got %v, want DeadlineExceeded", err)
+	}
+
+	release()
+	next, err := throttle.Acquire(context.Background(), domain.Email)
+	if err != nil {
+		t.Fatalf("Acquire after release: %v", err)
+	}
+	next()
+}
+
+func testRate(t *testing.T, throttle acquirer, perSecond float64) {
+	t.Helper()
+
+	startedAt := time.Now()
+	for i := 0; i < 3; i++ {
+		release, err := throttle.Acquire(context.Background(), domain.Email)
+		if err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+		release()
+	}
+
+	// Первая отправка сразу, две следующие - с интервалом 1/perSecond
+	interval := time.Duration(float64(time.Second) / perSecond)
+	if elapsed := time.Since(startedAt); elapsed < 2*interval-10*time.Millisecond {
+		t.Fatalf("3 sends took %s, want at least %s", elapsed, 2*interval)
+	}
+}
+
+func TestMemoryThrottle(t *testing.T) {
+	t.Run("unlimited channel", func(t *testing.T) {
+		testUnlimitedChannel(t, NewMemory(Limits{domain.Email: {Concurrency: 1}}))
+	})
+	t.Run("concurrency", func(t *testing.T) {
+		testConcurrency(t, NewMemory(Limits{domain.Email: {Concurrency: 1}}))
+	})
+	t.Run("rate", func(t *testing.T) {
+		testRate(t, NewMemory(Limits{domain.Email: {PerSecond: 20}}), 20)
+	})
+}
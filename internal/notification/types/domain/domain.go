@@ -13,25 +13,166 @@ const (
 	Telegram NotificationChannel = "telegram"
 )
 
+// NotificationPriority - enum для приоритетов уведомлений
+type NotificationPriority string
+
+const (
+	Low      NotificationPriority = "low"
+	Normal   NotificationPriority = "normal"
+	High     NotificationPriority = "high"
+	Critical NotificationPriority = "critical"
+)
+
+// MaxPriorityLevel - наибольший уровень, который возвращает Level
+const MaxPriorityLevel = 3
+
+// Level - числовой уровень приоритета, чем больше, тем раньше отправка.
+// Неизвестный приоритет считается обычным.
+func (p NotificationPriority) Level() uint8 {
+	switch p {
+	case Low:
+		return 0
+	case High:
+		return 2
+	case Critical:
+		return 3
+	default:
+		return 1
+	}
+}
+
 // NotificationStatus - enum для статусов уведомлений
 type NotificationStatus string
 
 const (
 	Scheduled NotificationStatus = "scheduled"
+	Sending   NotificationStatus = "sending" // воркер взял уведомление и отправляет его
 	Sent      NotificationStatus = "sent"
 	Canceled  NotificationStatus = "canceled"
 	Failed    NotificationStatus = "failed"
+	Expired   NotificationStatus = "expired"
+	Dropped   NotificationStatus = "dropped"
+)
+
+// RateLimitDecision - решение лимитера по получателю, записанное на уведомлении
+type RateLimitDecision string
+
+const (
+	RateLimitDeferred RateLimitDecision = "deferred"
+	RateLimitDropped  RateLimitDecision = "dropped"
 )
 
 // Notification - структура уведомления
 type Notification struct {
-	ID          uuid.UUID
-	Message     string
-	ScheduledAt time.Time
-	Retries     int // TODO: Убрать поле
-	Channel     NotificationChannel
-	Recipient   string
-	Status      NotificationStatus
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID            uuid.UUID
+	Message       string
+	ScheduledAt   time.Time
+	Retries       int        // сколько попыток отправки уже не удалось
+	MaxAttempts   int        // сколько всего попыток отправки даётся уведомлению
+	NextRetryAt   *time.Time // когда будет следующая попытка после неудачной
+	LastFailure   *SendFailure
+	Channel       NotificationChannel
+	Recipient     string
+	Priority      NotificationPriority
+	ExpiresAt     *time.Time // после этого момента уведомление не отправляется
+	Status        NotificationStatus
+	Version       int64      // растёт при каждой смене статуса, для условных обновлений
+	LeaseUntil    *time.Time // до какого момента статус sending принадлежит воркеру
+	RateLimit     RateLimitDecision
+	DeferredUntil *time.Time
+	Digest        bool       // можно объединять с другими уведомлениями получателя
+	DigestID      *uuid.UUID // дайджест, которым уведомление было доставлено
+	ParentID      *uuid.UUID // уведомление, по итогу которого создано это
+	FollowUps     []FollowUp
+	Cancellation  *Cancellation
+	LastAttemptAt *time.Time // когда воркер последний раз взял уведомление в работу
+	RepublishedAt *time.Time // когда сверка последний раз переопубликовала уведомление
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// SendFailure - неудачная попытка отправки
+type SendFailure struct {
+	Class    string // transient, permanent, rate_limited, invalid_recipient
+	Error    string
+	Response string // ответ провайдера, для поддержки
+}
+
+// AttemptOutcome - enum для итогов попытки доставки
+type AttemptOutcome string
+
+const (
+	AttemptSent   AttemptOutcome = "sent"
+	AttemptFailed AttemptOutcome = "failed"
+)
+
+// Attempt - попытка доставки уведомления провайдеру
+type Attempt struct {
+	NotificationID   uuid.UUID
+	AttemptedAt      time.Time
+	Channel          NotificationChannel
+	Recipient        string
+	Duration         time.Duration
+	Outcome          AttemptOutcome
+	ErrorClass       string // пусто у успешной попытки
+	Error            string
+	ProviderResponse string
+	Digest           bool // уведомление доставлялось в составе дайджеста
+}
+
+// Cancellation - кто, когда и почему отменил уведомление
+type Cancellation struct {
+	By     string
+	Reason string
+	At     time.Time
+}
+
+// CancelResult - итог запроса на отмену
+type CancelResult struct {
+	Status       NotificationStatus // статус уведомления после запроса
+	Cancellation *Cancellation      // nil, если уведомление уже было завершено
+	FollowUps    []uuid.UUID        // отменённые follow-up'ы
+}
+
+// Claim - взятие уведомления в отправку: версия после взятия и конец аренды воркера
+type Claim struct {
+	Version    int64
+	LeaseUntil time.Time
+}
+
+// Digest - одно сообщение, которым доставлены несколько уведомлений получателя
+type Digest struct {
+	ID        uuid.UUID
+	Channel   NotificationChannel
+	Recipient string
+	Message   string
+	CreatedAt time.Time
+}
+
+// OutboxEntry - запись outbox: уведомление, ещё не опубликованное в брокер
+type OutboxEntry struct {
+	ID           int64
+	Notification Notification
+}
+
+// IdempotencyKey - результат запроса на создание, сохранённый под ключом клиента
+type IdempotencyKey struct {
+	Key            string
+	RequestHash    string
+	NotificationID uuid.UUID
+	CreatedAt      time.Time
+}
+
+// FollowUp - уведомление, которое создаётся, когда родитель получает статус Trigger,
+// и отправляется через Delay после этого
+type FollowUp struct {
+	ID             uuid.UUID
+	ParentID       uuid.UUID
+	Trigger        NotificationStatus
+	Delay          time.Duration
+	Message        string
+	Channel        NotificationChannel
+	Recipient      string
+	NotificationID *uuid.UUID // созданное уведомление, nil пока не сработал
+	Canceled       bool
 }
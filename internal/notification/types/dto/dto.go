@@ -1,10 +1,30 @@
 package dto
 
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
 type Notification struct {
-	Message     string `json:"message" validate:"required"`
-	ScheduledAt string `json:"scheduled_at" validate:"required"`
-	Channel     string `json:"channel" validate:"required"`
-	Recipient   string `json:"recipient" validate:"required"`
+	Message     string     `json:"message" validate:"required"`
+	ScheduledAt string     `json:"scheduled_at" validate:"required"`
+	Channel     string     `json:"channel" validate:"required"`
+	Recipient   string     `json:"recipient" validate:"required"`
+	ExpiresAt   string     `json:"expires_at,omitempty"`
+	MaxLateness string     `json:"max_lateness,omitempty"`
+	Digest      bool       `json:"digest,omitempty"`
+	Priority    string     `json:"priority,omitempty" validate:"omitempty,oneof=low normal high critical"`
+	FollowUps   []FollowUp `json:"follow_ups,omitempty" validate:"omitempty,dive"`
+}
+
+// FollowUp - уведомление, отправляемое через delay после того, как родитель
+// получит статус on. Канал и получатель по умолчанию берутся у родителя.
+type FollowUp struct {
+	On        string `json:"on" validate:"required,oneof=sent failed"`
+	Delay     string `json:"delay" validate:"required"`
+	Message   string `json:"message" validate:"required"`
+	Channel   string `json:"channel,omitempty"`
+	Recipient string `json:"recipient,omitempty"`
 }
 
 type SendNotification struct {
@@ -12,4 +32,121 @@ type SendNotification struct {
 	ScheduledAt string
 	Channel     string
 	Recipient   string
+	IDs         []uuid.UUID // уведомления, которые доставляет отправка, для истории попыток
+	Digest      bool
+}
+
+type CreatedNotification struct {
+	ID string `json:"id"`
+}
+
+// CancelNotification - необязательное тело запроса на отмену.
+// CancelFollowUps разрешает отменить follow-up'ы уже завершённого уведомления.
+type CancelNotification struct {
+	Reason          string `json:"reason,omitempty" validate:"omitempty,max=500"`
+	CanceledBy      string `json:"canceled_by,omitempty" validate:"omitempty,max=100"`
+	CancelFollowUps bool   `json:"cancel_follow_ups,omitempty"`
+}
+
+type Cancellation struct {
+	Reason     string    `json:"reason,omitempty"`
+	CanceledBy string    `json:"canceled_by"`
+	CanceledAt time.Time `json:"canceled_at"`
+}
+
+// CanceledNotification - итог отмены. Для завершённого уведомления, у которого
+// отменены только follow-up'ы, Status - его конечный статус, Cancellation нет.
+type CanceledNotification struct {
+	ID                string        `json:"id"`
+	Status            string        `json:"status"`
+	Cancellation      *Cancellation `json:"cancellation,omitempty"`
+	CanceledFollowUps []string      `json:"canceled_follow_ups"`
+}
+
+// CancelConflict - отмена невозможна: уведомление уже отправлено, отправляется или завершено.
+type CancelConflict struct {
+	Error  string `json:"error"`
+	Status string `json:"status"`
+}
+
+type NotificationStatus struct {
+	ID            string        `json:"id"`
+	Status        string        `json:"status"`
+	Channel       string        `json:"channel"`
+	Recipient     string        `json:"recipient"`
+	Priority      string        `json:"priority"`
+	ScheduledAt   time.Time     `json:"scheduled_at"`
+	ExpiresAt     *time.Time    `json:"expires_at,omitempty"`
+	RateLimit     string        `json:"rate_limit,omitempty"`
+	DeferredUntil *time.Time    `json:"deferred_until,omitempty"`
+	Digest        bool          `json:"digest,omitempty"`
+	DigestID      string        `json:"digest_id,omitempty"`
+	ParentID      string        `json:"parent_id,omitempty"`
+	Retry         *RetryStatus  `json:"retry,omitempty"`
+	LastFailure   *SendFailure  `json:"last_failure,omitempty"`
+	Cancellation  *Cancellation `json:"cancellation,omitempty"`
+}
+
+// SendFailure - последняя неудачная попытка отправки.
+type SendFailure struct {
+	Class    string `json:"class"`
+	Error    string `json:"error"`
+	Response string `json:"provider_response,omitempty"`
+}
+
+// Attempt - попытка доставки уведомления провайдеру.
+type Attempt struct {
+	AttemptedAt      time.Time `json:"attempted_at"`
+	Channel          string    `json:"channel"`
+	Recipient        string    `json:"recipient"`
+	DurationMs       int64     `json:"duration_ms"`
+	Outcome          string    `json:"outcome"`
+	ErrorClass       string    `json:"error_class,omitempty"`
+	Error            string    `json:"error,omitempty"`
+	ProviderResponse string    `json:"provider_response,omitempty"`
+	Digest           bool      `json:"digest,omitempty"`
+}
+
+// RetryStatus - состояние повторов отправки: Attempt - номер следующей попытки
+// для ожидающего уведомления или последней - для завершённого.
+type RetryStatus struct {
+	Attempt       int        `json:"attempt"`
+	MaxAttempts   int        `json:"max_attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+type DeadLetter struct {
+	ID           string                  `json:"id"`
+	Reason       string                  `json:"reason,omitempty"`
+	Error        string                  `json:"error,omitempty"` // причина карантина
+	Queue        string                  `json:"queue,omitempty"`
+	Count        int64                   `json:"count,omitempty"`
+	DiedAt       *time.Time              `json:"died_at,omitempty"`
+	Notification *DeadLetterNotification `json:"notification,omitempty"`
+	Body         string                  `json:"body,omitempty"` // только если тело не удалось разобрать
+}
+
+type DeadLetterNotification struct {
+	ID          string    `json:"id"`
+	Message     string    `json:"message"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	Channel     string    `json:"channel"`
+	Recipient   string    `json:"recipient"`
+	Priority    string    `json:"priority,omitempty"`
+}
+
+// ReplayDeadLetters - какие сообщения DLQ опубликовать заново. Без scheduled_at
+// уведомления уходят в исходное время (прошедшее - немедленно).
+type ReplayDeadLetters struct {
+	IDs         []string `json:"ids" validate:"required,min=1"`
+	ScheduledAt string   `json:"scheduled_at,omitempty"`
+}
+
+type ReplayedDeadLetters struct {
+	Replayed []string          `json:"replayed"`
+	Failed   map[string]string `json:"failed,omitempty"`
+}
+
+type PurgedDeadLetters struct {
+	Purged int `json:"purged"`
 }
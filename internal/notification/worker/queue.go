@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/rabbitmq/notifier"
+	"delayed-notifier/internal/notification/types/domain"
+	"sync"
+)
+
+// priorityQueue - ограниченная очередь между консьюмером и воркерами.
+// Pop всегда отдаёт самое старое сообщение наивысшего приоритета.
+type priorityQueue struct {
+	mu       sync.Mutex
+	levels   [domain.MaxPriorityLevel + 1][]notifier.Delivery
+	size     int
+	capacity int
+
+	notEmpty chan struct{}
+	notFull  chan struct{}
+}
+
+func newPriorityQueue(capacity int) *priorityQueue {
+	return &priorityQueue{
+		capacity: capacity,
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+	}
+}
+
+// Push кладёт сообщение в очередь, ожидая освобождения места.
+func (q *priorityQueue) Push(ctx context.Context, notification notifier.Delivery) bool {
+	level := domain.NotificationPriority(notification.Priority).Level()
+
+	for {
+		q.mu.Lock()
+		if q.size < q.capacity {
+			q.levels[level] = append(q.levels[level], notification)
+			q.size++
+			q.mu.Unlock()
+			signal(q.notEmpty)
+			return true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-q.notFull:
+		}
+	}
+}
+
+// Pop забирает следующее сообщение, ожидая его появления.
+func (q *priorityQueue) Pop(ctx context.Context) (notifier.Delivery, bool) {
+	for {
+		q.mu.Lock()
+		for level := len(q.levels) - 1; level >= 0; level-- {
+			if len(q.levels[level]) == 0 {
+				continue
+			}
+
+			notification := q.levels[level][0]
+			q.levels[level][0] = notifier.Delivery{}
+			q.levels[level] = q.levels[level][1:]
+			q.size--
+			remaining := q.size
+			q.mu.Unlock()
+
+			signal(q.notFull)
+			if remaining > 0 {
+				// Будим следующего ожидающего воркера
+				signal(q.notEmpty)
+			}
+			return notification, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return notifier.Delivery{}, false
+		case <-q.notEmpty:
+		}
+	}
+}
+
+// Drain забирает все оставшиеся сообщения без ожидания.
+func (q *priorityQueue) Drain() []notifier.Delivery {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var rest []notifier.Delivery
+	for level := len(q.levels) - 1; level >= 0; level-- {
+		rest = append(rest, q.levels[level]...)
+		q.levels[level] = nil
+	}
+	q.size = 0
+
+	return rest
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
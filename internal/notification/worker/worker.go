@@ -6,76 +6,179 @@ import (
 	"github.com/wb-go/wbf/retry"
 	"github.com/wb-go/wbf/zlog"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type NotifConsumer interface {
-	Consume(ctx context.Context, notifications chan notifier.Message, strategy retry.Strategy) error
+	Consume(ctx context.Context, deliveries chan notifier.Delivery, strategy retry.Strategy) error
 }
 
+// NotifHandler обрабатывает сообщение и сам подтверждает его брокеру.
 type NotifHandler interface {
-	HandleNotif(ctx context.Context, notification notifier.Message, strategy retry.Strategy)
+	HandleNotif(ctx context.Context, delivery notifier.Delivery, strategy retry.Strategy)
 }
 
-type Notification interface {
-	GetStatusByID(ctx context.Context, id string) (string, error)
+// queueFactor - размер приоритетной очереди на одного воркера.
+const queueFactor = 15
+
+// cancelGrace - сколько Drain ждёт воркеров после прерывания обработки по сроку.
+const cancelGrace = 5 * time.Second
+
+// Capacity - сколько неподтверждённых сообщений пул воркеров может держать
+// одновременно: очередь, буфер канала консьюмера и сообщения в обработке.
+// Prefetch брокера - это значение плюс ёмкость буфера дайджестов.
+func Capacity(workers int) int {
+	return workers * (queueFactor + 2)
+}
+
+// DrainReport - итог остановки пула: сколько сообщений в обработке воркеры
+// успели довести до конца и сколько вернули брокеру.
+type DrainReport struct {
+	Drained  int  `json:"drained"`
+	Requeued int  `json:"requeued"`
+	TimedOut bool `json:"timed_out"`
 }
 
 type WorkerPool struct {
 	consumer NotifConsumer
 	handler  NotifHandler
-	notif    Notification
 	workers  int
+
+	// Обработка идёт под отдельным контекстом: после остановки приёма
+	// начатые отправки дорабатывают до истечения срока Drain
+	stopWork context.CancelFunc
+	queue    *priorityQueue
+	consumed chan struct{} // закрывается, когда консьюмер и раскладка по очереди завершены
+	wg       sync.WaitGroup
+
+	inFlight atomic.Int64
+	drained  atomic.Int64
+	requeued atomic.Int64
 }
 
-func NewWorkerPool(consumer NotifConsumer, handler NotifHandler, notif Notification, workers int) *WorkerPool {
+func NewWorkerPool(consumer NotifConsumer, handler NotifHandler, workers int) *WorkerPool {
 	return &WorkerPool{
 		consumer: consumer,
 		handler:  handler,
-		notif:    notif,
 		workers:  workers,
 	}
 }
 
+// Start запускает консьюмер и воркеров и сразу возвращается. Отмена ctx
+// останавливает приём новых сообщений; дождаться воркеров - Drain.
 func (w *WorkerPool) Start(ctx context.Context, strategy retry.Strategy) {
-	notifications := make(chan notifier.Message, w.workers*15)
+	deliveries := make(chan notifier.Delivery, w.workers)
+	w.queue = newPriorityQueue(w.workers * queueFactor)
+	w.consumed = make(chan struct{})
+
+	var workCtx context.Context
+	workCtx, w.stopWork = context.WithCancel(context.WithoutCancel(ctx))
 
+	consumerDone := make(chan struct{})
 	go func() {
-		if err := w.consumer.Consume(ctx, notifications, strategy); err != nil {
+		defer close(consumerDone)
+		if err := w.consumer.Consume(ctx, deliveries, strategy); err != nil {
 			zlog.Logger.Error().Err(err).Msg("failed to consume notifications")
 		}
 	}()
 
-	wg := sync.WaitGroup{}
-	wg.Add(w.workers)
+	// Раскладываем входящие сообщения по приоритетам, чтобы срочные
+	// уведомления не ждали за массовыми, запланированными на ту же минуту.
+	// После отмены ctx всё, что ещё приходит от консьюмера, возвращается брокеру.
+	go func() {
+		defer close(w.consumed)
+		for delivery := range deliveries {
+			if !w.queue.Push(ctx, delivery) {
+				w.requeue(delivery)
+			}
+		}
+		<-consumerDone
+	}()
+
+	w.wg.Add(w.workers)
 	for i := 0; i < w.workers; i++ {
 		go func() {
-			defer wg.Done()
+			defer w.wg.Done()
 
 			for {
-				select {
-				case <-ctx.Done():
+				// Pop отдаёт уже лежащие в очереди сообщения и после отмены ctx,
+				// поэтому проверяем остановку явно: неначатые сообщения вернёт Drain
+				if ctx.Err() != nil {
 					zlog.Logger.Info().Msg("worker shutting down due to canceled context")
 					return
-				case notification, ok := <-notifications:
-					if !ok {
-						zlog.Logger.Info().Msg("worker shutting down due to closed channel")
-						return
-					}
-
-					status, err := w.notif.GetStatusByID(ctx, notification.ID.String())
-					if err != nil {
-						zlog.Logger.Error().Err(err).Str("id", notification.ID.String()).Msg("failed to get notification status by id")
-						continue
-					}
-
-					if status != "canceled" {
-						w.handler.HandleNotif(ctx, notification, strategy)
-					}
 				}
 
+				delivery, ok := w.queue.Pop(ctx)
+				if !ok {
+					zlog.Logger.Info().Msg("worker shutting down due to canceled context")
+					return
+				}
+
+				w.process(ctx, workCtx, delivery, strategy)
 			}
 		}()
 	}
-	<-ctx.Done()
-	wg.Wait()
+}
+
+// process передаёт сообщение обработчику. Статус заранее не проверяется: отменённые,
+// уже отправленные и повторно доставленные уведомления отсеивает обработчик,
+// забирая уведомление в отправку условным обновлением в базе, а не по кэшу.
+func (w *WorkerPool) process(ctx, workCtx context.Context, delivery notifier.Delivery, strategy retry.Strategy) {
+	w.inFlight.Add(1)
+	w.handler.HandleNotif(workCtx, delivery, strategy)
+	w.inFlight.Add(-1)
+
+	// Обработки, прерванные по сроку, Drain уже посчитал возвращёнными
+	if ctx.Err() != nil && workCtx.Err() == nil {
+		w.drained.Add(1)
+	}
+}
+
+// Drain ждёт, пока воркеры завершат начатые отправки, не дольше срока ctx.
+// По истечении срока обработка прерывается, и сообщения возвращаются брокеру.
+// Вызывать после отмены контекста, переданного в Start.
+func (w *WorkerPool) Drain(ctx context.Context) DrainReport {
+	report := DrainReport{}
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		w.stopWork()
+	case <-ctx.Done():
+		// Прерываем обработку и даём обработчикам вернуть сообщения брокеру,
+		// прежде чем закрывать соединения, которыми они пользуются
+		report.TimedOut = true
+		w.requeued.Add(w.inFlight.Load())
+		w.stopWork()
+
+		select {
+		case <-done:
+		case <-time.After(cancelGrace):
+			// Зависшие отправки не ждём: неподтверждённые сообщения брокер
+			// доставит повторно после закрытия соединения
+			zlog.Logger.Warn().Int64("in_flight", w.inFlight.Load()).Msg("workers did not stop after cancellation")
+		}
+	}
+	<-w.consumed
+
+	// Неразобранные сообщения возвращаем брокеру, а не теряем
+	for _, delivery := range w.queue.Drain() {
+		w.requeue(delivery)
+	}
+
+	report.Drained = int(w.drained.Load())
+	report.Requeued = int(w.requeued.Load())
+
+	return report
+}
+
+func (w *WorkerPool) requeue(delivery notifier.Delivery) {
+	delivery.Requeue()
+	w.requeued.Add(1)
 }
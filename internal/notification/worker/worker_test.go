@@ -0,0 +1,161 @@
+package worker
+
+import (
+	"context"
+	"delayed-notifier/internal/notification/rabbitmq/notifier"
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/retry"
+	"sync"
+	"testing"
+	"time"
+)
+
+// acker запоминает, чем закончилась обработка сообщения.
+type acker struct {
+	mu     sync.Mutex
+	result string
+}
+
+func (a *acker) Ack() error {
+	a.set("ack")
+	return nil
+}
+
+func (a *acker) Nack(requeue bool) error {
+	if requeue {
+		a.set("requeue")
+	} else {
+		a.set("reject")
+	}
+	return nil
+}
+
+func (a *acker) set(result string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.result = result
+}
+
+func (a *acker) get() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.result
+}
+
+// consumer отдаёт заданные сообщения и ждёт остановки, как консьюмер брокера.
+type consumer struct {
+	deliveries []notifier.Delivery
+	sent       chan struct{}
+}
+
+func (c *consumer) Consume(ctx context.Context, deliveries chan notifier.Delivery, _ retry.Strategy) error {
+	defer close(deliveries)
+
+	for _, delivery := range c.deliveries {
+		deliveries <- delivery
+	}
+	close(c.sent)
+	<-ctx.Done()
+
+	return nil
+}
+
+// blockingHandler держит сообщение до release или до отмены обработки:
+// в первом случае подтверждает его, во втором возвращает брокеру.
+type blockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHandler) HandleNotif(ctx context.Context, delivery notifier.Delivery, _ retry.Strategy) {
+	h.started <- struct{}{}
+
+	select {
+	case <-h.release:
+		delivery.Ack()
+	case <-ctx.Done():
+		delivery.Requeue()
+	}
+}
+
+func newDeliveries(count int) ([]notifier.Delivery, []*acker) {
+	deliveries := make([]notifier.Delivery, 0, count)
+	ackers := make([]*acker, 0, count)
+	for i := 0; i < count; i++ {
+		a := &acker{}
+		deliveries = append(deliveries, notifier.NewDelivery(notifier.Message{ID: uuid.New()}, a))
+		ackers = append(ackers, a)
+	}
+
+	return deliveries, ackers
+}
+
+// startPool запускает пул из одного воркера и ждёт, пока первое сообщение
+// окажется в обработке, а остальные - в очереди.
+func startPool(t *testing.T, count int) (*WorkerPool, *blockingHandler, []*acker, context.CancelFunc) {
+	t.Helper()
+
+	deliveries, ackers := newDeliveries(count)
+	c := &consumer{deliveries: deliveries, sent: make(chan struct{})}
+	h := &blockingHandler{started: make(chan struct{}, count), release: make(chan struct{})}
+
+	pool := NewWorkerPool(c, h, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	pool.Start(ctx, retry.Strategy{Attempts: 1})
+
+	for _, ch := range []chan struct{}{h.started, c.sent} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("pool did not start processing")
+		}
+	}
+
+	return pool, h, ackers, cancel
+}
+
+func TestDrainWaitsForInFlight(t *testing.T) {
+	pool, h, ackers, cancel := startPool(t, 3)
+	cancel()
+
+	report := make(chan DrainReport, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		report <- pool.Drain(ctx)
+	}()
+
+	// Начатая отправка дорабатывает, несмотря на остановку приёма
+	close(h.release)
+
+	got := <-report
+	if got.TimedOut || got.Drained != 1 || got.Requeued != 2 {
+		t.Fatalf("report: got %+v, want 1 drained and 2 requeued", got)
+	}
+	if result := ackers[0].get(); result != "ack" {
+		t.Fatalf("in-flight message: got %s, want ack", result)
+	}
+	for i, a := range ackers[1:] {
+		if result := a.get(); result != "requeue" {
+			t.Fatalf("queued message %d: got %s, want requeue", i+1, result)
+		}
+	}
+}
+
+func TestDrainDeadlineRequeuesInFlight(t *testing.T) {
+	pool, _, ackers, cancel := startPool(t, 3)
+	cancel()
+
+	ctx, cancelDrain := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelDrain()
+
+	report := pool.Drain(ctx)
+	if !report.TimedOut || report.Drained != 0 || report.Requeued != 3 {
+		t.Fatalf("report: got %+v, want timed out with 3 requeued", report)
+	}
+	for i, a := range ackers {
+		if result := a.get(); result != "requeue" {
+			t.Fatalf("message %d: got %s, want requeue", i, result)
+		}
+	}
+}
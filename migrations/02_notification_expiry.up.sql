@@ -0,0 +1,3 @@
+ALTER TYPE notification_status ADD VALUE IF NOT EXISTS 'expired';
+
+ALTER TABLE notification ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
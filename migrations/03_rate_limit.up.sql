@@ -0,0 +1,7 @@
+ALTER TYPE notification_status ADD VALUE IF NOT EXISTS 'dropped';
+
+CREATE TYPE rate_limit_decision AS ENUM ('deferred', 'dropped');
+
+ALTER TABLE notification
+    ADD COLUMN IF NOT EXISTS rate_limit rate_limit_decision,
+    ADD COLUMN IF NOT EXISTS deferred_until TIMESTAMP;
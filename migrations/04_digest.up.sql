@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS digest (
+    id UUID PRIMARY KEY,
+    channel notification_channel NOT NULL,
+    recipient TEXT NOT NULL,
+    message TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+ALTER TABLE notification
+    ADD COLUMN IF NOT EXISTS digest BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS digest_id UUID REFERENCES digest(id);
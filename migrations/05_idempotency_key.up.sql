@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS idempotency_key (
+    key TEXT PRIMARY KEY,
+    request_hash TEXT NOT NULL,
+    notification_id UUID NOT NULL REFERENCES notification(id) DEFERRABLE INITIALLY DEFERRED,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_key_created_at_idx ON idempotency_key (created_at);
@@ -0,0 +1,3 @@
+CREATE TYPE notification_priority AS ENUM ('low', 'normal', 'high', 'critical');
+
+ALTER TABLE notification ADD COLUMN IF NOT EXISTS priority notification_priority NOT NULL DEFAULT 'normal';
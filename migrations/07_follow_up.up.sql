@@ -0,0 +1,17 @@
+ALTER TABLE notification ADD COLUMN IF NOT EXISTS parent_id UUID REFERENCES notification(id);
+
+CREATE INDEX IF NOT EXISTS notification_parent_id_idx ON notification (parent_id);
+
+CREATE TABLE IF NOT EXISTS notification_follow_up (
+    id UUID PRIMARY KEY,
+    parent_id UUID NOT NULL REFERENCES notification(id),
+    trigger notification_status NOT NULL,
+    delay_ms BIGINT NOT NULL,
+    message TEXT NOT NULL,
+    channel notification_channel NOT NULL,
+    recipient TEXT NOT NULL,
+    notification_id UUID REFERENCES notification(id),
+    canceled BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS notification_follow_up_parent_id_idx ON notification_follow_up (parent_id);
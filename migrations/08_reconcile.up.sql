@@ -0,0 +1,5 @@
+ALTER TABLE notification
+    ADD COLUMN IF NOT EXISTS last_attempt_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS republished_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS notification_status_scheduled_at_idx ON notification (status, scheduled_at);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    notification_id UUID NOT NULL REFERENCES notification(id) ON DELETE CASCADE,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    claimed_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS outbox_notification_id_idx ON outbox (notification_id);
@@ -0,0 +1,4 @@
+ALTER TABLE notification
+    ADD COLUMN IF NOT EXISTS retries INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS next_retry_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS last_error TEXT;
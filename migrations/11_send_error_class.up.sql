@@ -0,0 +1,3 @@
+ALTER TABLE notification
+    ADD COLUMN IF NOT EXISTS error_class TEXT,
+    ADD COLUMN IF NOT EXISTS provider_response TEXT;
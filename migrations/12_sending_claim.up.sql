@@ -0,0 +1,5 @@
+ALTER TYPE notification_status ADD VALUE IF NOT EXISTS 'sending';
+
+ALTER TABLE notification
+    ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS lease_until TIMESTAMP;
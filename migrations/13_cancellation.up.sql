@@ -0,0 +1,4 @@
+ALTER TABLE notification
+    ADD COLUMN IF NOT EXISTS canceled_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS canceled_by TEXT,
+    ADD COLUMN IF NOT EXISTS cancel_reason TEXT;
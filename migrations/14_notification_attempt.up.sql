@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS notification_attempt (
+    id BIGSERIAL PRIMARY KEY,
+    notification_id UUID NOT NULL REFERENCES notification(id),
+    attempted_at TIMESTAMP NOT NULL,
+    channel notification_channel NOT NULL,
+    recipient TEXT NOT NULL,
+    duration_ms BIGINT NOT NULL,
+    outcome TEXT NOT NULL,
+    error_class TEXT,
+    error TEXT,
+    provider_response TEXT,
+    digest BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS notification_attempt_notification_id_idx ON notification_attempt (notification_id, attempted_at);
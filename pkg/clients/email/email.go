@@ -1,9 +1,14 @@
 package email
 
 import (
+	"context"
+	"delayed-notifier/pkg/senderr"
+	"errors"
 	"fmt"
 	"net"
 	"net/smtp"
+	"net/textproto"
+	"strings"
 )
 
 type Config struct {
@@ -31,7 +36,11 @@ func New(smtpHost, smtpPort, username, password, from string) *Client {
 }
 
 // Send отправляет email с фиксированной темой "Notification" и переданным текстом.
-func (c Client) Send(message, recipient string) error {
+func (c Client) Send(ctx context.Context, message, recipient string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	addr := net.JoinHostPort(c.cfg.SMTPHost, c.cfg.SMTPPort)
 
 	subject := "Notification"
@@ -48,5 +57,34 @@ func (c Client) Send(message, recipient string) error {
 	auth := smtp.PlainAuth("", c.cfg.Username, c.cfg.Password, c.cfg.SMTPHost)
 	to := []string{recipient}
 
-	return smtp.SendMail(addr, auth, c.cfg.From, to, msg)
+	return classify(smtp.SendMail(addr, auth, c.cfg.From, to, msg))
+}
+
+// classify сопоставляет ответ SMTP-сервера классу ошибки отправки:
+// 4xx - временные (или лимит провайдера), 5xx - постоянные,
+// 550/551/553 и 5.1.x - нет такого получателя. Ошибки без кода ответа
+// (сеть, TLS) остаются как есть и считаются временными.
+func classify(err error) error {
+	var reply *textproto.Error
+	if err == nil || !errors.As(err, &reply) {
+		return err
+	}
+
+	response := fmt.Sprintf("%d %s", reply.Code, reply.Msg)
+	text := strings.ToLower(reply.Msg)
+
+	switch {
+	case reply.Code >= 400 && reply.Code < 500:
+		if strings.Contains(text, "rate") || strings.Contains(text, "too many") {
+			return senderr.NewRateLimited(0, response, err)
+		}
+		return senderr.New(senderr.Transient, response, err)
+	case strings.HasPrefix(reply.Msg, "5.1."):
+		return senderr.New(senderr.InvalidRecipient, response, err)
+	case (reply.Code == 550 || reply.Code == 551 || reply.Code == 553) && !strings.HasPrefix(reply.Msg, "5.7."):
+		// 5.7.x - отказ по политике (спам, аутентификация), а не отсутствие ящика
+		return senderr.New(senderr.InvalidRecipient, response, err)
+	default:
+		return senderr.New(senderr.Permanent, response, err)
+	}
 }
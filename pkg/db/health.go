@@ -0,0 +1,40 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// Health - доступность базы для проверок готовности. База пингуется не чаще
+// раза в interval, между проверками отдаётся последний результат.
+type Health struct {
+	db       *sql.DB
+	interval time.Duration
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	ready     bool
+}
+
+func NewHealth(db *sql.DB, interval time.Duration) *Health {
+	return &Health{db: db, interval: interval}
+}
+
+func (h *Health) Ready() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if time.Since(h.checkedAt) < h.interval {
+		return h.ready
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.interval)
+	defer cancel()
+
+	h.ready = h.db.PingContext(ctx) == nil
+	h.checkedAt = time.Now()
+
+	return h.ready
+}
@@ -0,0 +1,63 @@
+package senderr
+
+import (
+	"errors"
+	"time"
+)
+
+// Class - вид ошибки отправки, от которого зависит, стоит ли повторять попытку.
+type Class string
+
+const (
+	Transient        Class = "transient"         // временная ошибка, повтор может помочь
+	Permanent        Class = "permanent"         // повтор не поможет
+	RateLimited      Class = "rate_limited"      // провайдер просит подождать
+	InvalidRecipient Class = "invalid_recipient" // получателя не существует
+	Throttled        Class = "throttled"         // провайдер не вызывался: троттлер недоступен
+)
+
+// Error - ошибка отправки с классификацией и ответом провайдера.
+type Error struct {
+	Class      Class
+	RetryAfter time.Duration // для RateLimited, 0 - неизвестно
+	Response   string        // ответ провайдера как есть
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return string(e.Class) + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Retryable - имеет ли смысл повторять отправку.
+func (e *Error) Retryable() bool {
+	return e.Class == Transient || e.Class == RateLimited || e.Class == Throttled
+}
+
+func New(class Class, response string, err error) *Error {
+	return &Error{Class: class, Response: response, Err: err}
+}
+
+func NewRateLimited(retryAfter time.Duration, response string, err error) *Error {
+	return &Error{Class: RateLimited, RetryAfter: retryAfter, Response: response, Err: err}
+}
+
+// NewThrottled - отправка не состоялась до обращения к провайдеру,
+// повторить её стоит через retryAfter.
+func NewThrottled(retryAfter time.Duration, err error) *Error {
+	return &Error{Class: Throttled, RetryAfter: retryAfter, Err: err}
+}
+
+// Classify достаёт типизированную ошибку из цепочки. Ошибки без классификации
+// (сеть, таймауты) считаются временными.
+func Classify(err error) *Error {
+	var sendErr *Error
+	if errors.As(err, &sendErr) {
+		return sendErr
+	}
+
+	return &Error{Class: Transient, Err: err}
+}
@@ -0,0 +1,45 @@
+package senderr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	t.Run("unclassified errors are transient", func(t *testing.T) {
+		got := Classify(errors.New("connection reset"))
+		if got.Class != Transient {
+			t.Fatalf("class: got %s, want %s", got.Class, Transient)
+		}
+	})
+
+	t.Run("classification survives wrapping", func(t *testing.T) {
+		err := fmt.Errorf("send: %w", NewRateLimited(time.Minute, "450 slow down", errors.New("rate limited")))
+
+		got := Classify(err)
+		if got.Class != RateLimited || got.RetryAfter != time.Minute || got.Response != "450 slow down" {
+			t.Fatalf("got %+v", got)
+		}
+	})
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		class Class
+		want  bool
+	}{
+		{Transient, true},
+		{RateLimited, true},
+		{Throttled, true},
+		{Permanent, false},
+		{InvalidRecipient, false},
+	}
+
+	for _, tt := range tests {
+		if got := New(tt.class, "", errors.New("failed")).Retryable(); got != tt.want {
+			t.Errorf("%s: Retryable() = %v, want %v", tt.class, got, tt.want)
+		}
+	}
+}